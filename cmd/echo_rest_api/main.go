@@ -2,36 +2,143 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
+	_ "time/tzdata" // Embed the timezone database for images without one
 
+	"github.com/labstack/echo/v4"
+
+	"echo_rest_api/internal"
 	"echo_rest_api/server"
 )
 
 func main() {
-	// Instantiate the Echo REST server and database connection
-	e, db := server.InitServer()
+	// Run the requested maintenance command instead of the server, the server only taking flags
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "migrate":
+			migrate(os.Args[2:])
+		case "rollup-backfill":
+			rollupBackfill(os.Args[2:])
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command %q, available: migrate [up|down [steps]|status] [flags], rollup-backfill [from YYYY-MM-DD] [flags]\n", os.Args[1])
+			os.Exit(2)
+		}
+		return
+	}
+
+	// Instantiate the Echo REST server, admin and probe servers, and database connection
+	s := server.InitServer(os.Args[1:])
+	e, admin, probes := s.Echo, s.Admin, s.Probes
 
 	// Start the server
+	e.Logger.Infof("Starting the server on %s", e.Server.Addr)
 	go func() {
 		if err := e.Start(e.Server.Addr); err != nil {
 			e.Logger.Info(err)
 		}
 	}()
 
+	// Start the admin and probe servers
+	startSide("admin", admin)
+	startSide("probe", probes)
+
 	// Graceful shutdown of the server with a timeout
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
 	<-quit
 	e.Logger.Info("Gracefully shutting down the server")
+
+	// Report the server as not ready first, and give the load balancers time to stop sending requests
+	s.Health.Drain()
+	time.Sleep(s.DrainDelay)
+
+	// Finish the requests in progress, then close the DB connection they use
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	if err := db.Disconnect(context.TODO()); err != nil {
+	if err := e.Shutdown(ctx); err != nil {
 		e.Logger.Fatal(err)
 	}
-	if err := e.Shutdown(ctx); err != nil {
+	if err := s.DB.Disconnect(context.TODO()); err != nil {
 		e.Logger.Fatal(err)
 	}
+
+	// Flush the pending spans, including the ones of the last requests
+	tCtx, tCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer tCancel()
+	if err := s.ShutdownTracing(tCtx); err != nil {
+		e.Logger.Errorf("Failed to flush the traces: %s", err)
+	}
+
+	// Shut down the admin and probe servers last, so the metrics and the draining readiness stay available while the
+	// requests drain
+	shutdownSide(admin)
+	shutdownSide(probes)
+}
+
+// Start a server next to the public API, if enabled
+func startSide(name string, a *echo.Echo) {
+	if a == nil {
+		return
+	}
+
+	a.Logger.Infof("Starting the %s server on %s", name, a.Server.Addr)
+	go func() {
+		if err := a.Start(a.Server.Addr); err != nil {
+			a.Logger.Info(err)
+		}
+	}()
+}
+
+// Shut down a server next to the public API, if enabled
+func shutdownSide(a *echo.Echo) {
+	if a == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.Shutdown(ctx); err != nil {
+		a.Logger.Fatal(err)
+	}
+}
+
+// Create the logger of a maintenance command, with the log settings of the environment once it is read
+func commandLogger(name string, env *internal.Environ) *internal.Logger {
+	level, format := "info", internal.LogFormatJSON
+	if env != nil {
+		level, format = env.LogLevel, env.LogFormat
+	}
+
+	l, err := internal.NewLogger(level, format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to configure logging: %s\n", err)
+		os.Exit(1)
+	}
+	l.SetPrefix(name)
+
+	return l
+}
+
+// Split the arguments of a maintenance command into its own ones and the configuration flags, e.g. --config, which
+// all take a value
+func commandArgs(args []string) (own, flags []string) {
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			own = append(own, args[i])
+			continue
+		}
+
+		flags = append(flags, args[i])
+		if !strings.Contains(args[i], "=") && i+1 < len(args) {
+			i++
+			flags = append(flags, args[i])
+		}
+	}
+
+	return own, flags
 }
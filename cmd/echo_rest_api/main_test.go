@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"echo_rest_api/internal"
+)
+
+func TestCommandArgs(t *testing.T) {
+	tests := []struct {
+		args  []string
+		own   []string
+		flags []string
+	}{
+		{nil, nil, nil},
+		{[]string{"down", "2"}, []string{"down", "2"}, nil},
+		{[]string{"down", "2", "--config", "prod.yaml"}, []string{"down", "2"}, []string{"--config", "prod.yaml"}},
+		{[]string{"--config", "prod.yaml", "status"}, []string{"status"}, []string{"--config", "prod.yaml"}},
+		{[]string{"--config=prod.yaml", "up", "-db-name", "stats"}, []string{"up"}, []string{"--config=prod.yaml", "-db-name", "stats"}},
+	}
+	for _, tt := range tests {
+		own, flags := commandArgs(tt.args)
+		if !reflect.DeepEqual(own, tt.own) || !reflect.DeepEqual(flags, tt.flags) {
+			t.Errorf("%q: got %q %q, want %q %q", tt.args, own, flags, tt.own, tt.flags)
+		}
+	}
+}
+
+func TestCommandConfigFile(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "prod.yaml")
+	if err := ioutil.WriteFile(config, []byte(`
+port: 8080
+skip_checks: true
+secure: false
+secret_key: `+strings.Repeat("s", 32)+`
+jwt_exp: 72h
+db_uri: mongodb://prod:27017
+db_name: prod_db
+db_root_user: owner@local.com
+db_root_pass: prod-pass
+fe_endpoint: localhost:3000
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config file given to a maintenance command is read, whatever its position
+	args, flags := commandArgs([]string{"down", "--config", config, "2"})
+	env, err := internal.GetEnv(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.ConfigFile != config || env.DBUri != "mongodb://prod:27017" {
+		t.Errorf("got config file %q and DB URI %q, want the ones of %s", env.ConfigFile, env.DBUri, config)
+	}
+	if !reflect.DeepEqual(args, []string{"down", "2"}) {
+		t.Errorf("got command arguments %q, want down 2", args)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"echo_rest_api/database"
+	"echo_rest_api/database/model"
+	"echo_rest_api/internal"
+)
+
+// Apply the pending DB migrations, revert the given number of the latest ones, or list them all
+func migrate(args []string) {
+	l := commandLogger("migrate", nil)
+
+	// Take the configuration flags apart from the command arguments
+	args, flags := commandArgs(args)
+
+	// Parse the action
+	action, steps := "up", 1
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "up", "status":
+	case "down":
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				l.Fatalf("Invalid number of steps %q", args[1])
+			}
+		}
+	default:
+		l.Fatalf("Unknown action %q, expected up, down [steps] or status", action)
+	}
+
+	// Get configuration from environment, configured by the given flags on top of it
+	env, err := internal.GetEnv(flags)
+	if err != nil {
+		l.Fatalf("Failed to get configuration: %s", err)
+	}
+	l = commandLogger("migrate", env)
+
+	// Bound the DB operations
+	model.OperationTimeout, model.AggregationTimeout = env.DBTimeout, env.DBAggregateTimeout
+
+	// Create the database client & connection
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dbClient, dbConn := database.NewDBClientAndConnection(ctx, env, nil, l)
+	defer func() {
+		if err := dbClient.Disconnect(context.TODO()); err != nil {
+			l.Error(err)
+		}
+	}()
+
+	switch action {
+	case "up":
+		err = database.Migrate(context.Background(), dbConn, l)
+	case "down":
+		err = database.MigrateDown(context.Background(), dbConn, steps, l)
+	case "status":
+		rs, serr := database.MigrationStatus(context.Background(), dbConn)
+		for _, r := range rs {
+			state := "pending"
+			if !r.AppliedAt.IsZero() {
+				state = "applied " + r.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-32s %s\n", r.Version, r.Name, state)
+		}
+		err = serr
+	}
+	if err != nil {
+		l.Fatalf("Failed to migrate the DB: %s", err)
+	}
+}
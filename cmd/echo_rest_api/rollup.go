@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"echo_rest_api/database"
+	"echo_rest_api/database/model"
+	"echo_rest_api/internal"
+	"echo_rest_api/worker"
+)
+
+// Rebuild the stats rollups from the given day, or from the first day of data if none is given
+func rollupBackfill(args []string) {
+	l := commandLogger("rollup-backfill", nil)
+
+	// Take the configuration flags apart from the command arguments
+	args, flags := commandArgs(args)
+
+	// Parse the start date
+	var from time.Time
+	if len(args) > 0 {
+		var err error
+		if from, err = time.Parse("2006-01-02", args[0]); err != nil {
+			l.Fatalf("Invalid start date %q, expected YYYY-MM-DD: %s", args[0], err)
+		}
+	}
+
+	// Get configuration from environment, configured by the given flags on top of it
+	env, err := internal.GetEnv(flags)
+	if err != nil {
+		l.Fatalf("Failed to get configuration: %s", err)
+	}
+	l = commandLogger("rollup-backfill", env)
+
+	// Bound the DB operations
+	model.OperationTimeout, model.AggregationTimeout = env.DBTimeout, env.DBAggregateTimeout
+
+	// Create the database client & connection
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dbClient, dbConn := database.NewDBClientAndConnection(ctx, env, nil, l)
+	defer func() {
+		if err := dbClient.Disconnect(context.TODO()); err != nil {
+			l.Error(err)
+		}
+	}()
+
+	// Rebuild the rollups
+	w := &worker.Rollups{
+		DB:     dbConn,
+		Logger: l,
+	}
+	if err = w.Backfill(context.Background(), from); err != nil {
+		l.Fatalf("Failed to backfill the rollups: %s", err)
+	}
+
+	l.Info("Rollups backfilled")
+}
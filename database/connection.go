@@ -5,6 +5,7 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -15,8 +16,13 @@ import (
 )
 
 // NewDBClientAndConnection create new MongoDB client and connection objects
-func NewDBClientAndConnection(ctx context.Context, env *internal.Environ, logger echo.Logger) (*mongo.Client, *mongo.Database) {
-	dbClient, err := mongo.Connect(ctx, options.Client().ApplyURI(env.DBUri))
+func NewDBClientAndConnection(ctx context.Context, env *internal.Environ, metrics *internal.Metrics, logger echo.Logger) (*mongo.Client, *mongo.Database) {
+	// Trace and measure every DB command, and measure the connection pool
+	opts := options.Client().ApplyURI(env.DBUri).
+		SetMonitor(newCommandMonitor(metrics)).
+		SetPoolMonitor(&event.PoolMonitor{Event: metrics.DBPoolEvent})
+
+	dbClient, err := mongo.Connect(ctx, opts)
 	if err != nil {
 		logger.Fatalf("Failed to create DB client: %s", err)
 	}
@@ -31,7 +37,7 @@ func NewDBClientAndConnection(ctx context.Context, env *internal.Environ, logger
 	dbConn := dbClient.Database(env.DBName)
 
 	// Check for DB root account existence; create it if it does not exist
-	rootExists, err := model.UserFindRoot(dbConn, env.DBRootUser)
+	rootExists, err := model.UserFindRoot(ctx, dbConn, env.DBRootUser)
 	if err != nil {
 		logger.Fatalf("Failed to get root user: %s", err)
 	}
@@ -43,7 +49,7 @@ func NewDBClientAndConnection(ctx context.Context, env *internal.Environ, logger
 		}
 
 		// Hash and set the password field
-		p := security.HashPassword(env.DBRootPass, s)
+		p := security.HashPassword(ctx, env.DBRootPass, s)
 
 		// Create the new root user object
 		u := &model.User{
@@ -57,7 +63,7 @@ func NewDBClientAndConnection(ctx context.Context, env *internal.Environ, logger
 		}
 
 		// Create the user in the DB
-		err = model.UserCreateRoot(dbConn, u)
+		err = model.UserCreateRoot(ctx, dbConn, u)
 		if err != nil {
 			logger.Fatalf("Failed to create root user: %s", err)
 		}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"echo_rest_api/database/model"
+)
+
+const (
+	migrationLease = 10 * time.Minute // How long the lock is held without being extended
+	migrationRetry = 2 * time.Second  // How often a held lock is checked
+)
+
+// Get all the migrations in the order they are applied: the model ones, then the ones converting the stored data,
+// which log their progress
+func migrations(logger echo.Logger) []model.Migration {
+	return append(append([]model.Migration{}, model.Migrations...), model.Migration{
+		// Store the raw gate events and space results as time series
+		Version: 5,
+		Name:    "raw_data_time_series",
+		Up:      timeSeriesMigration(logger),
+	})
+}
+
+// Migrate applies all pending migrations in version order, while holding the migrations lock
+func Migrate(ctx context.Context, m *mongo.Database, logger echo.Logger) error {
+	return withMigrationLock(ctx, m, logger, func(extend func() error) error {
+		applied, err := appliedMigrations(ctx, m)
+		if err != nil {
+			return err
+		}
+
+		n := 0
+		all := migrations(logger)
+		for i := range all {
+			mg := &all[i]
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+
+			logger.Infof("Applying migration %d_%s", mg.Version, mg.Name)
+			if err = mg.Up(ctx, m); err != nil {
+				return fmt.Errorf("migration %d_%s failed: %w", mg.Version, mg.Name, err)
+			}
+			if err = model.MigrationSetApplied(ctx, m, mg, time.Now()); err != nil {
+				return err
+			}
+			n++
+
+			if err = extend(); err != nil {
+				return err
+			}
+		}
+
+		if n == 0 {
+			logger.Info("No pending migrations")
+		}
+
+		return nil
+	})
+}
+
+// MigrateDown reverts the given number of the latest applied migrations, while holding the migrations lock
+func MigrateDown(ctx context.Context, m *mongo.Database, steps int, logger echo.Logger) error {
+	return withMigrationLock(ctx, m, logger, func(extend func() error) error {
+		applied, err := appliedMigrations(ctx, m)
+		if err != nil {
+			return err
+		}
+
+		all := migrations(logger)
+		for i := len(all) - 1; i >= 0 && steps > 0; i-- {
+			mg := &all[i]
+			if _, ok := applied[mg.Version]; !ok {
+				continue
+			}
+
+			if mg.Down == nil {
+				return fmt.Errorf("migration %d_%s cannot be reverted", mg.Version, mg.Name)
+			}
+
+			logger.Infof("Reverting migration %d_%s", mg.Version, mg.Name)
+			if err = mg.Down(ctx, m); err != nil {
+				return fmt.Errorf("reverting migration %d_%s failed: %w", mg.Version, mg.Name, err)
+			}
+			if err = model.MigrationSetReverted(ctx, m, mg); err != nil {
+				return err
+			}
+			steps--
+
+			if err = extend(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// MigrationStatus gets all migrations in version order, the pending ones without an application date
+func MigrationStatus(ctx context.Context, m *mongo.Database) ([]model.MigrationRecord, error) {
+	applied, err := appliedMigrations(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	all := migrations(nil)
+	r := make([]model.MigrationRecord, len(all))
+	for i, mg := range all {
+		r[i] = model.MigrationRecord{Version: mg.Version, Name: mg.Name, AppliedAt: applied[mg.Version]}
+	}
+
+	return r, nil
+}
+
+// Get the application dates of the applied migrations, by version
+func appliedMigrations(ctx context.Context, m *mongo.Database) (map[int]time.Time, error) {
+	rs, err := model.MigrationGetApplied(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time, len(rs))
+	for _, r := range rs {
+		applied[r.Version] = r.AppliedAt
+	}
+
+	return applied, nil
+}
+
+// Run a function while holding the migrations lock, waiting for other instances to release it first; the function
+// extends the lock after every step, so it is not taken over while still running
+func withMigrationLock(ctx context.Context, m *mongo.Database, logger echo.Logger, fn func(extend func() error) error) error {
+	owner := primitive.NewObjectID().Hex()
+	extend := func() error {
+		ok, err := model.MigrationLock(ctx, m, owner, time.Now(), migrationLease)
+		if err == nil && !ok {
+			err = fmt.Errorf("the migrations lock was taken over by another instance")
+		}
+
+		return err
+	}
+
+	// Wait for the lock
+	for waiting := false; ; waiting = true {
+		ok, err := model.MigrationLock(ctx, m, owner, time.Now(), migrationLease)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+
+		if !waiting {
+			logger.Info("Waiting for another instance to finish migrating")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationRetry):
+		}
+	}
+
+	// Release the lock once done, even on failure or cancellation
+	defer func() {
+		if err := model.MigrationUnlock(context.Background(), m, owner); err != nil {
+			logger.Errorf("Failed to release the migrations lock: %s", err)
+		}
+	}()
+
+	return fn(extend)
+}
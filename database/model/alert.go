@@ -0,0 +1,398 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"echo_rest_api/internal"
+)
+
+type (
+	Alert struct {
+		ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+		Name      string             `bson:"name" json:"name" validate:"required"`
+		Type      string             `bson:"type" json:"type" validate:"required,oneof=occupancy gateSilent spaceStale"`
+		EntityID  primitive.ObjectID `bson:"entity_id" json:"entityId" validate:"required"`
+		Threshold float64            `bson:"threshold" json:"threshold"`
+		Minutes   int                `bson:"minutes" json:"minutes" validate:"required,min=1"`
+		Emails    []string           `bson:"emails" json:"emails" validate:"dive,email"`
+		Webhooks  []string           `bson:"webhooks" json:"webhooks" validate:"dive,url"`
+		Secret    string             `bson:"secret" json:"secret,omitempty" validate:"required_with=Webhooks,omitempty,min=16"`
+		Active    bool               `bson:"active" json:"active"`
+		CreatedBy primitive.ObjectID `bson:"created_by" json:"-"`
+		State     string             `bson:"state" json:"state"`
+		Since     *time.Time         `bson:"since,omitempty" json:"since,omitempty"`
+		Pending   *AlertNotification `bson:"pending,omitempty" json:"-"`
+	}
+
+	// AlertNotification is the last state change of an alert, while some of its channels are still to be notified
+	AlertNotification struct {
+		Event    AlertEvent `bson:"event"`
+		Channels []string   `bson:"channels"` // The email channel and the webhook URLs left to notify
+		Attempts int        `bson:"attempts"`
+	}
+
+	AlertEvent struct {
+		ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+		AlertID  primitive.ObjectID `bson:"alert_id" json:"alertId"`
+		Name     string             `bson:"name" json:"name"`
+		Type     string             `bson:"type" json:"type"`
+		EntityID primitive.ObjectID `bson:"entity_id" json:"entityId"`
+		State    string             `bson:"state" json:"state"`
+		Time     time.Time          `bson:"time" json:"time"`
+		Value    *float64           `bson:"value,omitempty" json:"value,omitempty"`
+		Errors   []string           `bson:"errors,omitempty" json:"errors,omitempty"`
+	}
+)
+
+const (
+	AlertTypeOccupancy  = "occupancy"  // The space count stays above the threshold for the given minutes
+	AlertTypeGateSilent = "gateSilent" // No events from the gate for the given minutes
+	AlertTypeSpaceStale = "spaceStale" // No results from the space for the given minutes
+)
+
+const (
+	AlertStateOK     = "ok"     // The alert condition is not met
+	AlertStateFiring = "firing" // The alert condition is met
+)
+
+const (
+	AlertChannelEmail = "email" // The notification channel of the alert emails
+)
+
+const (
+	alertsCollectionName      = "alerts"
+	alertEventsCollectionName = "alert_events"
+	maxAlertEvents            = 100
+)
+
+// AlertEvaluate checks if the condition of an alert is met at the given time, along with the value it was checked on
+func AlertEvaluate(ctx context.Context, m *mongo.Database, a *Alert, t time.Time) (bool, *float64, error) {
+	ctx, cancel := withAggregationTimeout(ctx)
+	defer cancel()
+
+	// Look at the data of the alert window only
+	coll, field := spaceResultsCollectionName, "space_id"
+	if a.Type == AlertTypeGateSilent {
+		coll, field = eventsCollectionName, "gate_id"
+	}
+
+	// Create a DB connection
+	db := m.Collection(coll)
+
+	cur, err := db.Aggregate(ctx, []bson.M{
+		{
+			"$match": bson.M{
+				field: a.EntityID,
+				"timestamp": bson.M{
+					"$gt":  t.Add(-time.Duration(a.Minutes) * time.Minute),
+					"$lte": t,
+				},
+			},
+		},
+		{
+			"$group": bson.M{
+				"_id":       nil,
+				"samples":   bson.M{"$sum": 1},
+				"min_count": bson.M{"$min": "$count"},
+			},
+		},
+	}, aggregateOptions())
+	if err != nil {
+		return false, nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	var res []struct {
+		Samples  int     `bson:"samples"`
+		MinCount float64 `bson:"min_count"`
+	}
+	if err = cur.All(ctx, &res); err != nil {
+		return false, nil, internal.NewError(internal.ErrDBDecode, err, 1)
+	}
+
+	samples, minCount := 0.0, 0.0
+	if len(res) > 0 {
+		samples, minCount = float64(res[0].Samples), res[0].MinCount
+	}
+
+	switch a.Type {
+	case AlertTypeOccupancy:
+		// The count must have stayed above the threshold in all the samples of the window
+		if samples == 0 {
+			return false, nil, nil
+		}
+		return minCount > a.Threshold, &minCount, nil
+	default:
+		return samples == 0, &samples, nil
+	}
+}
+
+// AlertCreate creates a new alert in the DB
+func AlertCreate(ctx context.Context, m *mongo.Database, a *Alert) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(alertsCollectionName)
+
+	// Add the alert to the DB
+	newAlert, err := db.InsertOne(ctx, a)
+	if err != nil {
+		return internal.NewError(internal.ErrDBInsert, err, 1)
+	}
+
+	// Save the ID of the new alert
+	a.ID = newAlert.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// AlertGet retrieves an alert based on the given ID from the DB
+func AlertGet(ctx context.Context, m *mongo.Database, id primitive.ObjectID) (*Alert, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	a := new(Alert)
+
+	// Create a DB connection
+	db := m.Collection(alertsCollectionName)
+
+	if err := db.FindOne(ctx, bson.M{"_id": id}).Decode(a); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, internal.NewError(internal.ErrDBNoData, err, 1)
+		}
+
+		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	return a, nil
+}
+
+// AlertGetAll retrieves all alerts from the DB
+func AlertGetAll(ctx context.Context, m *mongo.Database) ([]Alert, error) {
+	return alertFind(ctx, m, bson.M{})
+}
+
+// AlertGetActive retrieves all active alerts from the DB
+func AlertGetActive(ctx context.Context, m *mongo.Database) ([]Alert, error) {
+	return alertFind(ctx, m, bson.M{"active": true})
+}
+
+// Find the alerts matching a filter in the DB
+func alertFind(ctx context.Context, m *mongo.Database, filter bson.M) ([]Alert, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	a := []Alert{}
+
+	// Create a DB connection
+	db := m.Collection(alertsCollectionName)
+
+	cur, err := db.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, internal.NewError(internal.ErrDBQuery, err, 2)
+	}
+
+	// Decode all found information
+	for cur.Next(ctx) {
+		var elem Alert
+
+		err = cur.Decode(&elem)
+		if err != nil {
+			return nil, internal.NewError(internal.ErrDBDecode, err, 2)
+		}
+
+		a = append(a, elem)
+	}
+
+	// Check if any errors occurred
+	if err = cur.Err(); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorIterate, err, 2)
+	}
+
+	// Close the cursor once finished
+	if err = cur.Close(ctx); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorClose, err, 2)
+	}
+
+	return a, nil
+}
+
+// AlertUpdate updates the settings of a given alert in the DB, keeping its current state
+func AlertUpdate(ctx context.Context, m *mongo.Database, a *Alert) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(alertsCollectionName)
+
+	res, err := db.UpdateOne(ctx, bson.M{"_id": a.ID}, bson.M{
+		"$set": bson.M{
+			"name":      a.Name,
+			"type":      a.Type,
+			"entity_id": a.EntityID,
+			"threshold": a.Threshold,
+			"minutes":   a.Minutes,
+			"emails":    a.Emails,
+			"webhooks":  a.Webhooks,
+			"secret":    a.Secret,
+			"active":    a.Active,
+		},
+	})
+	if err != nil {
+		return internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	// If no alert was found, return an error
+	if res.MatchedCount == 0 {
+		return internal.NewError(internal.ErrDBNoUpdate, err, 1)
+	}
+
+	return nil
+}
+
+// AlertSetState changes the state of an alert in the DB, along with its notification to be sent, replacing the one of
+// the previous change if still pending; it fails if the state was already changed by another server
+func AlertSetState(ctx context.Context, m *mongo.Database, a *Alert, state string, t time.Time, n *AlertNotification) (bool, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(alertsCollectionName)
+
+	res, err := db.UpdateOne(ctx, bson.M{"_id": a.ID, "state": a.State}, bson.M{
+		"$set": bson.M{"state": state, "since": t, "pending": n},
+	})
+	if err != nil {
+		return false, internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	return res.ModifiedCount > 0, nil
+}
+
+// AlertSetPending replaces the pending notification of an alert in the DB, or removes it if nil; it fails if the
+// notification was already changed by another server, or replaced by a new state change
+func AlertSetPending(ctx context.Context, m *mongo.Database, a *Alert, n *AlertNotification) (bool, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(alertsCollectionName)
+
+	update := bson.M{"$unset": bson.M{"pending": ""}}
+	if n != nil {
+		update = bson.M{"$set": bson.M{"pending": n}}
+	}
+
+	res, err := db.UpdateOne(ctx, bson.M{
+		"_id":                a.ID,
+		"state":              a.State,
+		"pending.event.time": a.Pending.Event.Time,
+		"pending.attempts":   a.Pending.Attempts,
+	}, update)
+	if err != nil {
+		return false, internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	return res.ModifiedCount > 0, nil
+}
+
+// AlertDelete deletes an alert and its history based on the given ID in the DB
+func AlertDelete(ctx context.Context, m *mongo.Database, id primitive.ObjectID) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(alertsCollectionName)
+
+	// Delete the alert from the DB
+	res, err := db.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return internal.NewError(internal.ErrDBDelete, err, 1)
+	}
+
+	// If no alert was found, return an error
+	if res.DeletedCount == 0 {
+		return internal.NewError(internal.ErrDBNoData, err, 1)
+	}
+
+	// Delete the history of the alert
+	_, err = m.Collection(alertEventsCollectionName).DeleteMany(ctx, bson.M{"alert_id": id})
+	if err != nil {
+		return internal.NewError(internal.ErrDBDelete, err, 1)
+	}
+
+	return nil
+}
+
+// AlertEventCreate records a state change of an alert in the DB
+func AlertEventCreate(ctx context.Context, m *mongo.Database, e *AlertEvent) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(alertEventsCollectionName)
+
+	// Add the event to the DB
+	newEvent, err := db.InsertOne(ctx, e)
+	if err != nil {
+		return internal.NewError(internal.ErrDBInsert, err, 1)
+	}
+
+	// Save the ID of the new event
+	e.ID = newEvent.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// AlertEventGetAll retrieves the latest state changes of all alerts, or of a given one, from the DB
+func AlertEventGetAll(ctx context.Context, m *mongo.Database, id *primitive.ObjectID) ([]AlertEvent, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	e := []AlertEvent{}
+
+	// Create a DB connection
+	db := m.Collection(alertEventsCollectionName)
+
+	filter := bson.M{}
+	if id != nil {
+		filter["alert_id"] = *id
+	}
+
+	// Find the latest events first
+	opts := options.Find().SetSort(bson.M{"time": -1}).SetLimit(maxAlertEvents)
+	cur, err := db.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	// Decode all found information
+	for cur.Next(ctx) {
+		var elem AlertEvent
+
+		err = cur.Decode(&elem)
+		if err != nil {
+			return nil, internal.NewError(internal.ErrDBDecode, err, 1)
+		}
+
+		e = append(e, elem)
+	}
+
+	// Check if any errors occurred
+	if err = cur.Err(); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorIterate, err, 1)
+	}
+
+	// Close the cursor once finished
+	if err = cur.Close(ctx); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorClose, err, 1)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"echo_rest_api/internal"
+)
+
+const (
+	cursorsCollectionName = "cursors"
+)
+
+// CursorGet retrieves the position up to which a collection was already processed by a named tailer from the DB;
+// the zero ID is returned if it was never processed
+func CursorGet(ctx context.Context, m *mongo.Database, name string) (primitive.ObjectID, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	var c struct {
+		Last primitive.ObjectID `bson:"last_id"`
+	}
+
+	// Create a DB connection
+	db := m.Collection(cursorsCollectionName)
+
+	if err := db.FindOne(ctx, bson.M{"_id": name}).Decode(&c); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return primitive.NilObjectID, nil
+		}
+
+		return primitive.NilObjectID, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	return c.Last, nil
+}
+
+// CursorAdvance moves the position up to which a collection was processed by a named tailer in the DB, if still at the
+// given one; it fails if the position was already moved by another server
+func CursorAdvance(ctx context.Context, m *mongo.Database, name string, prev, last primitive.ObjectID) (bool, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(cursorsCollectionName)
+
+	filter := bson.M{"_id": name, "last_id": prev}
+	res, err := db.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_id": last}}, options.Update().SetUpsert(true))
+	if err != nil {
+		// The position was moved while inserting the first one
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+
+		return false, internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	return res.ModifiedCount > 0 || res.UpsertedCount > 0, nil
+}
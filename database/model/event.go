@@ -0,0 +1,92 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"echo_rest_api/internal"
+)
+
+type (
+	Event struct {
+		ID        primitive.ObjectID `bson:"_id" json:"id"`
+		GateID    primitive.ObjectID `bson:"gate_id" json:"gateId"`
+		Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
+		Crossed   int8               `bson:"crossed" json:"crossed"`
+	}
+
+	SpaceResult struct {
+		ID        primitive.ObjectID `bson:"_id" json:"id"`
+		SpaceID   primitive.ObjectID `bson:"space_id" json:"spaceId"`
+		Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
+		Count     float64            `bson:"count" json:"count"`
+	}
+)
+
+// EventGetAfter retrieves the gate events added after the given one from the DB, in insertion order
+func EventGetAfter(ctx context.Context, m *mongo.Database, id primitive.ObjectID, limit int64) ([]Event, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	e := []Event{}
+
+	// Create a DB connection
+	db := m.Collection(eventsCollectionName)
+
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(limit)
+	cur, err := db.Find(ctx, bson.M{"_id": bson.M{"$gt": id}}, opts)
+	if err != nil {
+		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	// Decode all found information
+	for cur.Next(ctx) {
+		var elem Event
+
+		err = cur.Decode(&elem)
+		if err != nil {
+			return nil, internal.NewError(internal.ErrDBDecode, err, 1)
+		}
+
+		e = append(e, elem)
+	}
+
+	// Check if any errors occurred
+	if err = cur.Err(); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorIterate, err, 1)
+	}
+
+	// Close the cursor once finished
+	if err = cur.Close(ctx); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorClose, err, 1)
+	}
+
+	return e, nil
+}
+
+// EventGetLastID retrieves the ID of the last added gate event from the DB; the zero ID is returned if there are none
+func EventGetLastID(ctx context.Context, m *mongo.Database) (primitive.ObjectID, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	var e Event
+
+	// Create a DB connection
+	db := m.Collection(eventsCollectionName)
+
+	opts := options.FindOne().SetSort(bson.M{"_id": -1}).SetProjection(bson.M{"_id": 1})
+	if err := db.FindOne(ctx, bson.M{}, opts).Decode(&e); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return primitive.NilObjectID, nil
+		}
+
+		return primitive.NilObjectID, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	return e.ID, nil
+}
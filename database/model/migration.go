@@ -0,0 +1,274 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"echo_rest_api/internal"
+)
+
+type (
+	// Migration is a versioned change of the DB schema, indexes or data; Down reverts Up, if possible
+	Migration struct {
+		Version int
+		Name    string
+		Up      func(ctx context.Context, m *mongo.Database) error
+		Down    func(ctx context.Context, m *mongo.Database) error
+	}
+
+	// MigrationRecord is an applied migration
+	MigrationRecord struct {
+		Version   int       `bson:"_id" json:"version"`
+		Name      string    `bson:"name" json:"name"`
+		AppliedAt time.Time `bson:"applied_at" json:"appliedAt"`
+	}
+)
+
+const (
+	migrationsCollectionName = "migrations"
+	migrationLockID          = "lock"
+)
+
+var (
+	// Migrations holds all the migrations, in the order they are applied
+	Migrations = []Migration{
+		{
+			// Close the race between checking an invited email and adding its user
+			Version: 1,
+			Name:    "users_email_unique",
+			Up: migrationCreateIndex(usersCollectionName, mongo.IndexModel{
+				Keys:    bson.M{"email": 1},
+				Options: options.Index().SetName("email_unique").SetUnique(true),
+			}),
+			Down: migrationDropIndex(usersCollectionName, "email_unique"),
+		},
+		{
+			// The token is removed once the invite is used
+			Version: 2,
+			Name:    "users_invite_token_unique",
+			Up: migrationCreateIndex(usersCollectionName, mongo.IndexModel{
+				Keys:    bson.M{"invite_token": 1},
+				Options: options.Index().SetName("invite_token_unique").SetUnique(true).SetSparse(true),
+			}),
+			Down: migrationDropIndex(usersCollectionName, "invite_token_unique"),
+		},
+		{
+			// Only the new and updated users are validated, so invalid ones already in the DB can still be fixed
+			Version: 3,
+			Name:    "users_validator",
+			Up: migrationSetValidator(usersCollectionName, bson.M{
+				"$jsonSchema": bson.M{
+					"bsonType": "object",
+					"required": []string{"email", "role", "active"},
+					"properties": bson.M{
+						"email":        bson.M{"bsonType": "string", "pattern": "^[^@\\s]+@[^@\\s]+$"},
+						"role":         bson.M{"enum": []string{"admin", "user"}},
+						"active":       bson.M{"bsonType": "bool"},
+						"invite_token": bson.M{"bsonType": "string"},
+					},
+				},
+			}),
+			Down: migrationSetValidator(usersCollectionName, bson.M{}),
+		},
+		{
+			// Index the queries of the background workers and of the delivery histories
+			Version: 4,
+			Name:    "worker_indexes",
+			Up: func(ctx context.Context, m *mongo.Database) error {
+				for _, idx := range workerIndexes {
+					if err := migrationCreateIndex(idx.collection, idx.model)(ctx, m); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Down: func(ctx context.Context, m *mongo.Database) error {
+				for _, idx := range workerIndexes {
+					if err := migrationDropIndex(idx.collection, *idx.model.Options.Name)(ctx, m); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+	}
+
+	workerIndexes = []struct {
+		collection string
+		model      mongo.IndexModel
+	}{
+		{reportsCollectionName, mongo.IndexModel{
+			Keys:    bson.D{{Key: "active", Value: 1}, {Key: "next_run", Value: 1}},
+			Options: options.Index().SetName("active_next_run"),
+		}},
+		{reportDeliveriesCollectionName, mongo.IndexModel{
+			Keys:    bson.D{{Key: "report_id", Value: 1}, {Key: "time", Value: -1}},
+			Options: options.Index().SetName("report_id_time"),
+		}},
+		{alertEventsCollectionName, mongo.IndexModel{
+			Keys:    bson.D{{Key: "alert_id", Value: 1}, {Key: "time", Value: -1}},
+			Options: options.Index().SetName("alert_id_time"),
+		}},
+		{webhookOutboxCollectionName, mongo.IndexModel{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "next_attempt", Value: 1}},
+			Options: options.Index().SetName("status_next_attempt"),
+		}},
+		{webhookDeliveriesCollectionName, mongo.IndexModel{
+			Keys:    bson.D{{Key: "webhook_id", Value: 1}, {Key: "time", Value: -1}},
+			Options: options.Index().SetName("webhook_id_time"),
+		}},
+	}
+)
+
+// MigrationGetApplied retrieves the applied migrations from the DB, in version order
+func MigrationGetApplied(ctx context.Context, m *mongo.Database) ([]MigrationRecord, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	var r []MigrationRecord
+
+	// Create a DB connection
+	db := m.Collection(migrationsCollectionName)
+
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	cur, err := db.Find(ctx, bson.M{"_id": bson.M{"$ne": migrationLockID}}, opts)
+	if err != nil {
+		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	// Decode all found information
+	if err = cur.All(ctx, &r); err != nil {
+		return nil, internal.NewError(internal.ErrDBDecode, err, 1)
+	}
+
+	return r, nil
+}
+
+// MigrationSetApplied records a migration as applied in the DB
+func MigrationSetApplied(ctx context.Context, m *mongo.Database, mg *Migration, t time.Time) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(migrationsCollectionName)
+
+	r := &MigrationRecord{Version: mg.Version, Name: mg.Name, AppliedAt: t}
+	if _, err := db.InsertOne(ctx, r); err != nil {
+		return internal.NewError(internal.ErrDBInsert, err, 1)
+	}
+
+	return nil
+}
+
+// MigrationSetReverted removes the record of an applied migration from the DB
+func MigrationSetReverted(ctx context.Context, m *mongo.Database, mg *Migration) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(migrationsCollectionName)
+
+	if _, err := db.DeleteOne(ctx, bson.M{"_id": mg.Version}); err != nil {
+		return internal.NewError(internal.ErrDBDelete, err, 1)
+	}
+
+	return nil
+}
+
+// MigrationLock takes or extends the migrations lock for the lease duration in the DB; it fails if the lock is held
+// by another owner and has not expired yet
+func MigrationLock(ctx context.Context, m *mongo.Database, owner string, t time.Time, lease time.Duration) (bool, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(migrationsCollectionName)
+
+	filter := bson.M{
+		"_id": migrationLockID,
+		"$or": []bson.M{
+			{"owner": owner},
+			{"expires_at": bson.M{"$lte": t}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": t.Add(lease)}}
+	res, err := db.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		// The lock is held by another owner
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+
+		return false, internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	return res.MatchedCount > 0 || res.UpsertedCount > 0, nil
+}
+
+// MigrationUnlock releases the migrations lock in the DB, if still held by the given owner
+func MigrationUnlock(ctx context.Context, m *mongo.Database, owner string) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(migrationsCollectionName)
+
+	if _, err := db.DeleteOne(ctx, bson.M{"_id": migrationLockID, "owner": owner}); err != nil {
+		return internal.NewError(internal.ErrDBDelete, err, 1)
+	}
+
+	return nil
+}
+
+// Build a migration step creating an index
+func migrationCreateIndex(coll string, idx mongo.IndexModel) func(ctx context.Context, m *mongo.Database) error {
+	return func(ctx context.Context, m *mongo.Database) error {
+		if _, err := m.Collection(coll).Indexes().CreateOne(ctx, idx); err != nil {
+			return internal.NewError(internal.ErrDBIndex, err, 1)
+		}
+
+		return nil
+	}
+}
+
+// Build a migration step dropping an index
+func migrationDropIndex(coll string, name string) func(ctx context.Context, m *mongo.Database) error {
+	return func(ctx context.Context, m *mongo.Database) error {
+		if _, err := m.Collection(coll).Indexes().DropOne(ctx, name); err != nil {
+			return internal.NewError(internal.ErrDBIndex, err, 1)
+		}
+
+		return nil
+	}
+}
+
+// Build a migration step setting the validator of a collection, creating it if missing; an empty one removes it
+func migrationSetValidator(coll string, validator bson.M) func(ctx context.Context, m *mongo.Database) error {
+	return func(ctx context.Context, m *mongo.Database) error {
+		specs, err := m.ListCollectionSpecifications(ctx, bson.M{"name": coll})
+		if err != nil {
+			return internal.NewError(internal.ErrDBQuery, err, 1)
+		}
+
+		cmd := bson.D{{Key: "collMod", Value: coll}}
+		if len(specs) == 0 {
+			cmd = bson.D{{Key: "create", Value: coll}}
+		}
+		cmd = append(cmd,
+			bson.E{Key: "validator", Value: validator},
+			bson.E{Key: "validationLevel", Value: "moderate"},
+		)
+
+		if err = m.RunCommand(ctx, cmd).Err(); err != nil {
+			return internal.NewError(internal.ErrDBUpdate, err, 1)
+		}
+
+		return nil
+	}
+}
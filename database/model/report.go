@@ -0,0 +1,322 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"echo_rest_api/internal"
+)
+
+type (
+	Report struct {
+		ID           primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+		Name         string               `bson:"name" json:"name" validate:"required"`
+		Schedule     string               `bson:"schedule" json:"schedule" validate:"required,cron"`
+		Timezone     string               `bson:"timezone" json:"timezone" validate:"required,timezone"`
+		Recipients   []string             `bson:"recipients" json:"recipients" validate:"required,min=1,dive,email"`
+		Location     string               `bson:"location" json:"location" validate:"required,oneof=gate space"`
+		IDs          []primitive.ObjectID `bson:"ids" json:"ids" validate:"required,min=1"`
+		PerEntity    bool                 `bson:"per_entity" json:"perEntity"`
+		IsInside     bool                 `bson:"is_inside" json:"isInside"`
+		Period       string               `bson:"period" json:"period" validate:"required,oneof=day week month quarter year"`
+		IntervalType string               `bson:"interval_type" json:"intervalType" validate:"required,oneof=hour day week month"`
+		Metrics      []string             `bson:"metrics" json:"metrics"`
+		Capacity     float64              `bson:"capacity,omitempty" json:"capacity,omitempty" validate:"gte=0"`
+		Format       string               `bson:"format" json:"format" validate:"omitempty,oneof=csv xlsx parquet"`
+		Active       bool                 `bson:"active" json:"active"`
+		CreatedBy    primitive.ObjectID   `bson:"created_by" json:"-"`
+		NextRun      time.Time            `bson:"next_run" json:"nextRun"`
+		LastRun      *time.Time           `bson:"last_run,omitempty" json:"lastRun,omitempty"`
+	}
+
+	ReportDelivery struct {
+		ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+		ReportID   primitive.ObjectID `bson:"report_id" json:"reportId"`
+		Time       time.Time          `bson:"time" json:"time"`
+		Start      time.Time          `bson:"start" json:"start"`
+		End        time.Time          `bson:"end" json:"end"`
+		Recipients []string           `bson:"recipients" json:"recipients"`
+		Success    bool               `bson:"success" json:"success"`
+		Error      string             `bson:"error,omitempty" json:"error,omitempty"`
+	}
+)
+
+const (
+	reportsCollectionName          = "reports"
+	reportDeliveriesCollectionName = "report_deliveries"
+	maxReportDeliveries            = 100
+)
+
+// StatsQuery builds the stats query of the last complete report period before the given time
+func (r *Report) StatsQuery(t time.Time, densify bool) (*Stats, error) {
+	tz, err := internal.LoadTimezone(r.Timezone)
+	if err != nil {
+		return nil, internal.NewError(internal.ErrBEQPInvalidTimezone, err, 1)
+	}
+
+	// The period ends where the current one starts (the stats end date is inclusive)
+	p := StatsInterval{Type: r.Period}
+	end := bucketStart(t.In(tz), p)
+	start := bucketStart(end.Add(-time.Nanosecond), p)
+
+	return &Stats{
+		IDs:       r.IDs,
+		PerEntity: r.PerEntity,
+		Location:  r.Location,
+		IsInside:  r.IsInside,
+		Start:     start,
+		End:       end.Add(-time.Millisecond),
+		Timezone:  tz.String(),
+		TZ:        tz,
+		Interval:  StatsInterval{Type: r.IntervalType},
+		Fill:      StatsFillZero,
+		Metrics:   r.Metrics,
+		Capacity:  r.Capacity,
+		Densify:   densify,
+		Series:    []StatsSeries{},
+	}, nil
+}
+
+// ReportCreate creates a new report in the DB
+func ReportCreate(ctx context.Context, m *mongo.Database, r *Report) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(reportsCollectionName)
+
+	// Add the report to the DB
+	newReport, err := db.InsertOne(ctx, r)
+	if err != nil {
+		return internal.NewError(internal.ErrDBInsert, err, 1)
+	}
+
+	// Save the ID of the new report
+	r.ID = newReport.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// ReportGet retrieves a report based on the given ID from the DB
+func ReportGet(ctx context.Context, m *mongo.Database, id primitive.ObjectID) (*Report, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	r := new(Report)
+
+	// Create a DB connection
+	db := m.Collection(reportsCollectionName)
+
+	if err := db.FindOne(ctx, bson.M{"_id": id}).Decode(r); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, internal.NewError(internal.ErrDBNoData, err, 1)
+		}
+
+		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	return r, nil
+}
+
+// ReportGetAll retrieves all reports from the DB
+func ReportGetAll(ctx context.Context, m *mongo.Database) ([]Report, error) {
+	return reportFind(ctx, m, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+}
+
+// ReportGetDue retrieves all active reports scheduled up to the given time from the DB
+func ReportGetDue(ctx context.Context, m *mongo.Database, t time.Time) ([]Report, error) {
+	return reportFind(ctx, m, bson.M{"active": true, "next_run": bson.M{"$lte": t}}, options.Find().SetSort(bson.M{"next_run": 1}))
+}
+
+// Find the reports matching a filter in the DB
+func reportFind(ctx context.Context, m *mongo.Database, filter bson.M, opts *options.FindOptions) ([]Report, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	r := []Report{}
+
+	// Create a DB connection
+	db := m.Collection(reportsCollectionName)
+
+	cur, err := db.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, internal.NewError(internal.ErrDBQuery, err, 2)
+	}
+
+	// Decode all found information
+	for cur.Next(ctx) {
+		var elem Report
+
+		err = cur.Decode(&elem)
+		if err != nil {
+			return nil, internal.NewError(internal.ErrDBDecode, err, 2)
+		}
+
+		r = append(r, elem)
+	}
+
+	// Check if any errors occurred
+	if err = cur.Err(); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorIterate, err, 2)
+	}
+
+	// Close the cursor once finished
+	if err = cur.Close(ctx); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorClose, err, 2)
+	}
+
+	return r, nil
+}
+
+// ReportUpdate updates the settings of a given report in the DB
+func ReportUpdate(ctx context.Context, m *mongo.Database, r *Report) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(reportsCollectionName)
+
+	// Replace all settings, keeping the creator and the last run
+	res, err := db.UpdateOne(ctx, bson.M{"_id": r.ID}, bson.M{
+		"$set": bson.M{
+			"name":          r.Name,
+			"schedule":      r.Schedule,
+			"timezone":      r.Timezone,
+			"recipients":    r.Recipients,
+			"location":      r.Location,
+			"ids":           r.IDs,
+			"per_entity":    r.PerEntity,
+			"is_inside":     r.IsInside,
+			"period":        r.Period,
+			"interval_type": r.IntervalType,
+			"metrics":       r.Metrics,
+			"capacity":      r.Capacity,
+			"format":        r.Format,
+			"active":        r.Active,
+			"next_run":      r.NextRun,
+		},
+	})
+	if err != nil {
+		return internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	// If no report was found, return an error
+	if res.MatchedCount == 0 {
+		return internal.NewError(internal.ErrDBNoUpdate, err, 1)
+	}
+
+	return nil
+}
+
+// ReportClaim moves a due report to its next run in the DB; it fails if the report was already claimed by another server
+func ReportClaim(ctx context.Context, m *mongo.Database, r *Report, next time.Time, t time.Time) (bool, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(reportsCollectionName)
+
+	res, err := db.UpdateOne(ctx, bson.M{"_id": r.ID, "next_run": r.NextRun}, bson.M{
+		"$set": bson.M{"next_run": next, "last_run": t},
+	})
+	if err != nil {
+		return false, internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	return res.ModifiedCount > 0, nil
+}
+
+// ReportDelete deletes a report and its delivery history based on the given ID in the DB
+func ReportDelete(ctx context.Context, m *mongo.Database, id primitive.ObjectID) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(reportsCollectionName)
+
+	// Delete the report from the DB
+	res, err := db.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return internal.NewError(internal.ErrDBDelete, err, 1)
+	}
+
+	// If no report was found, return an error
+	if res.DeletedCount == 0 {
+		return internal.NewError(internal.ErrDBNoData, err, 1)
+	}
+
+	// Delete the delivery history of the report
+	_, err = m.Collection(reportDeliveriesCollectionName).DeleteMany(ctx, bson.M{"report_id": id})
+	if err != nil {
+		return internal.NewError(internal.ErrDBDelete, err, 1)
+	}
+
+	return nil
+}
+
+// ReportDeliveryCreate records a report delivery in the DB
+func ReportDeliveryCreate(ctx context.Context, m *mongo.Database, d *ReportDelivery) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(reportDeliveriesCollectionName)
+
+	// Add the delivery to the DB
+	newDelivery, err := db.InsertOne(ctx, d)
+	if err != nil {
+		return internal.NewError(internal.ErrDBInsert, err, 1)
+	}
+
+	// Save the ID of the new delivery
+	d.ID = newDelivery.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// ReportDeliveryGetAll retrieves the latest deliveries of a given report from the DB
+func ReportDeliveryGetAll(ctx context.Context, m *mongo.Database, id primitive.ObjectID) ([]ReportDelivery, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	d := []ReportDelivery{}
+
+	// Create a DB connection
+	db := m.Collection(reportDeliveriesCollectionName)
+
+	// Find the latest deliveries first
+	opts := options.Find().SetSort(bson.M{"time": -1}).SetLimit(maxReportDeliveries)
+	cur, err := db.Find(ctx, bson.M{"report_id": id}, opts)
+	if err != nil {
+		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	// Decode all found information
+	for cur.Next(ctx) {
+		var elem ReportDelivery
+
+		err = cur.Decode(&elem)
+		if err != nil {
+			return nil, internal.NewError(internal.ErrDBDecode, err, 1)
+		}
+
+		d = append(d, elem)
+	}
+
+	// Check if any errors occurred
+	if err = cur.Err(); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorIterate, err, 1)
+	}
+
+	// Close the cursor once finished
+	if err = cur.Close(ctx); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorClose, err, 1)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,402 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"echo_rest_api/internal"
+)
+
+type (
+	// RollupChanges holds the entities and UTC hours touched by a batch of raw documents
+	RollupChanges struct {
+		IDs   []primitive.ObjectID
+		Hours []time.Time
+		Last  primitive.ObjectID
+		Count int // The raw documents of the batch
+	}
+
+	// The collections and fields of the rollups of a stats location
+	rollupLocation struct {
+		raw    string
+		field  string
+		hourly string
+		daily  string
+		fields []string
+	}
+)
+
+const (
+	eventsHourlyCollectionName       = "events_hourly"
+	eventsDailyCollectionName        = "events_daily"
+	spaceResultsHourlyCollectionName = "space_results_hourly"
+	spaceResultsDailyCollectionName  = "space_results_daily"
+	rollupsCollectionName            = "rollups"
+)
+
+var (
+	// The gate rollups count the crossings in each direction, with the highest number of them in a minute;
+	// the space rollups keep the extreme counts, with their sum and number of samples for the average
+	rollupLocations = map[string]rollupLocation{
+		StatsLocationGate: {
+			raw:    eventsCollectionName,
+			field:  "gate_id",
+			hourly: eventsHourlyCollectionName,
+			daily:  eventsDailyCollectionName,
+			fields: []string{"in", "out", "peak_in", "peak_out"},
+		},
+		StatsLocationSpace: {
+			raw:    spaceResultsCollectionName,
+			field:  "space_id",
+			hourly: spaceResultsHourlyCollectionName,
+			daily:  spaceResultsDailyCollectionName,
+			fields: []string{"max", "min", "sum", "samples"},
+		},
+	}
+
+	// RollupLocations holds all the stats locations having rollups
+	RollupLocations = []string{StatsLocationGate, StatsLocationSpace}
+)
+
+// RollupEnsureIndexes creates the unique indexes the rollups are merged on in the DB, if missing
+func RollupEnsureIndexes(ctx context.Context, m *mongo.Database) error {
+	for _, l := range rollupLocations {
+		for _, c := range []string{l.hourly, l.daily} {
+			// Create a DB connection
+			db := m.Collection(c)
+
+			idx := mongo.IndexModel{
+				Keys:    bson.D{{Key: l.field, Value: 1}, {Key: "timestamp", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			}
+			if _, err := db.Indexes().CreateOne(ctx, idx); err != nil {
+				return internal.NewError(internal.ErrDBIndex, err, 1)
+			}
+		}
+	}
+
+	return nil
+}
+
+// RollupGetChanges retrieves the entities and hours touched by the raw documents of a location added after the given one
+// from the DB, in ID order; nil is returned if there are none
+func RollupGetChanges(ctx context.Context, m *mongo.Database, location string, id primitive.ObjectID, limit int64) (*RollupChanges, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	l := rollupLocations[location]
+
+	// Create a DB connection
+	db := m.Collection(l.raw)
+
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(limit).SetProjection(bson.M{l.field: 1, "timestamp": 1})
+	cur, err := db.Find(ctx, bson.M{"_id": bson.M{"$gt": id}}, opts)
+	if err != nil {
+		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	// Collect the distinct entities and hours of all found documents
+	var ch *RollupChanges
+	ids, hours := map[primitive.ObjectID]bool{}, map[time.Time]bool{}
+	for cur.Next(ctx) {
+		var elem struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			GateID    primitive.ObjectID `bson:"gate_id"`
+			SpaceID   primitive.ObjectID `bson:"space_id"`
+			Timestamp time.Time          `bson:"timestamp"`
+		}
+
+		err = cur.Decode(&elem)
+		if err != nil {
+			_ = cur.Close(ctx)
+			return nil, internal.NewError(internal.ErrDBDecode, err, 1)
+		}
+
+		if ch == nil {
+			ch = &RollupChanges{}
+		}
+		ch.Last = elem.ID
+		ch.Count++
+
+		e := elem.GateID
+		if location == StatsLocationSpace {
+			e = elem.SpaceID
+		}
+		if !ids[e] {
+			ids[e] = true
+			ch.IDs = append(ch.IDs, e)
+		}
+
+		h := elem.Timestamp.UTC().Truncate(time.Hour)
+		if !hours[h] {
+			hours[h] = true
+			ch.Hours = append(ch.Hours, h)
+		}
+	}
+
+	// Check if any errors occurred
+	if err = cur.Err(); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorIterate, err, 1)
+	}
+
+	// Close the cursor once finished
+	if err = cur.Close(ctx); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorClose, err, 1)
+	}
+
+	return ch, nil
+}
+
+// RollupGetLastID retrieves the ID of the last added raw document of a location from the DB; the zero ID is returned
+// if there are none
+func RollupGetLastID(ctx context.Context, m *mongo.Database, location string) (primitive.ObjectID, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	var d struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+
+	// Create a DB connection
+	db := m.Collection(rollupLocations[location].raw)
+
+	opts := options.FindOne().SetSort(bson.M{"_id": -1}).SetProjection(bson.M{"_id": 1})
+	if err := db.FindOne(ctx, bson.M{}, opts).Decode(&d); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return primitive.NilObjectID, nil
+		}
+
+		return primitive.NilObjectID, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	return d.ID, nil
+}
+
+// RollupGetFirstTime retrieves the time of the earliest raw document of a location from the DB; the zero time is
+// returned if there are none
+func RollupGetFirstTime(ctx context.Context, m *mongo.Database, location string) (time.Time, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	var d struct {
+		Timestamp time.Time `bson:"timestamp"`
+	}
+
+	// Create a DB connection
+	db := m.Collection(rollupLocations[location].raw)
+
+	opts := options.FindOne().SetSort(bson.M{"timestamp": 1}).SetProjection(bson.M{"timestamp": 1})
+	if err := db.FindOne(ctx, bson.M{}, opts).Decode(&d); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	return d.Timestamp, nil
+}
+
+// RollupRefresh recomputes from the raw data the hourly rollups of the given entities and UTC hours, then the daily
+// rollups of their days, in the DB; all entities are recomputed if none are given
+func RollupRefresh(ctx context.Context, m *mongo.Database, location string, ids []primitive.ObjectID, hours []time.Time) error {
+	l := rollupLocations[location]
+	if len(hours) == 0 {
+		return nil
+	}
+
+	// Recompute the hourly rollups from the raw documents
+	var days []time.Time
+	seen := map[time.Time]bool{}
+	for _, h := range hours {
+		if d := h.Truncate(24 * time.Hour); !seen[d] {
+			seen[d] = true
+			days = append(days, d)
+		}
+	}
+
+	if err := rollupMerge(ctx, m, location, rollupFilter(l, ids, hours, time.Hour), false); err != nil {
+		return err
+	}
+
+	// Recompute the daily rollups from the hourly ones
+	return rollupMerge(ctx, m, location, rollupFilter(l, ids, days, 24*time.Hour), true)
+}
+
+// RollupGetCoverage retrieves the date from which the rollups of a location are complete from the DB; the zero time
+// is returned if they were never built
+func RollupGetCoverage(ctx context.Context, m *mongo.Database, location string) (time.Time, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	var r struct {
+		From time.Time `bson:"covered_from"`
+	}
+
+	// Create a DB connection
+	db := m.Collection(rollupsCollectionName)
+
+	if err := db.FindOne(ctx, bson.M{"_id": location}).Decode(&r); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	return r.From, nil
+}
+
+// RollupCover extends the rollups coverage of a location back to the given date in the DB, if not already covered
+func RollupCover(ctx context.Context, m *mongo.Database, location string, from time.Time) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(rollupsCollectionName)
+
+	update := bson.M{"$min": bson.M{"covered_from": from}}
+	if _, err := db.UpdateOne(ctx, bson.M{"_id": location}, update, options.Update().SetUpsert(true)); err != nil {
+		return internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	return nil
+}
+
+// Build the filter of the documents of the given entities within the given periods
+func rollupFilter(l rollupLocation, ids []primitive.ObjectID, starts []time.Time, d time.Duration) bson.M {
+	periods := make([]bson.M, len(starts))
+	for i, s := range starts {
+		periods[i] = bson.M{"timestamp": bson.M{"$gte": s, "$lt": s.Add(d)}}
+	}
+
+	filter := bson.M{"$or": periods}
+	if ids != nil {
+		filter[l.field] = bson.M{"$in": ids}
+	}
+
+	return filter
+}
+
+// Aggregate the matching raw documents into hourly rollups, or the matching hourly rollups into daily ones, and
+// replace the ones already in the DB
+func rollupMerge(ctx context.Context, m *mongo.Database, location string, filter bson.M, daily bool) error {
+	ctx, cancel := withAggregationTimeout(ctx)
+	defer cancel()
+
+	l := rollupLocations[location]
+	src, dst, unit := l.raw, l.hourly, hour
+	if daily {
+		src, dst, unit = l.hourly, l.daily, day
+	}
+
+	pipeline := []bson.M{
+		{"$match": filter},
+	}
+
+	// Convert the raw documents into minute-long rollups first
+	if !daily {
+		switch location {
+		case StatsLocationGate:
+			pipeline = append(pipeline,
+				bson.M{
+					"$group": bson.M{
+						"_id": bson.M{entity: "$gate_id", "timestamp": rollupTrunc("$timestamp", minute)},
+						"in":  statsCrossings(1),
+						"out": statsCrossings(-1),
+					},
+				},
+				bson.M{
+					"$project": bson.M{
+						"_id":       0,
+						"gate_id":   "$_id." + entity,
+						"timestamp": "$_id.timestamp",
+						"in":        1,
+						"out":       1,
+						"peak_in":   "$in",
+						"peak_out":  "$out",
+					},
+				},
+			)
+		case StatsLocationSpace:
+			// The negative sensor counts are clamped to 0
+			count := bson.M{"$max": []interface{}{"$count", 0}}
+			pipeline = append(pipeline, bson.M{
+				"$project": bson.M{
+					"_id":       0,
+					"space_id":  1,
+					"timestamp": 1,
+					"max":       count,
+					"min":       count,
+					"sum":       count,
+					"samples":   bson.M{"$literal": 1},
+				},
+			})
+		}
+	}
+
+	// Merge the rollups into the longer ones
+	group := bson.M{"_id": bson.M{entity: "$" + l.field, "timestamp": rollupTrunc("$timestamp", unit)}}
+	project := bson.M{"_id": 0, l.field: "$_id." + entity, "timestamp": "$_id.timestamp"}
+	for _, f := range l.fields {
+		switch f {
+		case "peak_in", "peak_out", "max":
+			group[f] = bson.M{"$max": "$" + f}
+		case "min":
+			group[f] = bson.M{"$min": "$" + f}
+		default:
+			group[f] = bson.M{"$sum": "$" + f}
+		}
+		project[f] = 1
+	}
+
+	pipeline = append(pipeline,
+		bson.M{"$group": group},
+		bson.M{"$project": project},
+		bson.M{
+			"$merge": bson.M{
+				"into":           dst,
+				"on":             []string{l.field, "timestamp"},
+				"whenMatched":    "replace",
+				"whenNotMatched": "insert",
+			},
+		},
+	)
+
+	// Create a DB connection
+	db := m.Collection(src)
+
+	cur, err := db.Aggregate(ctx, pipeline, aggregateOptions())
+	if err != nil {
+		return internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	// Close the cursor once finished
+	if err = cur.Close(ctx); err != nil {
+		return internal.NewError(internal.ErrDBCursorClose, err, 1)
+	}
+
+	return nil
+}
+
+// Build the truncation of a date to the start of its UTC minute, hour or day
+func rollupTrunc(date string, unit string) bson.M {
+	parts := bson.M{
+		year:  bson.M{"$year": date},
+		month: bson.M{"$month": date},
+		day:   bson.M{"$dayOfMonth": date},
+	}
+	if unit == hour || unit == minute {
+		parts[hour] = bson.M{"$hour": date}
+	}
+	if unit == minute {
+		parts[minute] = bson.M{"$minute": date}
+	}
+
+	return bson.M{"$dateFromParts": parts}
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"echo_rest_api/internal"
+)
+
+type (
+	Site struct {
+		ID     primitive.ObjectID   `bson:"_id" json:"id"`
+		Name   string               `bson:"name" json:"name"`
+		Gates  []primitive.ObjectID `bson:"gates" json:"gates"`
+		Spaces []primitive.ObjectID `bson:"spaces" json:"spaces"`
+	}
+)
+
+const (
+	sitesCollectionName = "sites"
+)
+
+// SiteGet retrieves a site (a group of gates and spaces) based on the given ID from the DB
+func SiteGet(ctx context.Context, m *mongo.Database, id primitive.ObjectID) (*Site, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	s := new(Site)
+
+	// Create a DB connection
+	db := m.Collection(sitesCollectionName)
+
+	if err := db.FindOne(ctx, bson.M{"_id": id}).Decode(s); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, internal.NewError(internal.ErrDBNoData, err, 1)
+		}
+
+		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	return s, nil
+}
@@ -3,7 +3,7 @@ package model
 import (
 	"context"
 	"fmt"
-	"sort"
+	"math"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,91 +15,354 @@ import (
 
 type (
 	Stats struct {
-		ID        primitive.ObjectID `json:"id"`
-		Location  string             `json:"location"`
-		ChartType string             `json:"chartType"`
-		IsInside  bool               `json:"-"`
-		Start     time.Time          `json:"start"`
-		End       time.Time          `json:"end"`
-		Timezone  int                `json:"timezone"`
-		AxisX     StatsAxisX         `json:"axisX"`
-		Data      []StatsData        `json:"data"`
+		IDs        []primitive.ObjectID `json:"ids"`
+		SiteID     *primitive.ObjectID  `json:"siteId,omitempty"`
+		PerEntity  bool                 `json:"perEntity"`
+		Location   string               `json:"location"`
+		IsInside   bool                 `json:"isInside"`
+		Start      time.Time            `json:"start"`
+		End        time.Time            `json:"end"`
+		Timezone   string               `json:"timezone"`
+		TZ         *time.Location       `json:"-"`
+		Interval   StatsInterval        `json:"interval"`
+		Fill       string               `json:"fill"`
+		Metrics    []string             `json:"metrics"`
+		Capacity   float64              `json:"capacity,omitempty"`
+		Densify    bool                 `json:"-"`
+		CompareTo  string               `json:"compareTo,omitempty"`
+		Series     []StatsSeries        `json:"series"`
+		Comparison *StatsComparison     `json:"comparison,omitempty"`
 	}
 
-	StatsAxisX struct {
-		ValueFormat  string `json:"valueFormatString"`
-		IntervalType string `json:"intervalType"`
+	StatsInterval struct {
+		Type string `json:"type"`
+		Size int    `json:"size,omitempty"`
 	}
 
-	StatsData struct {
-		Type         string           `json:"type"`
-		Name         string           `json:"name"`
-		Legend       bool             `json:"showInLegend"`
-		XValueFormat string           `json:"xValueFormatString"`
-		Data         []StatsDataPoint `json:"dataPoints"`
+	StatsSeries struct {
+		Name   string       `json:"name"`
+		Metric string       `json:"metric"`
+		Entity string       `json:"entity,omitempty"`
+		Points []StatsPoint `json:"points"`
 	}
 
-	StatsDataPoint struct {
-		Label string `json:"label"`
-		X     string `json:"x"`
-		Y     uint   `json:"y"`
+	StatsPoint struct {
+		Time  time.Time `json:"time"`
+		Value *float64  `json:"value"`
+	}
+
+	StatsComparison struct {
+		Start  time.Time     `json:"start"`
+		End    time.Time     `json:"end"`
+		Series []StatsSeries `json:"series"`
+		Deltas []StatsDelta  `json:"deltas"`
+	}
+
+	StatsDelta struct {
+		Name   string            `json:"name"`
+		Metric string            `json:"metric"`
+		Entity string            `json:"entity,omitempty"`
+		Points []StatsDeltaPoint `json:"points"`
+	}
+
+	StatsDeltaPoint struct {
+		Time         time.Time `json:"time"`
+		PreviousTime time.Time `json:"previousTime"`
+		Current      *float64  `json:"current"`
+		Previous     *float64  `json:"previous"`
+		Delta        *float64  `json:"delta"`
+		DeltaPct     *float64  `json:"deltaPct"`
+	}
+
+	StatsColumn struct {
+		Key    string `json:"key"`
+		Name   string `json:"name"`
+		Metric string `json:"metric"`
+		Entity string `json:"entity,omitempty"`
+	}
+
+	StatsRow struct {
+		Time   time.Time  `json:"time"`
+		Values []*float64 `json:"values"`
 	}
 
 	StatsDataPointRaw struct {
-		Year     uint `bson:"year"`
-		Month    uint `bson:"month,omitempty"`
-		Day      uint `bson:"day,omitempty"`
-		Hour     uint `bson:"hour,omitempty"`
-		Minute   uint `bson:"minute,omitempty"`
-		Second   uint `bson:"second,omitempty"`
-		Entered  uint `bson:"entered"`
-		Exited   uint `bson:"exited"`
-		MaxCount uint `bson:"max_count"`
+		Bucket      time.Time          `bson:"bucket"`
+		Entity      primitive.ObjectID `bson:"entity,omitempty"`
+		Entered     uint               `bson:"entered"`
+		Exited      uint               `bson:"exited"`
+		PeakEntered uint               `bson:"peak_entered"`
+		PeakExited  uint               `bson:"peak_exited"`
+		MaxCount    float64            `bson:"max_count"`
+		MinCount    float64            `bson:"min_count"`
+		AvgCount    float64            `bson:"avg_count"`
+		P50Count    float64            `bson:"p50_count"`
+		P95Count    float64            `bson:"p95_count"`
+		AboveRatio  float64            `bson:"above_ratio"`
 	}
 )
 
+const (
+	StatsMetricEntered     = "entered"     // The gate entries
+	StatsMetricExited      = "exited"      // The gate exits
+	StatsMetricNet         = "net"         // The gate net flow (entries minus exits)
+	StatsMetricOccupancy   = "occupancy"   // The cumulative net flow since the start of the interval
+	StatsMetricPeakEntered = "peakEntered" // The highest number of entries in a minute
+	StatsMetricPeakExited  = "peakExited"  // The highest number of exits in a minute
+
+	StatsMetricMax           = "max"           // The maximum space count
+	StatsMetricMin           = "min"           // The minimum space count
+	StatsMetricAvg           = "avg"           // The average space count
+	StatsMetricP50           = "p50"           // The median space count
+	StatsMetricP95           = "p95"           // The 95th percentile of the space count
+	StatsMetricAboveCapacity = "aboveCapacity" // The minutes spent above the space capacity
+)
+
+const (
+	StatsIntervalNone    = "none"    // Raw samples, without any grouping
+	StatsIntervalMinute  = "minute"  // N-minute buckets
+	StatsIntervalHour    = "hour"    // Hourly buckets
+	StatsIntervalDay     = "day"     // Daily buckets
+	StatsIntervalWeek    = "week"    // ISO week buckets
+	StatsIntervalMonth   = "month"   // Monthly buckets
+	StatsIntervalQuarter = "quarter" // Quarterly buckets
+	StatsIntervalYear    = "year"    // Yearly buckets
+)
+
+const (
+	StatsComparePrevious = "previous" // Compare with the period of the same length right before
+	StatsCompareLastYear = "lastYear" // Compare with the same period of the previous year
+	StatsCompareCustom   = "custom"   // Compare with a custom period
+)
+
+const (
+	StatsLocationGate  = "gate"  // The crossings of gates
+	StatsLocationSpace = "space" // The counts of spaces
+)
+
+const (
+	StatsFillZero = "zero" // Fill the missing buckets with zero values
+	StatsFillNull = "null" // Fill the missing buckets with null values
+	StatsFillNone = "none" // Return only the buckets having data
+)
+
 const (
 	eventsCollectionName       = "events"
 	spaceResultsCollectionName = "space_results"
+	none                       = "none"
 	year                       = "year"
+	quarter                    = "quarter"
 	month                      = "month"
+	week                       = "week"
 	day                        = "day"
 	hour                       = "hour"
-	second                     = "second"
+	minute                     = "minute"
+	offset                     = "offset"
+	entity                     = "entity"
+	isoWeekYear                = "isoWeekYear"
+	maxStatsBuckets            = 100000
 )
 
-// StatsGetGate retrieves gate statistics from the DB
-func StatsGetGate(m *mongo.Database, sq *Stats) error {
-	// Create a DB connection
-	db := m.Collection(eventsCollectionName)
+var (
+	// StatsIntervals holds all the available interval types
+	StatsIntervals = []string{
+		StatsIntervalNone, StatsIntervalMinute, StatsIntervalHour, StatsIntervalDay,
+		StatsIntervalWeek, StatsIntervalMonth, StatsIntervalQuarter, StatsIntervalYear,
+	}
 
-	// Parse the interval type for the date-time grouping
-	var group bson.M
-	switch sq.AxisX.IntervalType {
-	case hour:
-		group = bson.M{
-			year:  bson.M{"$year": "$timestamp"},
-			month: bson.M{"$month": "$timestamp"},
-			day:   bson.M{"$dayOfMonth": "$timestamp"},
-			hour:  bson.M{"$hour": "$timestamp"},
+	// GateMetrics holds all the available gate metrics
+	GateMetrics = []string{
+		StatsMetricEntered, StatsMetricExited, StatsMetricNet, StatsMetricOccupancy, StatsMetricPeakEntered, StatsMetricPeakExited,
+	}
+
+	// SpaceMetrics holds all the available space metrics
+	SpaceMetrics = []string{
+		StatsMetricMax, StatsMetricMin, StatsMetricAvg, StatsMetricP50, StatsMetricP95, StatsMetricAboveCapacity,
+	}
+
+	gateMetricNames = map[string]string{
+		StatsMetricEntered:     "Entered",
+		StatsMetricExited:      "Exited",
+		StatsMetricNet:         "Net flow",
+		StatsMetricOccupancy:   "Occupancy",
+		StatsMetricPeakEntered: "Peak entries per minute",
+		StatsMetricPeakExited:  "Peak exits per minute",
+	}
+	spaceMetricNames = map[string]string{
+		StatsMetricMax:           "Max count",
+		StatsMetricMin:           "Min count",
+		StatsMetricAvg:           "Average count",
+		StatsMetricP50:           "Median count",
+		StatsMetricP95:           "95th percentile count",
+		StatsMetricAboveCapacity: "Minutes above capacity",
+	}
+	spaceMetricFields = map[string]string{
+		StatsMetricMax:           "max_count",
+		StatsMetricMin:           "min_count",
+		StatsMetricAvg:           "avg_count",
+		StatsMetricP50:           "p50_count",
+		StatsMetricP95:           "p95_count",
+		StatsMetricAboveCapacity: "above_ratio",
+	}
+)
+
+// StatsGet retrieves the gate or space statistics from the DB, as a series for each entity and metric
+func StatsGet(ctx context.Context, m *mongo.Database, sq *Stats) error {
+	return StatsCollect(sq, func(fn func(r *StatsRow) error) error {
+		return StatsStream(ctx, m, sq, fn)
+	})
+}
+
+// StatsCollect collects the rows of a stats stream into a series for each entity and metric
+func StatsCollect(sq *Stats, stream func(fn func(r *StatsRow) error) error) error {
+	// Check the number of buckets to be filled before querying anything
+	if err := statsCheckBuckets(sq); err != nil {
+		return err
+	}
+
+	// Instantiate a series for each column of the stats rows
+	cols := StatsColumns(sq)
+	series := make([]StatsSeries, len(cols))
+	for i, col := range cols {
+		series[i] = StatsSeries{
+			Name:   col.Name,
+			Metric: col.Metric,
+			Entity: col.Entity,
+			Points: []StatsPoint{},
 		}
-	case day:
-		group = bson.M{
-			year:  bson.M{"$year": "$timestamp"},
-			month: bson.M{"$month": "$timestamp"},
-			day:   bson.M{"$dayOfMonth": "$timestamp"},
+	}
+
+	// Collect the rows into the series, leaving out the missing values if the gaps are not filled
+	err := stream(func(r *StatsRow) error {
+		for i, v := range r.Values {
+			if v == nil && !sq.fills() {
+				continue
+			}
+
+			series[i].Points = append(series[i].Points, StatsPoint{Time: r.Time, Value: v})
 		}
-	case month:
-		group = bson.M{
-			year:  bson.M{"$year": "$timestamp"},
-			month: bson.M{"$month": "$timestamp"},
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sq.Series = append(sq.Series, series...)
+
+	return nil
+}
+
+// StatsColumns gets the columns of the stats rows: a series for each entity and metric, in the requested order
+func StatsColumns(sq *Stats) []StatsColumn {
+	metrics, names := statsMetrics(sq)
+	entities := statsEntities(sq)
+
+	cols := make([]StatsColumn, 0, len(entities)*len(metrics))
+	for _, id := range entities {
+		for _, mt := range metrics {
+			col := StatsColumn{
+				Key:    mt,
+				Name:   names[mt],
+				Metric: mt,
+			}
+
+			// Tell apart the series of different entities
+			if !id.IsZero() {
+				col.Entity = id.Hex()
+				col.Key = mt + "_" + col.Entity
+				col.Name = fmt.Sprintf("%s (%s)", col.Name, col.Entity)
+			}
+
+			cols = append(cols, col)
 		}
-	case year:
-		group = bson.M{
-			year: bson.M{"$year": "$timestamp"},
+	}
+
+	return cols
+}
+
+// StatsStream streams the gate or space statistics straight from the DB cursor, as rows ordered by time
+// holding a value for each of the StatsColumns; the gaps between the buckets are filled as requested
+func StatsStream(ctx context.Context, m *mongo.Database, sq *Stats, fn func(r *StatsRow) error) error {
+	ctx, cancel := withAggregationTimeout(ctx)
+	defer cancel()
+
+	// Read from the coarsest rollup able to answer the query, or from the raw data of the location otherwise
+	rollup, err := statsRollup(ctx, m, sq)
+	if err != nil {
+		return err
+	}
+
+	// Create a DB connection to the collection of the location
+	db, pipeline := m.Collection(eventsCollectionName), statsGatePipeline(sq, rollup != "")
+	if sq.Location == StatsLocationSpace {
+		db, pipeline = m.Collection(spaceResultsCollectionName), statsSpacePipeline(sq, rollup != "")
+	}
+	if rollup != "" {
+		db = m.Collection(rollup)
+	}
+
+	cur, err := db.Aggregate(ctx, pipeline, aggregateOptions())
+	if err != nil {
+		return internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	// Merge all found information into rows
+	s := newStatsStreamer(sq, fn)
+	for cur.Next(ctx) {
+		var row StatsDataPointRaw
+
+		err = cur.Decode(&row)
+		if err != nil {
+			_ = cur.Close(ctx)
+			return internal.NewError(internal.ErrDBDecode, err, 1)
+		}
+
+		if err = s.add(&row); err != nil {
+			_ = cur.Close(ctx)
+			return err
 		}
 	}
 
+	// Check if any errors occurred
+	if err = cur.Err(); err != nil {
+		return internal.NewError(internal.ErrDBCursorIterate, err, 1)
+	}
+
+	// Close the cursor once finished
+	if err = cur.Close(ctx); err != nil {
+		return internal.NewError(internal.ErrDBCursorClose, err, 1)
+	}
+
+	return s.finish()
+}
+
+// StatsStreamPoints streams already aggregated stats data points, sorted by bucket, as rows ordered by time holding a
+// value for each of the StatsColumns; the gaps between the buckets are filled as requested
+func StatsStreamPoints(sq *Stats, points []StatsDataPointRaw, fn func(r *StatsRow) error) error {
+	s := newStatsStreamer(sq, fn)
+	for i := range points {
+		if err := s.add(&points[i]); err != nil {
+			return err
+		}
+	}
+
+	return s.finish()
+}
+
+// StatsBucket gets the start of the bucket of a stats query containing the given time
+func StatsBucket(sq *Stats, t time.Time) time.Time {
+	return bucketStart(t.In(sq.TZ), sq.Interval)
+}
+
+// Build the aggregation of the gate crossings, or of their hourly or daily rollups, into the requested buckets
+func statsGatePipeline(sq *Stats, rollup bool) []bson.M {
+	// Parse the interval type for the date-time grouping, split by gate if requested
+	group := statsGroup(sq.Interval, sq.Timezone)
+	if sq.PerEntity {
+		group[entity] = "$gate_id"
+	}
+
 	// Parse the direction of data
 	var direction int8
 	switch sq.IsInside {
@@ -110,40 +373,75 @@ func StatsGetGate(m *mongo.Database, sq *Stats) error {
 	}
 
 	// Aggregate all matching stats entries
-	cur, err := db.Aggregate(context.TODO(), []bson.M{
+	pipeline := []bson.M{
 		{
 			"$match": bson.M{
-				"gate_id": sq.ID,
+				"gate_id": bson.M{"$in": sq.IDs},
 				"timestamp": bson.M{
 					"$gte": sq.Start,
 					"$lte": sq.End,
 				},
 			},
 		},
-		{
-			"$group": bson.M{
-				"_id": group,
-				"entered": bson.M{
-					"$sum": bson.M{
-						"$cond": bson.M{
-							"if":   bson.M{"$eq": []interface{}{"$crossed", direction}},
-							"then": 1,
-							"else": 0,
-						},
+	}
+
+	fields := []string{"entered", "exited"}
+	peaks := internal.InSlice(StatsMetricPeakEntered, sq.Metrics) || internal.InSlice(StatsMetricPeakExited, sq.Metrics)
+	switch {
+	case rollup:
+		// The rollups already hold the crossings in each direction, with their peak rates
+		in, out := "in", "out"
+		if sq.IsInside {
+			in, out = out, in
+		}
+
+		g := bson.M{
+			"_id":     group,
+			"entered": bson.M{"$sum": "$" + in},
+			"exited":  bson.M{"$sum": "$" + out},
+		}
+		if peaks {
+			fields = append(fields, "peak_entered", "peak_exited")
+			g["peak_entered"] = bson.M{"$max": "$peak_" + in}
+			g["peak_exited"] = bson.M{"$max": "$peak_" + out}
+		}
+		pipeline = append(pipeline, bson.M{"$group": g})
+	case peaks:
+		// Count the crossings of every minute first, so the peak rates can be picked for each bucket
+		fields = append(fields, "peak_entered", "peak_exited")
+		pipeline = append(pipeline,
+			bson.M{
+				"$group": bson.M{
+					"_id": bson.M{
+						"bucket": group,
+						"minute": bson.M{"$dateToString": bson.M{"format": "%Y-%m-%dT%H:%M", "date": "$timestamp"}},
 					},
+					"entered": statsCrossings(direction),
+					"exited":  statsCrossings(-direction),
 				},
-				"exited": bson.M{
-					"$sum": bson.M{
-						"$cond": bson.M{
-							"if":   bson.M{"$eq": []interface{}{"$crossed", -direction}},
-							"then": 1,
-							"else": 0,
-						},
-					},
+			},
+			bson.M{
+				"$group": bson.M{
+					"_id":          "$_id.bucket",
+					"entered":      bson.M{"$sum": "$entered"},
+					"exited":       bson.M{"$sum": "$exited"},
+					"peak_entered": bson.M{"$max": "$entered"},
+					"peak_exited":  bson.M{"$max": "$exited"},
 				},
 			},
-		},
-		{
+		)
+	default:
+		pipeline = append(pipeline, bson.M{
+			"$group": bson.M{
+				"_id":     group,
+				"entered": statsCrossings(direction),
+				"exited":  statsCrossings(-direction),
+			},
+		})
+	}
+
+	pipeline = append(pipeline,
+		bson.M{
 			"$replaceRoot": bson.M{
 				"newRoot": bson.M{
 					"$mergeObjects": []string{
@@ -153,254 +451,723 @@ func StatsGetGate(m *mongo.Database, sq *Stats) error {
 				},
 			},
 		},
-		{
+		bson.M{
 			"$project": bson.M{"_id": 0, "gate_id": 0, "timestamp": 0},
 		},
-	})
-	if err != nil {
-		return internal.NewError(internal.ErrDBQuery, err, 1)
+	)
+	pipeline = append(pipeline, statsBucketStages(sq, fields...)...)
+
+	return pipeline
+}
+
+// Build the aggregation of the space counts, or of their hourly or daily rollups, into the requested buckets
+func statsSpacePipeline(sq *Stats, rollup bool) []bson.M {
+	// Aggregate all matching stats entries
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"space_id": bson.M{"$in": sq.IDs},
+				"timestamp": bson.M{
+					"$gte": sq.Start,
+					"$lte": sq.End,
+				},
+			},
+		},
 	}
 
-	// Instantiate the entered and exited chart entries
-	entered := StatsData{
-		Type:         sq.ChartType,
-		Name:         "Entered",
-		Legend:       true,
-		XValueFormat: sq.AxisX.ValueFormat,
-		Data:         []StatsDataPoint{},
+	// Clamp the negative sensor counts to 0; the rollups are already clamped
+	if !rollup {
+		pipeline = append(pipeline, bson.M{
+			"$addFields": bson.M{
+				"count": bson.M{"$max": []interface{}{"$count", 0}},
+			},
+		})
 	}
-	exited := StatsData{
-		Type:         sq.ChartType,
-		Name:         "Exited",
-		Legend:       true,
-		XValueFormat: sq.AxisX.ValueFormat,
-		Data:         []StatsDataPoint{},
+
+	// Sum the counts of all spaces at each time into site-level counts, if not split by space, so the metrics are those
+	// of the site and not sums of those of its spaces; the rollups are only used for a single space then
+	if !sq.PerEntity && !rollup {
+		pipeline = append(pipeline,
+			bson.M{
+				"$group": bson.M{
+					"_id":   "$timestamp",
+					"count": bson.M{"$sum": "$count"},
+				},
+			},
+			bson.M{
+				"$project": bson.M{"_id": 0, "timestamp": "$_id", "count": 1},
+			},
+		)
 	}
 
-	// Decode all found information
-	for cur.Next(context.TODO()) {
-		var row StatsDataPointRaw
+	// Raw samples have a single count series
+	metrics, _ := statsMetrics(sq)
 
-		err = cur.Decode(&row)
-		if err != nil {
-			return internal.NewError(internal.ErrDBDecode, err, 1)
+	// Build the requested metrics for each bucket, of each space if split by space
+	fields := make([]string, 0, len(metrics))
+	keys := statsGroup(sq.Interval, sq.Timezone)
+	id := bson.M{}
+	if sq.PerEntity {
+		id[entity] = "$space_id"
+	}
+	for k := range keys {
+		id[k] = keys[k]
+	}
+	group := bson.M{"_id": id}
+	compute := bson.M{}
+	count := func(f string) string {
+		if rollup {
+			return "$" + f
 		}
+		return "$count"
+	}
+	for _, mt := range metrics {
+		fields = append(fields, spaceMetricFields[mt])
+
+		switch mt {
+		case StatsMetricMax:
+			group["max_count"] = bson.M{"$max": count("max")}
+		case StatsMetricMin:
+			group["min_count"] = bson.M{"$min": count("min")}
+		case StatsMetricAvg:
+			if !rollup {
+				group["avg_count"] = bson.M{"$avg": "$count"}
+				break
+			}
 
-		// Convert the retrieved DB date to a date string
-		dateStr, dErr := dateToString(&row, sq.Timezone, sq.AxisX.IntervalType)
-		if dErr != nil {
-			return dErr
+			// The rollups hold the sum and number of samples of every hour or day
+			group["sum"] = bson.M{"$sum": "$sum"}
+			group["samples"] = bson.M{"$sum": "$samples"}
+			compute["avg_count"] = bson.M{"$divide": []interface{}{"$sum", "$samples"}}
+		case StatsMetricP50, StatsMetricP95:
+			// The counts are pushed in ascending order, so the percentile is picked by its rank
+			group["counts"] = bson.M{"$push": "$count"}
+			p := 0.5
+			if mt == StatsMetricP95 {
+				p = 0.95
+			}
+			compute[spaceMetricFields[mt]] = bson.M{
+				"$arrayElemAt": []interface{}{
+					"$counts",
+					bson.M{"$floor": bson.M{"$multiply": []interface{}{p, bson.M{"$subtract": []interface{}{bson.M{"$size": "$counts"}, 1}}}}},
+				},
+			}
+		case StatsMetricAboveCapacity:
+			// The share of samples above capacity; scaled to the bucket length once the bucket is known
+			group["samples"] = bson.M{"$sum": 1}
+			group["above"] = bson.M{"$sum": bson.M{"$cond": bson.M{"if": bson.M{"$gt": []interface{}{"$count", sq.Capacity}}, "then": 1, "else": 0}}}
+			compute["above_ratio"] = bson.M{"$divide": []interface{}{"$above", "$samples"}}
 		}
+	}
 
-		// Process the row into entered and exited point types
-		rowEntered := StatsDataPoint{
-			Label: dateStr,
-			X:     dateStr,
-			Y:     row.Entered,
+	if sq.Interval.Type == none {
+		// Return the raw samples as they are
+		project := bson.M{"_id": 0, "bucket": "$timestamp", "max_count": "$count"}
+		if sq.PerEntity {
+			project[entity] = "$space_id"
 		}
-		rowExited := StatsDataPoint{
-			Label: dateStr,
-			X:     dateStr,
-			Y:     row.Exited,
+		pipeline = append(pipeline, bson.M{"$project": project})
+	} else {
+		if _, ok := group["counts"]; ok {
+			pipeline = append(pipeline, bson.M{"$sort": bson.M{"count": 1}})
 		}
 
-		entered.Data = append(entered.Data, rowEntered)
-		exited.Data = append(exited.Data, rowExited)
+		pipeline = append(pipeline,
+			bson.M{
+				"$group": group,
+			},
+			bson.M{
+				"$replaceRoot": bson.M{
+					"newRoot": bson.M{
+						"$mergeObjects": []string{
+							"$_id",
+							"$$ROOT",
+						},
+					},
+				},
+			},
+		)
+		if len(compute) > 0 {
+			pipeline = append(pipeline, bson.M{"$addFields": compute})
+		}
+		pipeline = append(pipeline, bson.M{
+			"$project": bson.M{"_id": 0, "counts": 0, "sum": 0, "samples": 0, "above": 0},
+		})
 	}
+	pipeline = append(pipeline, statsBucketStages(sq, fields...)...)
 
-	// Sort the data by dates
-	sort.Slice(entered.Data, func(i, j int) bool {
-		return entered.Data[i].X < entered.Data[j].X
-	})
-	sort.Slice(exited.Data, func(i, j int) bool {
-		return exited.Data[i].X < exited.Data[j].X
-	})
+	return pipeline
+}
 
-	// Add the entered and exited data
-	sq.Data = append(sq.Data, entered, exited)
+// Pick the coarsest rollup collection able to answer a stats query exactly; none is picked if the raw data is needed
+func statsRollup(ctx context.Context, m *mongo.Database, sq *Stats) (string, error) {
+	l, ok := rollupLocations[sq.Location]
+	if !ok || sq.Interval.Type == none || sq.Interval.Type == minute {
+		return "", nil
+	}
 
-	// Check if any errors occurred
-	if err = cur.Err(); err != nil {
-		return internal.NewError(internal.ErrDBCursorIterate, err, 1)
+	// The space rollups cannot be summed into site-level counts, as they are per space
+	if sq.Location == StatsLocationSpace && !sq.PerEntity && len(sq.IDs) > 1 {
+		return "", nil
 	}
 
-	// Close the cursor once finished
-	if err = cur.Close(context.TODO()); err != nil {
-		return internal.NewError(internal.ErrDBCursorClose, err, 1)
+	// The space rollups have no percentiles nor capacity, and the gate ones no peak rates shared by several gates
+	for _, mt := range sq.Metrics {
+		switch mt {
+		case StatsMetricP50, StatsMetricP95, StatsMetricAboveCapacity:
+			return "", nil
+		case StatsMetricPeakEntered, StatsMetricPeakExited:
+			if !sq.PerEntity && len(sq.IDs) > 1 {
+				return "", nil
+			}
+		}
+	}
+
+	// The period must be made of whole UTC hours; the end date is usually the last second of the period, the rollups
+	// then also count its last fraction of a second
+	end := sq.End.Truncate(time.Second).Add(time.Second)
+	if !end.After(sq.Start) || !sq.Start.Truncate(time.Hour).Equal(sq.Start) || !end.Truncate(time.Hour).Equal(end) {
+		return "", nil
+	}
+
+	// The buckets must be made of whole UTC hours too, or of whole UTC days for the daily rollups
+	whole, utc := statsOffsets(sq.TZ, sq.Start, end)
+	if !whole {
+		return "", nil
 	}
 
-	// Check if any data found
-	if len(sq.Data) == 0 {
-		return internal.NewError(internal.ErrDBNoData, err, 1)
+	// The rollups must be kept up to date since the start date
+	from, err := RollupGetCoverage(ctx, m, sq.Location)
+	if err != nil {
+		return "", err
+	}
+	if from.IsZero() || sq.Start.Before(from) {
+		return "", nil
+	}
+
+	if utc && sq.Interval.Type != hour && sq.Start.Truncate(24*time.Hour).Equal(sq.Start) && end.Truncate(24*time.Hour).Equal(end) {
+		return l.daily, nil
+	}
+
+	return l.hourly, nil
+}
+
+// Check whether the UTC offsets of a timezone over a period are all whole hours, and whether they are all zero
+func statsOffsets(tz *time.Location, start, end time.Time) (whole, utc bool) {
+	whole, utc = true, true
+	check := func(t time.Time) {
+		_, o := t.In(tz).Zone()
+		whole = whole && o%3600 == 0
+		utc = utc && o == 0
+	}
+
+	// The offsets last far longer than a day, so it is enough to check them daily
+	for t := start; t.Before(end); t = t.Add(24 * time.Hour) {
+		check(t)
+	}
+	check(end.Add(-time.Nanosecond))
+
+	return whole, utc
+}
+
+// Get the value of a metric from a raw stats data point
+func (r *StatsDataPointRaw) metric(mt string, sq *Stats) float64 {
+	switch mt {
+	case StatsMetricExited:
+		return float64(r.Exited)
+	case StatsMetricNet, StatsMetricOccupancy:
+		// The occupancy is accumulated from the net flow once the rows are complete
+		return float64(r.Entered) - float64(r.Exited)
+	case StatsMetricPeakEntered:
+		return float64(r.PeakEntered)
+	case StatsMetricPeakExited:
+		return float64(r.PeakExited)
+	case StatsMetricMax:
+		return r.MaxCount
+	case StatsMetricMin:
+		return r.MinCount
+	case StatsMetricAvg:
+		return r.AvgCount
+	case StatsMetricP50:
+		return r.P50Count
+	case StatsMetricP95:
+		return r.P95Count
+	case StatsMetricAboveCapacity:
+		// Assume the samples are evenly spread over the part of the bucket inside the requested interval
+		s, e := r.Bucket, nextBucket(r.Bucket.In(sq.TZ), sq.Interval)
+		if s.Before(sq.Start) {
+			s = sq.Start
+		}
+		if e.After(sq.End) {
+			e = sq.End
+		}
+		return math.Round(r.AboveRatio*e.Sub(s).Minutes()*100) / 100
+	default:
+		return float64(r.Entered)
+	}
+}
+
+// Merges the DB documents, sorted by bucket, into a row per bucket and fills the gaps between them
+type statsStreamer struct {
+	sq        *Stats
+	fn        func(r *StatsRow) error
+	metrics   []string
+	offsets   map[primitive.ObjectID]int
+	occupancy []float64
+	row       *StatsRow
+	next      time.Time
+}
+
+// Create a new streamer of the rows of a stats query
+func newStatsStreamer(sq *Stats, fn func(r *StatsRow) error) *statsStreamer {
+	metrics, _ := statsMetrics(sq)
+	entities := statsEntities(sq)
+
+	s := &statsStreamer{
+		sq:        sq,
+		fn:        fn,
+		metrics:   metrics,
+		offsets:   make(map[primitive.ObjectID]int, len(entities)),
+		occupancy: make([]float64, len(entities)*len(metrics)),
+		next:      bucketStart(sq.Start.In(sq.TZ), sq.Interval),
+	}
+
+	// The columns of each entity follow each other
+	for i, id := range entities {
+		s.offsets[id] = i * len(metrics)
+	}
+
+	return s
+}
+
+// Add a DB document to the row of its bucket, passing on the previous row once the bucket changes
+func (s *statsStreamer) add(r *StatsDataPointRaw) error {
+	t := r.Bucket.In(s.sq.TZ)
+	if s.row != nil && !s.row.Time.Equal(t) {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	if s.row == nil {
+		s.row = &StatsRow{Time: t, Values: make([]*float64, len(s.occupancy))}
+	}
+
+	// Ignore the documents of unrequested entities
+	o, ok := s.offsets[r.Entity]
+	if !ok {
+		return nil
+	}
+
+	for i, mt := range s.metrics {
+		v := r.metric(mt, s.sq)
+		s.row.Values[o+i] = &v
 	}
 
 	return nil
 }
 
-// StatsGetSpace retrieve space statistics from the DB
-func StatsGetSpace(m *mongo.Database, sq *Stats) error {
-	// Create a DB connection
-	db := m.Collection(spaceResultsCollectionName)
+// Pass on the current row, preceded by the missing buckets before it
+func (s *statsStreamer) flush() error {
+	if s.sq.fills() {
+		for ; s.next.Before(s.row.Time); s.next = nextBucket(s.next, s.sq.Interval) {
+			if err := s.emit(&StatsRow{Time: s.next, Values: make([]*float64, len(s.occupancy))}); err != nil {
+				return err
+			}
+		}
+		if s.next.Equal(s.row.Time) {
+			s.next = nextBucket(s.next, s.sq.Interval)
+		}
+	}
+
+	err := s.emit(s.row)
+	s.row = nil
+
+	return err
+}
+
+// Pass on the last row, followed by the missing buckets up to the requested end date
+func (s *statsStreamer) finish() error {
+	if s.row != nil {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+
+	if !s.sq.fills() {
+		return nil
+	}
+
+	for ; !s.next.After(s.sq.End); s.next = nextBucket(s.next, s.sq.Interval) {
+		if err := s.emit(&StatsRow{Time: s.next, Values: make([]*float64, len(s.occupancy))}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Complete the missing and cumulative values of a row and pass it on
+func (s *statsStreamer) emit(r *StatsRow) error {
+	for i, v := range r.Values {
+		// Zero-fill the missing values, including the entities without data in a found bucket
+		if v == nil && s.sq.fills() && s.sq.Fill == StatsFillZero {
+			r.Values[i] = new(float64)
+		}
+
+		// Accumulate the net flow into the occupancy since the start of the interval
+		if s.metrics[i%len(s.metrics)] == StatsMetricOccupancy {
+			if v != nil {
+				s.occupancy[i] += *v
+			}
+
+			o := s.occupancy[i]
+			r.Values[i] = &o
+		}
+	}
+
+	return s.fn(r)
+}
+
+// Compare the stats data with the data of a comparison period, with the buckets aligned by their offset from the start
+// of their period, so the missing buckets of either period do not shift the others
+func (sq *Stats) Compare(cq *Stats) {
+	sq.Comparison = &StatsComparison{
+		Start:  cq.Start,
+		End:    cq.End,
+		Series: cq.Series,
+		Deltas: make([]StatsDelta, 0, len(sq.Series)),
+	}
+
+	offset, prevOffset := sq.bucketOffsets(), cq.bucketOffsets()
+	for i, cur := range sq.Series {
+		// Index the points of the comparison period by their offset
+		prev := make(map[int64]StatsPoint, len(cq.Series[i].Points))
+		for _, p := range cq.Series[i].Points {
+			if o, ok := prevOffset(p.Time); ok {
+				prev[o] = p
+			}
+		}
+
+		delta := StatsDelta{
+			Name:   cur.Name,
+			Metric: cur.Metric,
+			Entity: cur.Entity,
+			Points: make([]StatsDeltaPoint, 0, len(cur.Points)),
+		}
+
+		for _, p := range cur.Points {
+			dp := StatsDeltaPoint{
+				Time:    p.Time,
+				Current: p.Value,
+			}
+
+			if o, ok := offset(p.Time); ok {
+				if pp, ok := prev[o]; ok {
+					dp.PreviousTime = pp.Time
+					dp.Previous = pp.Value
+				}
+			}
+
+			// Compute the absolute and relative deltas, when both values are known
+			if dp.Current != nil && dp.Previous != nil {
+				d := *dp.Current - *dp.Previous
+				dp.Delta = &d
+
+				if *dp.Previous != 0 {
+					pct := math.Round(d / *dp.Previous * 10000) / 100
+					dp.DeltaPct = &pct
+				}
+			}
+
+			delta.Points = append(delta.Points, dp)
+		}
+
+		sq.Comparison.Deltas = append(sq.Comparison.Deltas, delta)
+	}
+}
+
+// Get the offset of a bucket from the start of the period: its position among the buckets of the period, or the time
+// since the start for raw samples
+func (sq *Stats) bucketOffsets() func(t time.Time) (int64, bool) {
+	if sq.Interval.Type == none {
+		start := sq.Start
+		return func(t time.Time) (int64, bool) {
+			return int64(t.Sub(start)), true
+		}
+	}
+
+	positions := make(map[int64]int64)
+	var i int64
+	for b := StatsBucket(sq, sq.Start); !b.After(sq.End); b = nextBucket(b, sq.Interval) {
+		positions[b.UnixNano()] = i
+		i++
+	}
+
+	return func(t time.Time) (int64, bool) {
+		o, ok := positions[t.UnixNano()]
+		return o, ok
+	}
+}
+
+// StatsSupportsDensify checks if the MongoDB server supports the $densify and $fill stages (v5.3+)
+func StatsSupportsDensify(ctx context.Context, m *mongo.Database) (bool, error) {
+	v, err := dbVersion(ctx, m)
+	if err != nil {
+		return false, err
+	}
+
+	if len(v) < 2 {
+		return false, nil
+	}
+
+	return v[0] > 5 || (v[0] == 5 && v[1] >= 3), nil
+}
+
+// Check if the gaps between the found buckets are filled
+func (sq *Stats) fills() bool {
+	return sq.Fill != StatsFillNone && sq.Interval.Type != none
+}
+
+// Get the metrics computed for each entity with their names; raw space samples have a single count
+func statsMetrics(sq *Stats) ([]string, map[string]string) {
+	switch {
+	case sq.Location == StatsLocationGate:
+		return sq.Metrics, gateMetricNames
+	case sq.Interval.Type == none:
+		return []string{StatsMetricMax}, map[string]string{StatsMetricMax: "Count"}
+	default:
+		return sq.Metrics, spaceMetricNames
+	}
+}
+
+// Get the entities having their own chart entries; a single zero entity if their data is merged
+func statsEntities(sq *Stats) []primitive.ObjectID {
+	if sq.PerEntity {
+		return sq.IDs
+	}
+
+	return []primitive.ObjectID{primitive.NilObjectID}
+}
+
+// Build the count of the gate crossings in a direction
+func statsCrossings(d int8) bson.M {
+	return bson.M{
+		"$sum": bson.M{
+			"$cond": bson.M{
+				"if":   bson.M{"$eq": []interface{}{"$crossed", d}},
+				"then": 1,
+				"else": 0,
+			},
+		},
+	}
+}
+
+// Build the date-time grouping for an interval type, with the date parts computed in the given timezone
+func statsGroup(iv StatsInterval, tz string) bson.M {
+	part := func(op string) bson.M {
+		return bson.M{op: bson.M{"date": "$timestamp", "timezone": tz}}
+	}
 
-	// Parse the interval type for the date-time grouping
 	var group bson.M
-	switch sq.AxisX.IntervalType {
+	switch iv.Type {
+	case minute:
+		group = bson.M{
+			year:  part("$year"),
+			month: part("$month"),
+			day:   part("$dayOfMonth"),
+			hour:  part("$hour"),
+			// Round the minute down to the start of its N-minute bucket
+			minute: bson.M{"$subtract": []interface{}{part("$minute"), bson.M{"$mod": []interface{}{part("$minute"), iv.Size}}}},
+			// Keep the UTC offset so the repeated hour of a DST change is not merged into a single bucket
+			offset: bson.M{"$dateToString": bson.M{"format": "%z", "date": "$timestamp", "timezone": tz}},
+		}
 	case hour:
 		group = bson.M{
-			year:  bson.M{"$year": "$timestamp"},
-			month: bson.M{"$month": "$timestamp"},
-			day:   bson.M{"$dayOfMonth": "$timestamp"},
-			hour:  bson.M{"$hour": "$timestamp"},
+			year:  part("$year"),
+			month: part("$month"),
+			day:   part("$dayOfMonth"),
+			hour:  part("$hour"),
+			// Keep the UTC offset so the repeated hour of a DST change is not merged into a single bucket
+			offset: bson.M{"$dateToString": bson.M{"format": "%z", "date": "$timestamp", "timezone": tz}},
 		}
 	case day:
 		group = bson.M{
-			year:  bson.M{"$year": "$timestamp"},
-			month: bson.M{"$month": "$timestamp"},
-			day:   bson.M{"$dayOfMonth": "$timestamp"},
+			year:  part("$year"),
+			month: part("$month"),
+			day:   part("$dayOfMonth"),
+		}
+	case week:
+		group = bson.M{
+			isoWeekYear: part("$isoWeekYear"),
+			week:        part("$isoWeek"),
 		}
 	case month:
 		group = bson.M{
-			year:  bson.M{"$year": "$timestamp"},
-			month: bson.M{"$month": "$timestamp"},
+			year:  part("$year"),
+			month: part("$month"),
+		}
+	case quarter:
+		group = bson.M{
+			year:    part("$year"),
+			quarter: bson.M{"$ceil": bson.M{"$divide": []interface{}{part("$month"), 3}}},
 		}
 	case year:
 		group = bson.M{
-			year: bson.M{"$year": "$timestamp"},
+			year: part("$year"),
 		}
 	}
 
-	// Aggregate all matching stats entries
-	cur, err := db.Aggregate(context.TODO(), []bson.M{
-		{
-			"$match": bson.M{
-				"space_id": sq.ID,
-				"timestamp": bson.M{
-					"$gte": sq.Start,
-					"$lte": sq.End,
-				},
-			},
-		},
-		{
-			"$group": bson.M{
-				"_id": group,
-				"max_raw": bson.M{
-					"$max": "$count",
+	return group
+}
+
+// Build the stages converting the grouped date parts into the bucket start date, sorted by real time;
+// when supported, the missing buckets are generated and zero-filled by MongoDB itself
+func statsBucketStages(sq *Stats, fields ...string) []bson.M {
+	var stages []bson.M
+
+	// Raw samples already have their timestamp as bucket
+	switch sq.Interval.Type {
+	case none:
+	case week:
+		stages = append(stages, bson.M{
+			"$addFields": bson.M{
+				"bucket": bson.M{
+					"$dateFromParts": bson.M{
+						"isoWeekYear":  "$" + isoWeekYear,
+						"isoWeek":      "$" + week,
+						"isoDayOfWeek": 1,
+						"timezone":     sq.Timezone,
+					},
 				},
 			},
-		},
-		{
-			"$replaceRoot": bson.M{
-				"newRoot": bson.M{
-					"$mergeObjects": []string{
-						"$_id",
-						"$$ROOT",
+		})
+	default:
+		// Sub-daily buckets carry their exact UTC offset, all others are local to the requested timezone
+		var tz interface{} = sq.Timezone
+		if sq.Interval.Type == hour || sq.Interval.Type == minute {
+			tz = "$" + offset
+		}
+
+		// Quarters start on their first month
+		var m interface{} = bson.M{"$ifNull": []interface{}{"$" + month, 1}}
+		if sq.Interval.Type == quarter {
+			m = bson.M{"$subtract": []interface{}{bson.M{"$multiply": []interface{}{"$" + quarter, 3}}, 2}}
+		}
+
+		stages = append(stages, bson.M{
+			"$addFields": bson.M{
+				"bucket": bson.M{
+					"$dateFromParts": bson.M{
+						"year":     "$" + year,
+						"month":    m,
+						"day":      bson.M{"$ifNull": []interface{}{"$" + day, 1}},
+						"hour":     bson.M{"$ifNull": []interface{}{"$" + hour, 0}},
+						"minute":   bson.M{"$ifNull": []interface{}{"$" + minute, 0}},
+						"timezone": tz,
 					},
 				},
 			},
-		},
-		{
-			"$project": bson.M{
-				"year": 1, "month": 1, "day": 1, "hour": 1,
-				"max_count": bson.M{"$cond": bson.M{"if": bson.M{"$lt": []interface{}{"$max_raw", 0}}, "then": 0, "else": "$max_raw"}},
-			},
-		},
-		{
-			"$project": bson.M{"_id": 0, "space_id": 0, "timestamp": 0, "count": 0, "stale": 0, "max_raw": 0},
-		},
-	})
-	if err != nil {
-		return internal.NewError(internal.ErrDBQuery, err, 1)
+		})
 	}
 
-	// Instantiate the max count chart entry
-	maxCount := StatsData{
-		Type:         sq.ChartType,
-		Name:         "Max count",
-		Legend:       true,
-		XValueFormat: sq.AxisX.ValueFormat,
-		Data:         []StatsDataPoint{},
+	// MongoDB densifies in UTC steps, so only use it when those match the local buckets
+	unit, step := "", 1
+	switch {
+	case sq.Interval.Type == none:
+	case sq.Interval.Type == minute:
+		unit, step = minute, sq.Interval.Size
+	case sq.Interval.Type == hour || sq.TZ.String() == "UTC":
+		unit = sq.Interval.Type
 	}
-
-	// Decode all found information
-	for cur.Next(context.TODO()) {
-		var row StatsDataPointRaw
-
-		err = cur.Decode(&row)
-		if err != nil {
-			return internal.NewError(internal.ErrDBDecode, err, 1)
+	if sq.Densify && sq.Fill == StatsFillZero && unit != "" && !sq.End.Before(sq.Start) {
+		output := bson.M{}
+		for _, f := range fields {
+			output[f] = bson.M{"value": 0}
 		}
 
-		// Convert the retrieved DB date to a date string
-		dateStr, dErr := dateToString(&row, sq.Timezone, sq.AxisX.IntervalType)
-		if dErr != nil {
-			return dErr
+		// Densify from the first bucket up to the end of the last one
+		first := bucketStart(sq.Start.In(sq.TZ), sq.Interval)
+		densify := bson.M{
+			"field": "bucket",
+			"range": bson.M{
+				"step":   step,
+				"unit":   unit,
+				"bounds": []time.Time{first, nextBucket(bucketStart(sq.End.In(sq.TZ), sq.Interval), sq.Interval)},
+			},
 		}
-
-		// Process the row into max count point type
-		rowMaxCount := StatsDataPoint{
-			Label: dateStr,
-			X:     dateStr,
-			Y:     row.MaxCount,
+		if sq.PerEntity {
+			densify["partitionByFields"] = []string{entity}
 		}
 
-		maxCount.Data = append(maxCount.Data, rowMaxCount)
+		stages = append(stages,
+			bson.M{
+				"$densify": densify,
+			},
+			bson.M{"$fill": bson.M{"output": output}},
+		)
 	}
 
-	// Sort the data by dates
-	sort.Slice(maxCount.Data, func(i, j int) bool {
-		return maxCount.Data[i].X < maxCount.Data[j].X
-	})
-
-	// Add the max count data
-	sq.Data = append(sq.Data, maxCount)
-
-	// Check if any errors occurred
-	if err = cur.Err(); err != nil {
-		return internal.NewError(internal.ErrDBCursorIterate, err, 1)
-	}
+	return append(stages, bson.M{"$sort": bson.M{"bucket": 1}})
+}
 
-	// Close the cursor once finished
-	if err = cur.Close(context.TODO()); err != nil {
-		return internal.NewError(internal.ErrDBCursorClose, err, 1)
+// Check that the number of buckets to be filled between the requested start and end dates is within the limit
+func statsCheckBuckets(sq *Stats) error {
+	if !sq.fills() {
+		return nil
 	}
 
-	// Check if any data found
-	if len(sq.Data) == 0 {
-		return internal.NewError(internal.ErrDBNoData, err, 1)
+	n := 0
+	for t := bucketStart(sq.Start.In(sq.TZ), sq.Interval); !t.After(sq.End); t = nextBucket(t, sq.Interval) {
+		if n++; n > maxStatsBuckets {
+			return internal.NewError(internal.ErrBEQPTooManyPoints, nil, 2)
+		}
 	}
 
 	return nil
 }
 
-// Convert a raw stats data point date to a date-string
-func dateToString(sd *StatsDataPointRaw, tOff int, mt string) (string, error) {
-	// Convert date points to time object
-	tStr := fmt.Sprintf(
-		"%d-%d-%d %d:%d:%d",
-		sd.Year, internal.MaxUint(sd.Month, 1), internal.MaxUint(sd.Day, 1), sd.Hour, sd.Minute, sd.Second,
-	)
-	ct, err := time.Parse("2006-1-2 15:4:5", tStr)
-	if err != nil {
-		return "", internal.NewError(internal.ErrBETimeConversion, err, 2)
+// Get the start of the bucket containing the given time, in the time's location
+func bucketStart(t time.Time, iv StatsInterval) time.Time {
+	switch iv.Type {
+	case minute:
+		// Truncate in absolute time, as the local hour may be ambiguous during a DST change
+		m := t.Minute() % iv.Size
+		return t.Add(-time.Duration(m)*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	case hour:
+		// Truncate in absolute time, as the local hour may be ambiguous during a DST change
+		return t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	case day:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case week:
+		// ISO weeks start on Monday
+		return time.Date(t.Year(), t.Month(), t.Day()-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	case month:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case quarter:
+		return time.Date(t.Year(), t.Month()-(t.Month()-1)%3, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
 	}
+}
 
-	// Apply the given timezone offset
-	ct = ct.Add(time.Hour * time.Duration(tOff))
-
-	// Convert the time to the required format
-	var date string
-	switch mt {
-	case second:
-		date = ct.Format("2006-01-02 15:04:05")
+// Get the start of the bucket following the one starting at the given time
+func nextBucket(t time.Time, iv StatsInterval) time.Time {
+	switch iv.Type {
+	case minute:
+		// Step in absolute time, so both occurrences of a repeated DST hour are kept
+		return t.Add(time.Duration(iv.Size) * time.Minute)
 	case hour:
-		date = ct.Format("2006-01-02 15:00 - 15:") + "59"
+		// Step in absolute time, so both occurrences of a repeated DST hour are kept
+		return t.Add(time.Hour)
 	case day:
-		date = ct.Format("2006-01-02")
+		return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+	case week:
+		return time.Date(t.Year(), t.Month(), t.Day()+7, 0, 0, 0, 0, t.Location())
 	case month:
-		date = ct.Format("2006-01")
-	case year:
-		date = ct.Format("2006")
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+	case quarter:
+		return time.Date(t.Year(), t.Month()+3, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year()+1, 1, 1, 0, 0, 0, 0, t.Location())
 	}
-
-	return date, nil
 }
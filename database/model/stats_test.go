@@ -0,0 +1,450 @@
+package model
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Get the values of the stream rows, with nil values as -1
+func streamValues(t *testing.T, sq *Stats, points []StatsDataPointRaw) ([]time.Time, [][]float64) {
+	t.Helper()
+
+	var times []time.Time
+	var values [][]float64
+	err := StatsStreamPoints(sq, points, func(r *StatsRow) error {
+		v := make([]float64, len(r.Values))
+		for i, p := range r.Values {
+			v[i] = -1
+			if p != nil {
+				v[i] = *p
+			}
+		}
+
+		times, values = append(times, r.Time), append(values, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return times, values
+}
+
+func TestStatsBucketDST(t *testing.T) {
+	tz, err := time.LoadLocation("Europe/Bucharest")
+	if err != nil {
+		t.Skip(err)
+	}
+	at := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name     string
+		interval StatsInterval
+		t        string
+		start    string
+		next     string
+	}{
+		{"spring forward day", StatsInterval{Type: StatsIntervalDay}, "2024-03-31T12:00:00+03:00", "2024-03-31T00:00:00+02:00", "2024-04-01T00:00:00+03:00"},
+		{"fall back day", StatsInterval{Type: StatsIntervalDay}, "2024-10-27T12:00:00+02:00", "2024-10-27T00:00:00+03:00", "2024-10-28T00:00:00+02:00"},
+		{"skipped hour", StatsInterval{Type: StatsIntervalHour}, "2024-03-31T02:30:00+02:00", "2024-03-31T02:00:00+02:00", "2024-03-31T04:00:00+03:00"},
+		{"first repeated hour", StatsInterval{Type: StatsIntervalHour}, "2024-10-27T03:30:00+03:00", "2024-10-27T03:00:00+03:00", "2024-10-27T03:00:00+02:00"},
+		{"second repeated hour", StatsInterval{Type: StatsIntervalHour}, "2024-10-27T03:30:00+02:00", "2024-10-27T03:00:00+02:00", "2024-10-27T04:00:00+02:00"},
+		{"15 minutes in the repeated hour", StatsInterval{Type: StatsIntervalMinute, Size: 15}, "2024-10-27T03:50:00+02:00", "2024-10-27T03:45:00+02:00", "2024-10-27T04:00:00+02:00"},
+		{"week across DST", StatsInterval{Type: StatsIntervalWeek}, "2024-03-31T12:00:00+03:00", "2024-03-25T00:00:00+02:00", "2024-04-01T00:00:00+03:00"},
+		{"week starting on Monday", StatsInterval{Type: StatsIntervalWeek}, "2024-04-01T00:00:00+03:00", "2024-04-01T00:00:00+03:00", "2024-04-08T00:00:00+03:00"},
+		{"quarter across DST", StatsInterval{Type: StatsIntervalQuarter}, "2024-05-15T12:00:00+03:00", "2024-04-01T00:00:00+03:00", "2024-07-01T00:00:00+03:00"},
+		{"quarter into winter time", StatsInterval{Type: StatsIntervalQuarter}, "2024-12-31T23:59:59+02:00", "2024-10-01T00:00:00+03:00", "2025-01-01T00:00:00+02:00"},
+		{"month into summer time", StatsInterval{Type: StatsIntervalMonth}, "2024-03-01T00:00:00+02:00", "2024-03-01T00:00:00+02:00", "2024-04-01T00:00:00+03:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sq := &Stats{TZ: tz, Interval: tt.interval}
+
+			// The buckets are computed from absolute times, whatever their location
+			start := StatsBucket(sq, at(tt.t).UTC())
+			if !start.Equal(at(tt.start)) {
+				t.Errorf("got start %s, want %s", start, tt.start)
+			}
+			if next := nextBucket(start, tt.interval); !next.Equal(at(tt.next)) {
+				t.Errorf("got next %s, want %s", next, tt.next)
+			}
+		})
+	}
+}
+
+func TestStatsStreamPointsFill(t *testing.T) {
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	hour := func(h int) time.Time { return start.Add(time.Duration(h) * time.Hour) }
+	points := []StatsDataPointRaw{
+		{Bucket: hour(1), Entered: 3, Exited: 1},
+		{Bucket: hour(3), Entered: 1, Exited: 2},
+	}
+
+	tests := []struct {
+		fill   string
+		times  []time.Time
+		values [][]float64
+	}{
+		{StatsFillZero, []time.Time{hour(0), hour(1), hour(2), hour(3), hour(4)}, [][]float64{{0}, {3}, {0}, {1}, {0}}},
+		{StatsFillNull, []time.Time{hour(0), hour(1), hour(2), hour(3), hour(4)}, [][]float64{{-1}, {3}, {-1}, {1}, {-1}}},
+		{StatsFillNone, []time.Time{hour(1), hour(3)}, [][]float64{{3}, {1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fill, func(t *testing.T) {
+			sq := &Stats{
+				Location: StatsLocationGate,
+				Start:    start,
+				End:      hour(4).Add(59 * time.Minute),
+				TZ:       time.UTC,
+				Interval: StatsInterval{Type: StatsIntervalHour},
+				Fill:     tt.fill,
+				Metrics:  []string{StatsMetricEntered},
+			}
+
+			times, values := streamValues(t, sq, points)
+			if !reflect.DeepEqual(times, tt.times) || !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("got %v %v, want %v %v", times, values, tt.times, tt.values)
+			}
+		})
+	}
+}
+
+func TestStatsStreamPointsOccupancy(t *testing.T) {
+	g1, g2 := primitive.NewObjectID(), primitive.NewObjectID()
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return start.AddDate(0, 0, d) }
+	sq := &Stats{
+		IDs:       []primitive.ObjectID{g1, g2},
+		PerEntity: true,
+		Location:  StatsLocationGate,
+		Start:     start,
+		End:       day(3).Add(-time.Second),
+		TZ:        time.UTC,
+		Interval:  StatsInterval{Type: StatsIntervalDay},
+		Fill:      StatsFillZero,
+		Metrics:   []string{StatsMetricNet, StatsMetricOccupancy},
+	}
+
+	// The occupancy accumulates the net flow of each gate, carried over the buckets without data
+	_, values := streamValues(t, sq, []StatsDataPointRaw{
+		{Bucket: day(0), Entity: g1, Entered: 5, Exited: 1},
+		{Bucket: day(0), Entity: g2, Entered: 2},
+		{Bucket: day(2), Entity: g1, Exited: 3},
+	})
+	want := [][]float64{
+		{4, 4, 2, 2},
+		{0, 4, 0, 2},
+		{-3, 1, 0, 2},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("got %v, want %v", values, want)
+	}
+}
+
+func TestStatsCompare(t *testing.T) {
+	v := func(f float64) *float64 { return &f }
+	start := time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)
+	prevStart := start.AddDate(0, 0, -7)
+	day := func(t time.Time, d int) time.Time { return t.AddDate(0, 0, d) }
+
+	sq := &Stats{
+		Start:    start,
+		End:      day(start, 7).Add(-time.Second),
+		TZ:       time.UTC,
+		Interval: StatsInterval{Type: StatsIntervalDay},
+		Series: []StatsSeries{{Name: "Entered", Metric: StatsMetricEntered, Points: []StatsPoint{
+			{Time: day(start, 1), Value: v(10)},
+			{Time: day(start, 4), Value: v(6)},
+		}}},
+	}
+	cq := *sq
+	cq.Start, cq.End = prevStart, start.Add(-time.Second)
+	cq.Series = []StatsSeries{{Name: "Entered", Metric: StatsMetricEntered, Points: []StatsPoint{
+		{Time: day(prevStart, 0), Value: v(1)},
+		{Time: day(prevStart, 1), Value: v(5)},
+		{Time: day(prevStart, 4), Value: v(0)},
+	}}}
+
+	sq.Compare(&cq)
+
+	// The sparse points are paired by their day in the period, not by their position in the series
+	points := sq.Comparison.Deltas[0].Points
+	if len(points) != 2 {
+		t.Fatalf("got %d delta points, want 2", len(points))
+	}
+	if p := points[0]; !p.PreviousTime.Equal(day(prevStart, 1)) || *p.Delta != 5 || *p.DeltaPct != 100 {
+		t.Errorf("got %+v, want the second day compared, with a delta of 5 (100%%)", p)
+	}
+	if p := points[1]; !p.PreviousTime.Equal(day(prevStart, 4)) || *p.Delta != 6 || p.DeltaPct != nil {
+		t.Errorf("got %+v, want the fifth day compared, with a delta of 6 and no percentage", p)
+	}
+}
+
+func TestStatsSpacePipeline(t *testing.T) {
+	s1, s2 := primitive.NewObjectID(), primitive.NewObjectID()
+	sq := &Stats{
+		IDs:      []primitive.ObjectID{s1, s2},
+		Location: StatsLocationSpace,
+		Start:    time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		End:      time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+		Timezone: "UTC",
+		TZ:       time.UTC,
+		Interval: StatsInterval{Type: StatsIntervalHour},
+		Metrics:  []string{StatsMetricMax, StatsMetricAboveCapacity},
+		Capacity: 10,
+	}
+
+	// Find the grouping stages of a pipeline
+	groups := func(pipeline []bson.M) []bson.M {
+		var gs []bson.M
+		for _, st := range pipeline {
+			if g, ok := st["$group"].(bson.M); ok {
+				gs = append(gs, g)
+			}
+		}
+
+		return gs
+	}
+
+	// Site-level: the counts of all spaces are summed at each time first, then bucketed
+	gs := groups(statsSpacePipeline(sq, false))
+	if len(gs) != 2 {
+		t.Fatalf("got %d group stages, want 2", len(gs))
+	}
+	if gs[0]["_id"] != "$timestamp" || !reflect.DeepEqual(gs[0]["count"], bson.M{"$sum": "$count"}) {
+		t.Errorf("got first group %v, want the counts summed by timestamp", gs[0])
+	}
+	if _, ok := gs[1]["_id"].(bson.M)[entity]; ok {
+		t.Errorf("got bucket group %v, want it not split by space", gs[1])
+	}
+
+	// Per space: the buckets are computed for each space, without summing anything
+	sq.PerEntity = true
+	gs = groups(statsSpacePipeline(sq, false))
+	if len(gs) != 1 || gs[0]["_id"].(bson.M)[entity] != "$space_id" {
+		t.Errorf("got groups %v, want a single group split by space", gs)
+	}
+}
+
+func TestStatsRollupSiteLevel(t *testing.T) {
+	sq := &Stats{
+		IDs:      []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()},
+		Location: StatsLocationSpace,
+		Start:    time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		End:      time.Date(2024, 3, 1, 23, 59, 59, 0, time.UTC),
+		TZ:       time.UTC,
+		Interval: StatsInterval{Type: StatsIntervalDay},
+		Metrics:  []string{StatsMetricMax},
+	}
+
+	// The per-space rollups cannot give the site maximum, so the raw data is read without querying the DB
+	r, err := statsRollup(nil, nil, sq)
+	if err != nil || r != "" {
+		t.Errorf("got rollup %q (%v), want none", r, err)
+	}
+}
+
+func TestStatsGroup(t *testing.T) {
+	tests := []struct {
+		interval StatsInterval
+		keys     []string
+	}{
+		{StatsInterval{Type: StatsIntervalMinute, Size: 15}, []string{day, hour, minute, month, offset, year}},
+		{StatsInterval{Type: StatsIntervalHour}, []string{day, hour, month, offset, year}},
+		{StatsInterval{Type: StatsIntervalDay}, []string{day, month, year}},
+		{StatsInterval{Type: StatsIntervalWeek}, []string{isoWeekYear, week}},
+		{StatsInterval{Type: StatsIntervalMonth}, []string{month, year}},
+		{StatsInterval{Type: StatsIntervalQuarter}, []string{quarter, year}},
+		{StatsInterval{Type: StatsIntervalYear}, []string{year}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.interval.Type, func(t *testing.T) {
+			group := statsGroup(tt.interval, "Europe/Bucharest")
+
+			var keys []string
+			for k := range group {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.keys) {
+				t.Errorf("got keys %v, want %v", keys, tt.keys)
+			}
+
+			// The date parts are taken in the requested timezone
+			want := bson.M{"date": "$timestamp", "timezone": "Europe/Bucharest"}
+			if y, ok := group[year]; ok && !reflect.DeepEqual(y, bson.M{"$year": want}) {
+				t.Errorf("got %v, want the year in the requested timezone", y)
+			}
+		})
+	}
+}
+
+func TestStatsBucketStages(t *testing.T) {
+	tz, err := time.LoadLocation("Europe/Bucharest")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		name      string
+		interval  StatsInterval
+		tz        *time.Location
+		densify   bool
+		fill      string
+		perEntity bool
+		bucketTZ  interface{} // The timezone of the bucket dates, nil for none
+		unit      string      // The densify unit, empty for none
+		step      int
+	}{
+		{"raw samples", StatsInterval{Type: StatsIntervalNone}, time.UTC, true, StatsFillZero, false, nil, "", 0},
+		{"minutes", StatsInterval{Type: StatsIntervalMinute, Size: 15}, tz, true, StatsFillZero, false, "$" + offset, minute, 15},
+		{"hours", StatsInterval{Type: StatsIntervalHour}, tz, true, StatsFillZero, true, "$" + offset, hour, 1},
+		{"local days", StatsInterval{Type: StatsIntervalDay}, tz, true, StatsFillZero, false, "Europe/Bucharest", "", 0},
+		{"UTC days", StatsInterval{Type: StatsIntervalDay}, time.UTC, true, StatsFillZero, false, "UTC", day, 1},
+		{"weeks", StatsInterval{Type: StatsIntervalWeek}, time.UTC, true, StatsFillZero, false, "UTC", week, 1},
+		{"filled by the server", StatsInterval{Type: StatsIntervalHour}, tz, false, StatsFillZero, false, "$" + offset, "", 0},
+		{"null fill", StatsInterval{Type: StatsIntervalHour}, tz, true, StatsFillNull, false, "$" + offset, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sq := &Stats{
+				Start:     time.Date(2024, 3, 4, 0, 0, 0, 0, tt.tz),
+				End:       time.Date(2024, 3, 10, 23, 59, 59, 0, tt.tz),
+				Timezone:  tt.tz.String(),
+				TZ:        tt.tz,
+				Interval:  tt.interval,
+				Fill:      tt.fill,
+				Densify:   tt.densify,
+				PerEntity: tt.perEntity,
+			}
+			stages := statsBucketStages(sq, "entered", "exited")
+
+			// The buckets are always sorted by time last
+			if last := stages[len(stages)-1]; !reflect.DeepEqual(last, bson.M{"$sort": bson.M{"bucket": 1}}) {
+				t.Errorf("got last stage %v, want a sort by bucket", last)
+			}
+
+			// The bucket date is rebuilt from its parts, in the requested timezone or at the exact offset
+			var bucketTZ interface{}
+			var densify, fill bson.M
+			for _, st := range stages {
+				if f, ok := st["$addFields"].(bson.M); ok {
+					bucketTZ = f["bucket"].(bson.M)["$dateFromParts"].(bson.M)["timezone"]
+				}
+				if d, ok := st["$densify"].(bson.M); ok {
+					densify = d
+				}
+				if f, ok := st["$fill"].(bson.M); ok {
+					fill = f
+				}
+			}
+			if bucketTZ != tt.bucketTZ {
+				t.Errorf("got bucket timezone %v, want %v", bucketTZ, tt.bucketTZ)
+			}
+
+			// The missing buckets are generated only when MongoDB can step through them like the server would
+			if tt.unit == "" {
+				if densify != nil || fill != nil {
+					t.Errorf("got densify %v and fill %v, want none", densify, fill)
+				}
+				return
+			}
+			if densify == nil || fill == nil {
+				t.Fatalf("got densify %v and fill %v, want both", densify, fill)
+			}
+			r := densify["range"].(bson.M)
+			if r["unit"] != tt.unit || r["step"] != tt.step {
+				t.Errorf("got densify range %v, want %d %s", r, tt.step, tt.unit)
+			}
+			first := bucketStart(sq.Start, sq.Interval)
+			bounds := []time.Time{first, nextBucket(bucketStart(sq.End, sq.Interval), sq.Interval)}
+			if !reflect.DeepEqual(r["bounds"], bounds) {
+				t.Errorf("got densify bounds %v, want %v", r["bounds"], bounds)
+			}
+			if _, ok := densify["partitionByFields"]; ok != tt.perEntity {
+				t.Errorf("got densify %v, want partitioned=%t", densify, tt.perEntity)
+			}
+			want := bson.M{"output": bson.M{"entered": bson.M{"value": 0}, "exited": bson.M{"value": 0}}}
+			if !reflect.DeepEqual(fill, want) {
+				t.Errorf("got fill %v, want %v", fill, want)
+			}
+		})
+	}
+}
+
+func TestStatsGatePipeline(t *testing.T) {
+	g := primitive.NewObjectID()
+	sq := &Stats{
+		IDs:       []primitive.ObjectID{g},
+		PerEntity: true,
+		Location:  StatsLocationGate,
+		IsInside:  true,
+		Start:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		End:       time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+		Timezone:  "UTC",
+		TZ:        time.UTC,
+		Interval:  StatsInterval{Type: StatsIntervalHour},
+		Metrics:   []string{StatsMetricEntered},
+	}
+
+	tests := []struct {
+		name    string
+		rollup  bool
+		peaks   bool
+		groups  int
+		entered interface{}
+	}{
+		{"raw events", false, false, 1, statsCrossings(-1)},
+		{"raw events with peaks", false, true, 2, bson.M{"$sum": "$entered"}},
+		{"rollups", true, false, 1, bson.M{"$sum": "$out"}},
+		{"rollups with peaks", true, true, 1, bson.M{"$sum": "$out"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sq.Metrics = []string{StatsMetricEntered}
+			if tt.peaks {
+				sq.Metrics = append(sq.Metrics, StatsMetricPeakEntered)
+			}
+
+			var gs []bson.M
+			for _, st := range statsGatePipeline(sq, tt.rollup) {
+				if g, ok := st["$group"].(bson.M); ok {
+					gs = append(gs, g)
+				}
+			}
+			if len(gs) != tt.groups {
+				t.Fatalf("got %d group stages, want %d", len(gs), tt.groups)
+			}
+
+			// Seen from inside, the entries are the crossings out of the gate's direction
+			last := gs[len(gs)-1]
+			if !reflect.DeepEqual(last["entered"], tt.entered) {
+				t.Errorf("got entered %v, want %v", last["entered"], tt.entered)
+			}
+			if _, ok := last["peak_entered"]; ok != tt.peaks {
+				t.Errorf("got group %v, want peaks=%t", last, tt.peaks)
+			}
+
+			// The buckets are split by gate
+			id := gs[0]["_id"].(bson.M)
+			if tt.peaks && !tt.rollup {
+				id = id["bucket"].(bson.M)
+			}
+			if id[entity] != "$gate_id" {
+				t.Errorf("got group id %v, want it split by gate", id)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// OperationTimeout bounds every DB read and write, on top of the deadline of its caller; zero leaves them unbounded
+	OperationTimeout = 10 * time.Second
+
+	// AggregationTimeout bounds every DB aggregation, on top of the deadline of its caller, and is passed to MongoDB as
+	// its maxTimeMS; zero leaves them unbounded. The schema changes and data conversions are never bounded.
+	AggregationTimeout = time.Minute
+)
+
+// Bound a DB operation by the operation timeout
+func withOperationTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if OperationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, OperationTimeout)
+}
+
+// Bound a DB aggregation by the aggregation timeout
+func withAggregationTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if AggregationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, AggregationTimeout)
+}
+
+// Get the options of a DB aggregation, so MongoDB stops it by itself once the aggregation timeout is exceeded
+func aggregateOptions() *options.AggregateOptions {
+	opts := options.Aggregate()
+	if AggregationTimeout > 0 {
+		opts.SetMaxTime(AggregationTimeout)
+	}
+
+	return opts
+}
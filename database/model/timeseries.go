@@ -0,0 +1,254 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"echo_rest_api/internal"
+)
+
+type (
+	// TimeSeriesInfo holds the kind and data expiry of a raw data collection
+	TimeSeriesInfo struct {
+		TimeSeries bool
+		Expiry     time.Duration
+	}
+
+	// The layout of a raw data collection: the ID of its gate or space and how often it is sampled
+	timeSeriesCollection struct {
+		meta        string
+		granularity string
+	}
+)
+
+const (
+	legacySuffix = "_legacy"
+)
+
+var (
+	// TimeSeriesCollections holds the raw data collections stored as time series, when supported
+	TimeSeriesCollections = []string{eventsCollectionName, spaceResultsCollectionName}
+
+	timeSeriesCollections = map[string]timeSeriesCollection{
+		eventsCollectionName:       {meta: "gate_id", granularity: "seconds"},
+		spaceResultsCollectionName: {meta: "space_id", granularity: "minutes"},
+	}
+)
+
+// TimeSeriesSupported checks if the MongoDB server supports time-series collections (v5.0+)
+func TimeSeriesSupported(ctx context.Context, m *mongo.Database) (bool, error) {
+	v, err := dbVersion(ctx, m)
+	if err != nil {
+		return false, err
+	}
+
+	return len(v) > 0 && v[0] >= 5, nil
+}
+
+// TimeSeriesGet retrieves the kind and data expiry of a raw data collection, or of its legacy copy, from the DB;
+// nil is returned if it does not exist
+func TimeSeriesGet(ctx context.Context, m *mongo.Database, name string, legacy bool) (*TimeSeriesInfo, error) {
+	if legacy {
+		name += legacySuffix
+	}
+
+	specs, err := m.ListCollectionSpecifications(ctx, bson.M{"name": name})
+	if err != nil {
+		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	ts := &TimeSeriesInfo{TimeSeries: specs[0].Type == "timeseries"}
+	if v, err := specs[0].Options.LookupErr("expireAfterSeconds"); err == nil {
+		if s, ok := v.AsInt64OK(); ok {
+			ts.Expiry = time.Duration(s) * time.Second
+		}
+	}
+
+	return ts, nil
+}
+
+// TimeSeriesCreate creates a raw data collection as a time series in the DB, keyed by its gate or space ID; its data
+// expires after the given duration, if any
+func TimeSeriesCreate(ctx context.Context, m *mongo.Database, name string, expiry time.Duration) error {
+	c := timeSeriesCollections[name]
+
+	cmd := bson.D{
+		{Key: "create", Value: name},
+		{Key: "timeseries", Value: bson.D{
+			{Key: "timeField", Value: "timestamp"},
+			{Key: "metaField", Value: c.meta},
+			{Key: "granularity", Value: c.granularity},
+		}},
+	}
+	if expiry > 0 {
+		cmd = append(cmd, bson.E{Key: "expireAfterSeconds", Value: int64(expiry.Seconds())})
+	}
+
+	if err := m.RunCommand(ctx, cmd).Err(); err != nil {
+		return internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	return nil
+}
+
+// TimeSeriesSetExpiry changes the data expiry of a time-series collection in the DB; a zero duration keeps the data
+// forever
+func TimeSeriesSetExpiry(ctx context.Context, m *mongo.Database, name string, expiry time.Duration) error {
+	var v interface{} = "off"
+	if expiry > 0 {
+		v = int64(expiry.Seconds())
+	}
+
+	cmd := bson.D{{Key: "collMod", Value: name}, {Key: "expireAfterSeconds", Value: v}}
+	if err := m.RunCommand(ctx, cmd).Err(); err != nil {
+		return internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	return nil
+}
+
+// TimeSeriesSetAside renames a plain raw data collection to its legacy name in the DB, so a time series can take its place
+func TimeSeriesSetAside(ctx context.Context, m *mongo.Database, name string) error {
+	cmd := bson.D{
+		{Key: "renameCollection", Value: fmt.Sprintf("%s.%s", m.Name(), name)},
+		{Key: "to", Value: fmt.Sprintf("%s.%s", m.Name(), name+legacySuffix)},
+	}
+	if err := m.Client().Database("admin").RunCommand(ctx, cmd).Err(); err != nil {
+		return internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	return nil
+}
+
+// TimeSeriesCopy copies the legacy documents of a raw data collection added after the given one into its time series
+// in the DB, in insertion order; it returns the last copied document, the zero ID if there are none left. The documents
+// already copied before an interruption are skipped, if resuming.
+func TimeSeriesCopy(ctx context.Context, m *mongo.Database, name string, id primitive.ObjectID, limit int64, resume bool) (primitive.ObjectID, error) {
+	var docs []bson.Raw
+
+	// Create a DB connection
+	src, dst := m.Collection(name+legacySuffix), m.Collection(name)
+
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(limit)
+	cur, err := src.Find(ctx, bson.M{"_id": bson.M{"$gt": id}}, opts)
+	if err != nil {
+		return primitive.NilObjectID, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+	if err = cur.All(ctx, &docs); err != nil {
+		return primitive.NilObjectID, internal.NewError(internal.ErrDBDecode, err, 1)
+	}
+	if len(docs) == 0 {
+		return primitive.NilObjectID, nil
+	}
+
+	// Time series have no unique IDs, so leave out the documents copied right before an interruption
+	ids := make([]primitive.ObjectID, len(docs))
+	for i, d := range docs {
+		ids[i], _ = d.Lookup("_id").ObjectIDOK()
+	}
+	last := ids[len(ids)-1]
+
+	if resume {
+		var copied []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+
+		cur, err = dst.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return primitive.NilObjectID, internal.NewError(internal.ErrDBQuery, err, 1)
+		}
+		if err = cur.All(ctx, &copied); err != nil {
+			return primitive.NilObjectID, internal.NewError(internal.ErrDBDecode, err, 1)
+		}
+
+		skip := make(map[primitive.ObjectID]bool, len(copied))
+		for _, c := range copied {
+			skip[c.ID] = true
+		}
+
+		n := 0
+		for i, d := range docs {
+			if !skip[ids[i]] {
+				docs[n] = d
+				n++
+			}
+		}
+		docs = docs[:n]
+	}
+
+	if len(docs) > 0 {
+		batch := make([]interface{}, len(docs))
+		for i, d := range docs {
+			batch[i] = d
+		}
+
+		if _, err = dst.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false)); err != nil {
+			return primitive.NilObjectID, internal.NewError(internal.ErrDBInsert, err, 1)
+		}
+	}
+
+	return last, nil
+}
+
+// TimeSeriesDropLegacy deletes the legacy copy of a raw data collection from the DB, once fully copied
+func TimeSeriesDropLegacy(ctx context.Context, m *mongo.Database, name string) error {
+	if err := m.Collection(name + legacySuffix).Drop(ctx); err != nil {
+		return internal.NewError(internal.ErrDBDelete, err, 1)
+	}
+
+	return nil
+}
+
+// TimeSeriesEnsureIndex creates the index of a raw data collection used by the stats and alerts in the DB, if missing:
+// its gate or space ID with the timestamp
+func TimeSeriesEnsureIndex(ctx context.Context, m *mongo.Database, name string) error {
+	c := timeSeriesCollections[name]
+
+	// Create a DB connection
+	db := m.Collection(name)
+
+	idx := mongo.IndexModel{Keys: bson.D{{Key: c.meta, Value: 1}, {Key: "timestamp", Value: 1}}}
+	if _, err := db.Indexes().CreateOne(ctx, idx); err != nil {
+		return internal.NewError(internal.ErrDBIndex, err, 1)
+	}
+
+	return nil
+}
+
+// TimeSeriesEnsureIDIndex creates the index of a time series on the insertion order used by the tailers in the DB, if
+// missing; time series have none by default, and only support it since MongoDB v6.0
+func TimeSeriesEnsureIDIndex(ctx context.Context, m *mongo.Database, name string) error {
+	// Create a DB connection
+	db := m.Collection(name)
+
+	if _, err := db.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.M{"_id": 1}}); err != nil {
+		return internal.NewError(internal.ErrDBIndex, err, 1)
+	}
+
+	return nil
+}
+
+// Get the version of the MongoDB server, as its major, minor and patch numbers
+func dbVersion(ctx context.Context, m *mongo.Database) ([]int32, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	var info struct {
+		Version []int32 `bson:"versionArray"`
+	}
+
+	if err := m.RunCommand(ctx, bson.M{"buildInfo": 1}).Decode(&info); err != nil {
+		return nil, internal.NewError(internal.ErrDBQuery, err, 2)
+	}
+
+	return info.Version, nil
+}
@@ -6,6 +6,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"echo_rest_api/internal"
 	"echo_rest_api/security"
@@ -59,14 +60,17 @@ const (
 )
 
 // UserFind checks if a user is found based on given email and password in the DB
-func UserFind(m *mongo.Database, u *User) error {
+func UserFind(ctx context.Context, m *mongo.Database, u *User) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
 	// Save the raw password for later use
 	rawPassword := u.Password
 
 	// Create a DB connection
 	db := m.Collection(usersCollectionName)
 
-	if err := db.FindOne(context.TODO(), bson.M{"email": u.Email}).Decode(&u); err != nil {
+	if err := db.FindOne(ctx, bson.M{"email": u.Email}).Decode(&u); err != nil {
 		if err == mongo.ErrNoDocuments {
 			return internal.NewError(internal.ErrDBNoData, err, 1)
 		}
@@ -75,7 +79,7 @@ func UserFind(m *mongo.Database, u *User) error {
 	}
 
 	// Create the hashed password for the current user
-	hashedPassword := security.HashPassword(rawPassword, u.Salt)
+	hashedPassword := security.HashPassword(ctx, rawPassword, u.Salt)
 
 	// Check if hashed passwords match
 	if u.Password != hashedPassword {
@@ -86,11 +90,14 @@ func UserFind(m *mongo.Database, u *User) error {
 }
 
 // UserFindRoot checks if the root user account is already available in the DB
-func UserFindRoot(m *mongo.Database, email string) (bool, error) {
+func UserFindRoot(ctx context.Context, m *mongo.Database, email string) (bool, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
 	// Create a DB connection
 	db := m.Collection(usersCollectionName)
 
-	count, err := db.CountDocuments(context.TODO(), bson.M{"email": email})
+	count, err := db.CountDocuments(ctx, bson.M{"email": email})
 	if err != nil && err != mongo.ErrNoDocuments {
 		return false, internal.NewError(internal.ErrDBQuery, err, 1)
 	}
@@ -103,11 +110,14 @@ func UserFindRoot(m *mongo.Database, email string) (bool, error) {
 }
 
 // UserEmailExists checks if a user account is already registered to a given email in the DB
-func UserEmailExists(m *mongo.Database, i *Invite) error {
+func UserEmailExists(ctx context.Context, m *mongo.Database, i *Invite) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
 	// Create a DB connection
 	db := m.Collection(usersCollectionName)
 
-	count, err := db.CountDocuments(context.TODO(), bson.M{"email": i.Email})
+	count, err := db.CountDocuments(ctx, bson.M{"email": i.Email})
 	if err != nil && err != mongo.ErrNoDocuments {
 		return internal.NewError(internal.ErrDBQuery, err, 1)
 	}
@@ -120,13 +130,20 @@ func UserEmailExists(m *mongo.Database, i *Invite) error {
 }
 
 // UserCreate creates a new user in the DB
-func UserCreate(m *mongo.Database, u *User) error {
+func UserCreate(ctx context.Context, m *mongo.Database, u *User) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
 	// Create a DB connection
 	db := m.Collection(usersCollectionName)
 
-	// Add the user to the DB
-	newUser, err := db.InsertOne(context.TODO(), u)
+	// Add the user to the DB; the email may have been registered since it was checked
+	newUser, err := db.InsertOne(ctx, u)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return internal.NewError(internal.ErrBEUserExists, err, 1)
+		}
+
 		return internal.NewError(internal.ErrDBInsert, err, 1)
 	}
 
@@ -134,7 +151,7 @@ func UserCreate(m *mongo.Database, u *User) error {
 	u.ID = newUser.InsertedID.(primitive.ObjectID)
 
 	// Update the creating user
-	_, err = db.UpdateOne(context.TODO(), bson.M{"_id": u.CreatedBy}, bson.M{"$push": bson.M{"created_users": u.ID}})
+	_, err = db.UpdateOne(ctx, bson.M{"_id": u.CreatedBy}, bson.M{"$push": bson.M{"created_users": u.ID}})
 	if err != nil {
 		return internal.NewError(internal.ErrDBUpdate, err, 1)
 	}
@@ -143,12 +160,15 @@ func UserCreate(m *mongo.Database, u *User) error {
 }
 
 // UserCreateRoot creates a new root user in the DB
-func UserCreateRoot(m *mongo.Database, u *User) error {
+func UserCreateRoot(ctx context.Context, m *mongo.Database, u *User) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
 	// Create a DB connection
 	db := m.Collection(usersCollectionName)
 
 	// Add the user to the DB
-	_, err := db.InsertOne(context.TODO(), u)
+	_, err := db.InsertOne(ctx, u)
 	if err != nil {
 		return internal.NewError(internal.ErrDBInsert, err, 1)
 	}
@@ -157,11 +177,14 @@ func UserCreateRoot(m *mongo.Database, u *User) error {
 }
 
 // UserValidateInvite checks if an invite token is still available in the DB
-func UserValidateInvite(m *mongo.Database, i *ValidateInvite) error {
+func UserValidateInvite(ctx context.Context, m *mongo.Database, i *ValidateInvite) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
 	// Create a DB connection
 	db := m.Collection(usersCollectionName)
 
-	count, err := db.CountDocuments(context.TODO(), bson.M{"invite_token": i.InviteToken})
+	count, err := db.CountDocuments(ctx, bson.M{"invite_token": i.InviteToken})
 	if err != nil && err != mongo.ErrNoDocuments {
 		return internal.NewError(internal.ErrDBQuery, err, 1)
 	}
@@ -173,37 +196,50 @@ func UserValidateInvite(m *mongo.Database, i *ValidateInvite) error {
 	return nil
 }
 
-// UserSignUp activates an invited account and set the password and salt in the DB
-func UserSignUp(m *mongo.Database, s *SignUp) error {
+// UserSignUp activates an invited account and set the password and salt in the DB, returning the activated user
+func UserSignUp(ctx context.Context, m *mongo.Database, s *SignUp) (*UserMinimalData, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	u := new(UserMinimalData)
+
 	// Create a DB connection
 	db := m.Collection(usersCollectionName)
 
-	_, err := db.UpdateOne(context.TODO(), bson.M{"invite_token": s.InviteToken}, bson.M{
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := db.FindOneAndUpdate(ctx, bson.M{"invite_token": s.InviteToken}, bson.M{
 		"$set":   bson.M{"password": s.Password, "salt": s.Salt, "active": true},
 		"$unset": bson.M{"invite_token": ""},
-	})
+	}, opts).Decode(u)
 	if err != nil {
-		return internal.NewError(internal.ErrDBUpdate, err, 1)
+		if err == mongo.ErrNoDocuments {
+			return nil, internal.NewError(internal.ErrBEInvalidInvite, err, 1)
+		}
+
+		return nil, internal.NewError(internal.ErrDBUpdate, err, 1)
 	}
 
-	return nil
+	return u, nil
 }
 
 // UserGetAll retrieves all users (except from the given ID) from the DB
-func UserGetAll(m *mongo.Database, id primitive.ObjectID) ([]UserMinimalData, error) {
+func UserGetAll(ctx context.Context, m *mongo.Database, id primitive.ObjectID) ([]UserMinimalData, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
 	var u []UserMinimalData
 
 	// Create a DB connection
 	db := m.Collection(usersCollectionName)
 
 	// Find all users, except for the given ID
-	cur, err := db.Find(context.TODO(), bson.M{"_id": bson.M{"$ne": id}})
+	cur, err := db.Find(ctx, bson.M{"_id": bson.M{"$ne": id}})
 	if err != nil {
 		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
 	}
 
 	// Decode all found information
-	for cur.Next(context.TODO()) {
+	for cur.Next(ctx) {
 		var elem UserMinimalData
 
 		err = cur.Decode(&elem)
@@ -220,7 +256,7 @@ func UserGetAll(m *mongo.Database, id primitive.ObjectID) ([]UserMinimalData, er
 	}
 
 	// Close the cursor once finished
-	if err = cur.Close(context.TODO()); err != nil {
+	if err = cur.Close(ctx); err != nil {
 		return nil, internal.NewError(internal.ErrDBCursorClose, err, 1)
 	}
 
@@ -233,12 +269,15 @@ func UserGetAll(m *mongo.Database, id primitive.ObjectID) ([]UserMinimalData, er
 }
 
 // UserUpdate updates a given user data in the DB
-func UserUpdate(m *mongo.Database, u *UserUpdateData) error {
+func UserUpdate(ctx context.Context, m *mongo.Database, u *UserUpdateData) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
 	// Create a DB connection
 	db := m.Collection(usersCollectionName)
 
 	// Set the new values for active and role
-	r, err := db.UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{
+	r, err := db.UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{
 		"$set": bson.M{"active": u.Active, "role": u.Role},
 	})
 	if err != nil {
@@ -254,12 +293,15 @@ func UserUpdate(m *mongo.Database, u *UserUpdateData) error {
 }
 
 // UserDelete deletes a user based on the given ID in the DB
-func UserDelete(m *mongo.Database, id primitive.ObjectID) error {
+func UserDelete(ctx context.Context, m *mongo.Database, id primitive.ObjectID) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
 	// Create a DB connection
 	db := m.Collection(usersCollectionName)
 
 	// Delete the user from the DB
-	_, err := db.DeleteOne(context.TODO(), bson.M{"_id": id})
+	_, err := db.DeleteOne(ctx, bson.M{"_id": id})
 	if err != nil {
 		return internal.NewError(internal.ErrDBDelete, err, 1)
 	}
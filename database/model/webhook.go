@@ -0,0 +1,450 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"echo_rest_api/internal"
+)
+
+type (
+	Webhook struct {
+		ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+		URL       string             `bson:"url" json:"url" validate:"required,url"`
+		Secret    string             `bson:"secret" json:"secret,omitempty" validate:"required,min=16"`
+		Events    []string           `bson:"events" json:"events" validate:"required,min=1,dive,oneof=user.invited user.activated user.updated user.deleted gate.crossing alert.firing alert.resolved"`
+		Active    bool               `bson:"active" json:"active"`
+		CreatedBy primitive.ObjectID `bson:"created_by" json:"-"`
+	}
+
+	// WebhookMessage is an event waiting in the outbox to be delivered to a webhook
+	WebhookMessage struct {
+		ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+		WebhookID   primitive.ObjectID `bson:"webhook_id" json:"webhookId"`
+		Event       string             `bson:"event" json:"event"`
+		Payload     string             `bson:"payload" json:"payload"`
+		Status      string             `bson:"status" json:"status"`
+		Attempts    int                `bson:"attempts" json:"attempts"`
+		CreatedAt   time.Time          `bson:"created_at" json:"createdAt"`
+		NextAttempt time.Time          `bson:"next_attempt" json:"nextAttempt"`
+	}
+
+	WebhookDelivery struct {
+		ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+		MessageID  primitive.ObjectID `bson:"message_id" json:"messageId"`
+		WebhookID  primitive.ObjectID `bson:"webhook_id" json:"webhookId"`
+		Event      string             `bson:"event" json:"event"`
+		Attempt    int                `bson:"attempt" json:"attempt"`
+		Time       time.Time          `bson:"time" json:"time"`
+		Duration   int64              `bson:"duration_ms" json:"durationMs"`
+		StatusCode int                `bson:"status_code,omitempty" json:"statusCode,omitempty"`
+		Error      string             `bson:"error,omitempty" json:"error,omitempty"`
+	}
+
+	webhookPayload struct {
+		ID    primitive.ObjectID `json:"id"`
+		Event string             `json:"event"`
+		Time  time.Time          `json:"time"`
+		Data  interface{}        `json:"data"`
+	}
+)
+
+const (
+	WebhookEventUserInvited   = "user.invited"   // A user was invited
+	WebhookEventUserActivated = "user.activated" // An invited user signed up
+	WebhookEventUserUpdated   = "user.updated"   // The role or activation of a user changed
+	WebhookEventUserDeleted   = "user.deleted"   // A user was deleted
+	WebhookEventGateCrossing  = "gate.crossing"  // A gate reported a crossing
+	WebhookEventAlertFiring   = "alert.firing"   // An alert condition is met
+	WebhookEventAlertResolved = "alert.resolved" // An alert condition is no longer met
+	WebhookEventTest          = "test"           // A test event, sent on request
+)
+
+const (
+	WebhookMessagePending   = "pending"   // Waiting for its next delivery attempt
+	WebhookMessageDelivered = "delivered" // Accepted by the webhook
+	WebhookMessageFailed    = "failed"    // Given up after too many attempts
+)
+
+const (
+	webhooksCollectionName          = "webhooks"
+	webhookOutboxCollectionName     = "webhook_outbox"
+	webhookDeliveriesCollectionName = "webhook_deliveries"
+	maxWebhookDeliveries            = 100
+)
+
+// WebhookCreate creates a new webhook subscription in the DB
+func WebhookCreate(ctx context.Context, m *mongo.Database, w *Webhook) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(webhooksCollectionName)
+
+	// Add the webhook to the DB
+	newWebhook, err := db.InsertOne(ctx, w)
+	if err != nil {
+		return internal.NewError(internal.ErrDBInsert, err, 1)
+	}
+
+	// Save the ID of the new webhook
+	w.ID = newWebhook.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// WebhookGet retrieves a webhook subscription based on the given ID from the DB
+func WebhookGet(ctx context.Context, m *mongo.Database, id primitive.ObjectID) (*Webhook, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	w := new(Webhook)
+
+	// Create a DB connection
+	db := m.Collection(webhooksCollectionName)
+
+	if err := db.FindOne(ctx, bson.M{"_id": id}).Decode(w); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, internal.NewError(internal.ErrDBNoData, err, 1)
+		}
+
+		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	return w, nil
+}
+
+// WebhookGetAll retrieves all webhook subscriptions from the DB
+func WebhookGetAll(ctx context.Context, m *mongo.Database) ([]Webhook, error) {
+	return webhookFind(ctx, m, bson.M{})
+}
+
+// WebhookGetSubscribers retrieves the active webhook subscriptions to an event from the DB
+func WebhookGetSubscribers(ctx context.Context, m *mongo.Database, event string) ([]Webhook, error) {
+	return webhookFind(ctx, m, bson.M{"active": true, "events": event})
+}
+
+// Find the webhook subscriptions matching a filter in the DB
+func webhookFind(ctx context.Context, m *mongo.Database, filter bson.M) ([]Webhook, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	w := []Webhook{}
+
+	// Create a DB connection
+	db := m.Collection(webhooksCollectionName)
+
+	cur, err := db.Find(ctx, filter)
+	if err != nil {
+		return nil, internal.NewError(internal.ErrDBQuery, err, 2)
+	}
+
+	// Decode all found information
+	for cur.Next(ctx) {
+		var elem Webhook
+
+		err = cur.Decode(&elem)
+		if err != nil {
+			return nil, internal.NewError(internal.ErrDBDecode, err, 2)
+		}
+
+		w = append(w, elem)
+	}
+
+	// Check if any errors occurred
+	if err = cur.Err(); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorIterate, err, 2)
+	}
+
+	// Close the cursor once finished
+	if err = cur.Close(ctx); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorClose, err, 2)
+	}
+
+	return w, nil
+}
+
+// WebhookUpdate updates a given webhook subscription in the DB
+func WebhookUpdate(ctx context.Context, m *mongo.Database, w *Webhook) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(webhooksCollectionName)
+
+	res, err := db.UpdateOne(ctx, bson.M{"_id": w.ID}, bson.M{
+		"$set": bson.M{"url": w.URL, "secret": w.Secret, "events": w.Events, "active": w.Active},
+	})
+	if err != nil {
+		return internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	// If no webhook was found, return an error
+	if res.MatchedCount == 0 {
+		return internal.NewError(internal.ErrDBNoUpdate, err, 1)
+	}
+
+	return nil
+}
+
+// WebhookDelete deletes a webhook subscription, its pending messages and its delivery log based on the given ID in the DB
+func WebhookDelete(ctx context.Context, m *mongo.Database, id primitive.ObjectID) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(webhooksCollectionName)
+
+	// Delete the webhook from the DB
+	res, err := db.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return internal.NewError(internal.ErrDBDelete, err, 1)
+	}
+
+	// If no webhook was found, return an error
+	if res.DeletedCount == 0 {
+		return internal.NewError(internal.ErrDBNoData, err, 1)
+	}
+
+	// Delete the outbox messages and delivery log of the webhook
+	for _, coll := range []string{webhookOutboxCollectionName, webhookDeliveriesCollectionName} {
+		if _, err = m.Collection(coll).DeleteMany(ctx, bson.M{"webhook_id": id}); err != nil {
+			return internal.NewError(internal.ErrDBDelete, err, 1)
+		}
+	}
+
+	return nil
+}
+
+// WebhookPublish adds an event to the outbox of every active webhook subscribed to it in the DB
+func WebhookPublish(ctx context.Context, m *mongo.Database, event string, data interface{}) error {
+	w, err := WebhookGetSubscribers(ctx, m, event)
+	if err != nil {
+		return err
+	}
+
+	_, err = WebhookEnqueue(ctx, m, w, event, data)
+	return err
+}
+
+// WebhookEnqueue adds an event to the outbox of the given webhooks in the DB, to be delivered as soon as possible
+func WebhookEnqueue(ctx context.Context, m *mongo.Database, w []Webhook, event string, data interface{}) ([]primitive.ObjectID, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	if len(w) == 0 {
+		return nil, nil
+	}
+
+	// Create a DB connection
+	db := m.Collection(webhookOutboxCollectionName)
+
+	t := time.Now()
+	docs := make([]interface{}, 0, len(w))
+	for _, wh := range w {
+		msg, err := newWebhookMessage(primitive.NewObjectID(), wh.ID, event, t, data)
+		if err != nil {
+			return nil, err
+		}
+
+		docs = append(docs, msg)
+	}
+
+	res, err := db.InsertMany(ctx, docs)
+	if err != nil {
+		return nil, internal.NewError(internal.ErrDBInsert, err, 1)
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(res.InsertedIDs))
+	for _, id := range res.InsertedIDs {
+		ids = append(ids, id.(primitive.ObjectID))
+	}
+
+	return ids, nil
+}
+
+// WebhookEnqueueOnce adds an event to the outbox of the given webhooks in the DB like WebhookEnqueue, the messages being
+// identified by the source of the event, so adding the same event again has no effect
+func WebhookEnqueueOnce(ctx context.Context, m *mongo.Database, w []Webhook, event string, source primitive.ObjectID, data interface{}) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	if len(w) == 0 {
+		return nil
+	}
+
+	// Create a DB connection
+	db := m.Collection(webhookOutboxCollectionName)
+
+	// Only insert the messages not added yet
+	t := time.Now()
+	models := make([]mongo.WriteModel, 0, len(w))
+	for _, wh := range w {
+		msg, err := newWebhookMessage(webhookMessageID(source, wh.ID), wh.ID, event, t, data)
+		if err != nil {
+			return err
+		}
+
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": msg.ID}).
+			SetUpdate(bson.M{"$setOnInsert": msg}).
+			SetUpsert(true))
+	}
+
+	if _, err := db.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return internal.NewError(internal.ErrDBInsert, err, 1)
+	}
+
+	return nil
+}
+
+// Create an outbox message, serializing its payload once so every delivery attempt sends the same body
+func newWebhookMessage(id, webhookID primitive.ObjectID, event string, t time.Time, data interface{}) (*WebhookMessage, error) {
+	msg := &WebhookMessage{
+		ID:          id,
+		WebhookID:   webhookID,
+		Event:       event,
+		Status:      WebhookMessagePending,
+		CreatedAt:   t,
+		NextAttempt: t,
+	}
+
+	b, err := json.Marshal(&webhookPayload{ID: msg.ID, Event: event, Time: t, Data: data})
+	if err != nil {
+		return nil, internal.NewError(internal.ErrBEWebhookPayload, err, 1)
+	}
+	msg.Payload = string(b)
+
+	return msg, nil
+}
+
+// Get the ID of the message of an event source to a webhook, keeping the creation time of the source
+func webhookMessageID(source, webhookID primitive.ObjectID) primitive.ObjectID {
+	h := sha256.Sum256(append(source[:], webhookID[:]...))
+
+	var id primitive.ObjectID
+	copy(id[:4], source[:4])
+	copy(id[4:], h[:])
+
+	return id
+}
+
+// WebhookClaimDue takes the next outbox message due at the given time from the DB, skipping the given webhooks, and
+// hides it from other servers for the lease duration; nil is returned when none is due
+func WebhookClaimDue(ctx context.Context, m *mongo.Database, t time.Time, lease time.Duration, skip []primitive.ObjectID) (*WebhookMessage, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	msg := new(WebhookMessage)
+
+	// Create a DB connection
+	db := m.Collection(webhookOutboxCollectionName)
+
+	filter := bson.M{"status": WebhookMessagePending, "next_attempt": bson.M{"$lte": t}}
+	if len(skip) > 0 {
+		filter["webhook_id"] = bson.M{"$nin": skip}
+	}
+
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"next_attempt": 1}).SetReturnDocument(options.After)
+	err := db.FindOneAndUpdate(ctx,
+		filter,
+		bson.M{"$set": bson.M{"next_attempt": t.Add(lease)}, "$inc": bson.M{"attempts": 1}},
+		opts,
+	).Decode(msg)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+
+		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	return msg, nil
+}
+
+// WebhookMessageUpdate sets the status and next attempt of an outbox message in the DB
+func WebhookMessageUpdate(ctx context.Context, m *mongo.Database, msg *WebhookMessage) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(webhookOutboxCollectionName)
+
+	_, err := db.UpdateOne(ctx, bson.M{"_id": msg.ID}, bson.M{
+		"$set": bson.M{"status": msg.Status, "next_attempt": msg.NextAttempt},
+	})
+	if err != nil {
+		return internal.NewError(internal.ErrDBUpdate, err, 1)
+	}
+
+	return nil
+}
+
+// WebhookDeliveryCreate logs a delivery attempt in the DB
+func WebhookDeliveryCreate(ctx context.Context, m *mongo.Database, d *WebhookDelivery) error {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	// Create a DB connection
+	db := m.Collection(webhookDeliveriesCollectionName)
+
+	// Add the delivery to the DB
+	newDelivery, err := db.InsertOne(ctx, d)
+	if err != nil {
+		return internal.NewError(internal.ErrDBInsert, err, 1)
+	}
+
+	// Save the ID of the new delivery
+	d.ID = newDelivery.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// WebhookDeliveryGetAll retrieves the latest delivery attempts of a given webhook from the DB
+func WebhookDeliveryGetAll(ctx context.Context, m *mongo.Database, id primitive.ObjectID) ([]WebhookDelivery, error) {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+
+	d := []WebhookDelivery{}
+
+	// Create a DB connection
+	db := m.Collection(webhookDeliveriesCollectionName)
+
+	// Find the latest deliveries first
+	opts := options.Find().SetSort(bson.M{"time": -1}).SetLimit(maxWebhookDeliveries)
+	cur, err := db.Find(ctx, bson.M{"webhook_id": id}, opts)
+	if err != nil {
+		return nil, internal.NewError(internal.ErrDBQuery, err, 1)
+	}
+
+	// Decode all found information
+	for cur.Next(ctx) {
+		var elem WebhookDelivery
+
+		err = cur.Decode(&elem)
+		if err != nil {
+			return nil, internal.NewError(internal.ErrDBDecode, err, 1)
+		}
+
+		d = append(d, elem)
+	}
+
+	// Check if any errors occurred
+	if err = cur.Err(); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorIterate, err, 1)
+	}
+
+	// Close the cursor once finished
+	if err = cur.Close(ctx); err != nil {
+		return nil, internal.NewError(internal.ErrDBCursorClose, err, 1)
+	}
+
+	return d, nil
+}
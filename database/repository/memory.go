@@ -0,0 +1,624 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"echo_rest_api/database/model"
+	"echo_rest_api/internal"
+	"echo_rest_api/security"
+)
+
+type (
+	// Memory holds the in-memory repositories, which keep their data in process for tests and local runs
+	Memory struct {
+		Users    *MemoryUsers
+		Sites    *MemorySites
+		Stats    *MemoryStats
+		Reports  *MemoryReports
+		Alerts   *MemoryAlerts
+		Webhooks *MemoryWebhooks
+	}
+
+	// MemoryUsers is an in-memory UserRepository
+	MemoryUsers struct {
+		mu    sync.Mutex
+		users []model.User
+	}
+
+	// MemorySites is an in-memory SiteRepository
+	MemorySites struct {
+		mu    sync.Mutex
+		sites []model.Site
+	}
+
+	// MemoryReports is an in-memory ReportRepository
+	MemoryReports struct {
+		mu         sync.Mutex
+		reports    []model.Report
+		deliveries []model.ReportDelivery
+	}
+
+	// MemoryAlerts is an in-memory AlertRepository
+	MemoryAlerts struct {
+		mu     sync.Mutex
+		alerts []model.Alert
+		events []model.AlertEvent
+	}
+
+	// MemoryWebhooks is an in-memory WebhookRepository
+	MemoryWebhooks struct {
+		mu         sync.Mutex
+		webhooks   []model.Webhook
+		outbox     []model.WebhookMessage
+		deliveries []model.WebhookDelivery
+	}
+)
+
+// NewMemory creates empty in-memory repositories
+func NewMemory() *Memory {
+	return &Memory{
+		Users:    &MemoryUsers{},
+		Sites:    &MemorySites{},
+		Stats:    &MemoryStats{},
+		Reports:  &MemoryReports{},
+		Alerts:   &MemoryAlerts{},
+		Webhooks: &MemoryWebhooks{},
+	}
+}
+
+// Repositories gets the in-memory repositories behind their interfaces
+func (mem *Memory) Repositories() *Repositories {
+	return &Repositories{
+		Users:    mem.Users,
+		Sites:    mem.Sites,
+		Stats:    mem.Stats,
+		Reports:  mem.Reports,
+		Alerts:   mem.Alerts,
+		Webhooks: mem.Webhooks,
+	}
+}
+
+// Users
+
+// Add stores a user as it is, with a new ID if it has none
+func (r *MemoryUsers) Add(u *model.User) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	r.users = append(r.users, *u)
+}
+
+// Get gets a copy of a stored user; nil is returned if it does not exist
+func (r *MemoryUsers) Get(id primitive.ObjectID) *model.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i := r.index(func(u *model.User) bool { return u.ID == id }); i >= 0 {
+		u := r.users[i]
+		return &u
+	}
+
+	return nil
+}
+
+func (r *MemoryUsers) Find(ctx context.Context, u *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.index(func(s *model.User) bool { return s.Email == u.Email })
+	if i < 0 {
+		return internal.NewError(internal.ErrDBNoData, nil, 1)
+	}
+
+	// Check if hashed passwords match
+	rawPassword := u.Password
+	*u = r.users[i]
+	if u.Password != security.HashPassword(ctx, rawPassword, u.Salt) {
+		return internal.NewError(internal.ErrBEInvalidPassword, nil, 1)
+	}
+
+	return nil
+}
+
+func (r *MemoryUsers) GetAll(ctx context.Context, id primitive.ObjectID) ([]model.UserMinimalData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var u []model.UserMinimalData
+	for _, s := range r.users {
+		if s.ID != id {
+			u = append(u, model.UserMinimalData{ID: s.ID, Email: s.Email, Role: s.Role, Active: s.Active})
+		}
+	}
+
+	if len(u) == 0 {
+		return nil, internal.NewError(internal.ErrDBNoData, nil, 1)
+	}
+
+	return u, nil
+}
+
+func (r *MemoryUsers) EmailExists(ctx context.Context, i *model.Invite) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.index(func(u *model.User) bool { return u.Email == i.Email }) >= 0 {
+		return internal.NewError(internal.ErrBEUserExists, nil, 1)
+	}
+
+	return nil
+}
+
+func (r *MemoryUsers) Create(ctx context.Context, u *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The emails are unique
+	if r.index(func(s *model.User) bool { return s.Email == u.Email }) >= 0 {
+		return internal.NewError(internal.ErrBEUserExists, nil, 1)
+	}
+
+	u.ID = primitive.NewObjectID()
+	r.users = append(r.users, *u)
+
+	// Update the creating user
+	if i := r.index(func(s *model.User) bool { return s.ID == u.CreatedBy }); i >= 0 {
+		r.users[i].CreatedUsers = append(r.users[i].CreatedUsers, u.ID)
+	}
+
+	return nil
+}
+
+func (r *MemoryUsers) ValidateInvite(ctx context.Context, i *model.ValidateInvite) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.index(func(u *model.User) bool { return u.InviteToken == i.InviteToken }) < 0 {
+		return internal.NewError(internal.ErrBEInvalidInvite, nil, 1)
+	}
+
+	return nil
+}
+
+func (r *MemoryUsers) SignUp(ctx context.Context, s *model.SignUp) (*model.UserMinimalData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.index(func(u *model.User) bool { return u.InviteToken == s.InviteToken })
+	if i < 0 {
+		return nil, internal.NewError(internal.ErrBEInvalidInvite, nil, 1)
+	}
+
+	u := &r.users[i]
+	u.Password, u.Salt, u.Active, u.InviteToken = s.Password, s.Salt, true, ""
+
+	return &model.UserMinimalData{ID: u.ID, Email: u.Email, Role: u.Role, Active: u.Active}, nil
+}
+
+func (r *MemoryUsers) Update(ctx context.Context, u *model.UserUpdateData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Updates without any change fail, as in MongoDB
+	i := r.index(func(s *model.User) bool { return s.ID == u.ID })
+	if i < 0 || r.users[i].Role == u.Role && r.users[i].Active == u.Active {
+		return internal.NewError(internal.ErrDBNoUpdate, nil, 1)
+	}
+
+	r.users[i].Role, r.users[i].Active = u.Role, u.Active
+
+	return nil
+}
+
+func (r *MemoryUsers) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i := r.index(func(u *model.User) bool { return u.ID == id }); i >= 0 {
+		r.users = append(r.users[:i], r.users[i+1:]...)
+	}
+
+	return nil
+}
+
+// Get the position of the first user matching a condition; -1 if none does
+func (r *MemoryUsers) index(match func(u *model.User) bool) int {
+	for i := range r.users {
+		if match(&r.users[i]) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Sites
+
+// Add stores a site as it is, with a new ID if it has none
+func (r *MemorySites) Add(s *model.Site) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s.ID.IsZero() {
+		s.ID = primitive.NewObjectID()
+	}
+	r.sites = append(r.sites, *s)
+}
+
+func (r *MemorySites) Get(ctx context.Context, id primitive.ObjectID) (*model.Site, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.sites {
+		if s.ID == id {
+			return &s, nil
+		}
+	}
+
+	return nil, internal.NewError(internal.ErrDBNoData, nil, 1)
+}
+
+// Reports
+
+// AddDelivery stores a delivery of a report
+func (r *MemoryReports) AddDelivery(d *model.ReportDelivery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d.ID = primitive.NewObjectID()
+	r.deliveries = append(r.deliveries, *d)
+}
+
+func (r *MemoryReports) Create(ctx context.Context, rp *model.Report) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rp.ID = primitive.NewObjectID()
+	r.reports = append(r.reports, *rp)
+
+	return nil
+}
+
+func (r *MemoryReports) Get(ctx context.Context, id primitive.ObjectID) (*model.Report, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rp := range r.reports {
+		if rp.ID == id {
+			return &rp, nil
+		}
+	}
+
+	return nil, internal.NewError(internal.ErrDBNoData, nil, 1)
+}
+
+func (r *MemoryReports) GetAll(ctx context.Context) ([]model.Report, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rp := append([]model.Report{}, r.reports...)
+	sort.SliceStable(rp, func(i, j int) bool { return rp[i].Name < rp[j].Name })
+
+	return rp, nil
+}
+
+func (r *MemoryReports) Update(ctx context.Context, rp *model.Report) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Replace all settings, keeping the creator and the last run
+	for i := range r.reports {
+		if r.reports[i].ID == rp.ID {
+			u := *rp
+			u.CreatedBy, u.LastRun = r.reports[i].CreatedBy, r.reports[i].LastRun
+			r.reports[i] = u
+			return nil
+		}
+	}
+
+	return internal.NewError(internal.ErrDBNoUpdate, nil, 1)
+}
+
+func (r *MemoryReports) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.reports {
+		if r.reports[i].ID == id {
+			r.reports = append(r.reports[:i], r.reports[i+1:]...)
+
+			// Delete the delivery history of the report
+			d := r.deliveries[:0]
+			for _, rd := range r.deliveries {
+				if rd.ReportID != id {
+					d = append(d, rd)
+				}
+			}
+			r.deliveries = d
+
+			return nil
+		}
+	}
+
+	return internal.NewError(internal.ErrDBNoData, nil, 1)
+}
+
+func (r *MemoryReports) GetDeliveries(ctx context.Context, id primitive.ObjectID) ([]model.ReportDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The latest deliveries first
+	d := []model.ReportDelivery{}
+	for i := len(r.deliveries) - 1; i >= 0; i-- {
+		if r.deliveries[i].ReportID == id {
+			d = append(d, r.deliveries[i])
+		}
+	}
+
+	return d, nil
+}
+
+// Alerts
+
+// AddEvent stores a state change of an alert
+func (r *MemoryAlerts) AddEvent(e *model.AlertEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.ID = primitive.NewObjectID()
+	r.events = append(r.events, *e)
+}
+
+func (r *MemoryAlerts) Create(ctx context.Context, a *model.Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a.ID = primitive.NewObjectID()
+	r.alerts = append(r.alerts, *a)
+
+	return nil
+}
+
+func (r *MemoryAlerts) Get(ctx context.Context, id primitive.ObjectID) (*model.Alert, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, a := range r.alerts {
+		if a.ID == id {
+			return &a, nil
+		}
+	}
+
+	return nil, internal.NewError(internal.ErrDBNoData, nil, 1)
+}
+
+func (r *MemoryAlerts) GetAll(ctx context.Context) ([]model.Alert, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a := append([]model.Alert{}, r.alerts...)
+	sort.SliceStable(a, func(i, j int) bool { return a[i].Name < a[j].Name })
+
+	return a, nil
+}
+
+func (r *MemoryAlerts) Update(ctx context.Context, a *model.Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Replace all settings, keeping the creator and the current state
+	for i := range r.alerts {
+		if r.alerts[i].ID == a.ID {
+			u := *a
+			u.CreatedBy, u.State, u.Since, u.Pending = r.alerts[i].CreatedBy, r.alerts[i].State, r.alerts[i].Since, r.alerts[i].Pending
+			r.alerts[i] = u
+			return nil
+		}
+	}
+
+	return internal.NewError(internal.ErrDBNoUpdate, nil, 1)
+}
+
+func (r *MemoryAlerts) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.alerts {
+		if r.alerts[i].ID == id {
+			r.alerts = append(r.alerts[:i], r.alerts[i+1:]...)
+
+			// Delete the history of the alert
+			e := r.events[:0]
+			for _, ae := range r.events {
+				if ae.AlertID != id {
+					e = append(e, ae)
+				}
+			}
+			r.events = e
+
+			return nil
+		}
+	}
+
+	return internal.NewError(internal.ErrDBNoData, nil, 1)
+}
+
+func (r *MemoryAlerts) GetHistory(ctx context.Context, id *primitive.ObjectID) ([]model.AlertEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The latest state changes first
+	e := []model.AlertEvent{}
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if id == nil || r.events[i].AlertID == *id {
+			e = append(e, r.events[i])
+		}
+	}
+
+	return e, nil
+}
+
+// Webhooks
+
+// Outbox gets a copy of the messages added to the outbox, in insertion order
+func (r *MemoryWebhooks) Outbox() []model.WebhookMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]model.WebhookMessage{}, r.outbox...)
+}
+
+// AddDelivery stores a delivery attempt of a webhook
+func (r *MemoryWebhooks) AddDelivery(d *model.WebhookDelivery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d.ID = primitive.NewObjectID()
+	r.deliveries = append(r.deliveries, *d)
+}
+
+func (r *MemoryWebhooks) Create(ctx context.Context, w *model.Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w.ID = primitive.NewObjectID()
+	r.webhooks = append(r.webhooks, *w)
+
+	return nil
+}
+
+func (r *MemoryWebhooks) Get(ctx context.Context, id primitive.ObjectID) (*model.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, w := range r.webhooks {
+		if w.ID == id {
+			return &w, nil
+		}
+	}
+
+	return nil, internal.NewError(internal.ErrDBNoData, nil, 1)
+}
+
+func (r *MemoryWebhooks) GetAll(ctx context.Context) ([]model.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]model.Webhook{}, r.webhooks...), nil
+}
+
+func (r *MemoryWebhooks) Update(ctx context.Context, w *model.Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Replace all settings, keeping the creator
+	for i := range r.webhooks {
+		if r.webhooks[i].ID == w.ID {
+			u := *w
+			u.CreatedBy = r.webhooks[i].CreatedBy
+			r.webhooks[i] = u
+			return nil
+		}
+	}
+
+	return internal.NewError(internal.ErrDBNoUpdate, nil, 1)
+}
+
+func (r *MemoryWebhooks) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.webhooks {
+		if r.webhooks[i].ID == id {
+			r.webhooks = append(r.webhooks[:i], r.webhooks[i+1:]...)
+
+			// Delete the outbox messages and delivery log of the webhook
+			o := r.outbox[:0]
+			for _, msg := range r.outbox {
+				if msg.WebhookID != id {
+					o = append(o, msg)
+				}
+			}
+			r.outbox = o
+
+			d := r.deliveries[:0]
+			for _, wd := range r.deliveries {
+				if wd.WebhookID != id {
+					d = append(d, wd)
+				}
+			}
+			r.deliveries = d
+
+			return nil
+		}
+	}
+
+	return internal.NewError(internal.ErrDBNoData, nil, 1)
+}
+
+func (r *MemoryWebhooks) GetDeliveries(ctx context.Context, id primitive.ObjectID) ([]model.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The latest deliveries first
+	d := []model.WebhookDelivery{}
+	for i := len(r.deliveries) - 1; i >= 0; i-- {
+		if r.deliveries[i].WebhookID == id {
+			d = append(d, r.deliveries[i])
+		}
+	}
+
+	return d, nil
+}
+
+func (r *MemoryWebhooks) Publish(ctx context.Context, event string, data interface{}) error {
+	r.mu.Lock()
+	var subs []model.Webhook
+	for _, w := range r.webhooks {
+		if w.Active && internal.InSlice(event, w.Events) {
+			subs = append(subs, w)
+		}
+	}
+	r.mu.Unlock()
+
+	_, err := r.Enqueue(ctx, subs, event, data)
+	return err
+}
+
+func (r *MemoryWebhooks) Enqueue(ctx context.Context, w []model.Webhook, event string, data interface{}) ([]primitive.ObjectID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := time.Now()
+	ids := make([]primitive.ObjectID, 0, len(w))
+	for _, wh := range w {
+		msg := model.WebhookMessage{
+			ID:          primitive.NewObjectID(),
+			WebhookID:   wh.ID,
+			Event:       event,
+			Status:      model.WebhookMessagePending,
+			CreatedAt:   t,
+			NextAttempt: t,
+		}
+
+		b, err := json.Marshal(map[string]interface{}{"id": msg.ID, "event": event, "time": t, "data": data})
+		if err != nil {
+			return nil, internal.NewError(internal.ErrBEWebhookPayload, err, 1)
+		}
+		msg.Payload = string(b)
+
+		r.outbox = append(r.outbox, msg)
+		ids = append(ids, msg.ID)
+	}
+
+	return ids, nil
+}
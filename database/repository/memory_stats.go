@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"echo_rest_api/database/model"
+)
+
+type (
+	// MemoryStats is an in-memory StatsRepository, aggregating its gate events and space results like the DB pipelines;
+	// it is a second implementation for the handler tests, so it does not cover the pipelines themselves
+	MemoryStats struct {
+		mu      sync.Mutex
+		events  []model.Event
+		results []model.SpaceResult
+	}
+
+	// The key of a data point: its bucket and entity
+	memoryStatsKey struct {
+		bucket time.Time
+		entity primitive.ObjectID
+	}
+)
+
+// AddEvents stores gate events
+func (r *MemoryStats) AddEvents(e ...model.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, e...)
+}
+
+// AddSpaceResults stores space counts
+func (r *MemoryStats) AddSpaceResults(s ...model.SpaceResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.results = append(r.results, s...)
+}
+
+func (r *MemoryStats) Get(ctx context.Context, sq *model.Stats) error {
+	return model.StatsCollect(sq, func(fn func(r *model.StatsRow) error) error {
+		return r.Stream(ctx, sq, fn)
+	})
+}
+
+func (r *MemoryStats) Stream(ctx context.Context, sq *model.Stats, fn func(r *model.StatsRow) error) error {
+	r.mu.Lock()
+	var points []model.StatsDataPointRaw
+	if sq.Location == model.StatsLocationGate {
+		points = r.gatePoints(sq)
+	} else {
+		points = r.spacePoints(sq)
+	}
+	r.mu.Unlock()
+
+	// The rows are built in bucket order
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Bucket.Before(points[j].Bucket) })
+
+	return model.StatsStreamPoints(sq, points, fn)
+}
+
+// Aggregate the crossings of the requested gates into the requested buckets
+func (r *MemoryStats) gatePoints(sq *model.Stats) []model.StatsDataPointRaw {
+	// Parse the direction of data
+	var direction int8 = 1
+	if sq.IsInside {
+		direction = -1
+	}
+
+	points := make(map[memoryStatsKey]*model.StatsDataPointRaw)
+	minutes := make(map[memoryStatsKey][2]uint)
+	for _, e := range r.events {
+		if !hasID(sq.IDs, e.GateID) || e.Timestamp.Before(sq.Start) || e.Timestamp.After(sq.End) {
+			continue
+		}
+
+		k := memoryStatsKey{bucket: model.StatsBucket(sq, e.Timestamp), entity: r.entity(sq, e.GateID)}
+		p, ok := points[k]
+		if !ok {
+			p = &model.StatsDataPointRaw{Bucket: k.bucket, Entity: k.entity}
+			points[k] = p
+		}
+
+		// Count the crossings of every minute, so the peak rates can be picked for each bucket
+		mk := memoryStatsKey{bucket: e.Timestamp.Truncate(time.Minute), entity: k.entity}
+		m := minutes[mk]
+		switch e.Crossed {
+		case direction:
+			p.Entered++
+			m[0]++
+		case -direction:
+			p.Exited++
+			m[1]++
+		}
+		minutes[mk] = m
+
+		if m[0] > p.PeakEntered {
+			p.PeakEntered = m[0]
+		}
+		if m[1] > p.PeakExited {
+			p.PeakExited = m[1]
+		}
+	}
+
+	res := make([]model.StatsDataPointRaw, 0, len(points))
+	for _, p := range points {
+		res = append(res, *p)
+	}
+
+	return res
+}
+
+// Aggregate the counts of the requested spaces into the requested buckets, or return them as they are
+func (r *MemoryStats) spacePoints(sq *model.Stats) []model.StatsDataPointRaw {
+	var res []model.StatsDataPointRaw
+
+	// Sum the counts of all spaces at each time into site-level counts, if not split by space
+	samples := make(map[memoryStatsKey]float64)
+	for _, s := range r.results {
+		if !hasID(sq.IDs, s.SpaceID) || s.Timestamp.Before(sq.Start) || s.Timestamp.After(sq.End) {
+			continue
+		}
+
+		// Clamp the negative sensor counts to 0
+		samples[memoryStatsKey{bucket: s.Timestamp, entity: r.entity(sq, s.SpaceID)}] += math.Max(s.Count, 0)
+	}
+
+	counts := make(map[memoryStatsKey][]float64)
+	for k, c := range samples {
+		// Return the raw samples as they are
+		if sq.Interval.Type == model.StatsIntervalNone {
+			res = append(res, model.StatsDataPointRaw{Bucket: k.bucket, Entity: k.entity, MaxCount: c})
+			continue
+		}
+
+		bk := memoryStatsKey{bucket: model.StatsBucket(sq, k.bucket), entity: k.entity}
+		counts[bk] = append(counts[bk], c)
+	}
+
+	// Build the metrics for each bucket, of each space if split by space
+	for k, c := range counts {
+		sort.Float64s(c)
+
+		sum, above := 0.0, 0.0
+		for _, v := range c {
+			sum += v
+			if v > sq.Capacity {
+				above++
+			}
+		}
+		rank := func(p float64) float64 {
+			return c[int(math.Floor(p*float64(len(c)-1)))]
+		}
+
+		res = append(res, model.StatsDataPointRaw{
+			Bucket:     k.bucket,
+			Entity:     k.entity,
+			MaxCount:   c[len(c)-1],
+			MinCount:   c[0],
+			AvgCount:   sum / float64(len(c)),
+			P50Count:   rank(0.5),
+			P95Count:   rank(0.95),
+			AboveRatio: above / float64(len(c)),
+		})
+	}
+
+	return res
+}
+
+// Get the entity a data point is counted for; the zero ID if the data of all entities is merged
+func (r *MemoryStats) entity(sq *model.Stats, id primitive.ObjectID) primitive.ObjectID {
+	if sq.PerEntity {
+		return id
+	}
+
+	return primitive.NilObjectID
+}
+
+// Check if an ID is in a list
+func hasID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
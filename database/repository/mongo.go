@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"echo_rest_api/database/model"
+)
+
+type (
+	mongoUsers    struct{ m *mongo.Database }
+	mongoSites    struct{ m *mongo.Database }
+	mongoStats    struct{ m *mongo.Database }
+	mongoReports  struct{ m *mongo.Database }
+	mongoAlerts   struct{ m *mongo.Database }
+	mongoWebhooks struct{ m *mongo.Database }
+)
+
+// NewMongo creates the repositories backed by a MongoDB database
+func NewMongo(m *mongo.Database) *Repositories {
+	return &Repositories{
+		Users:    &mongoUsers{m},
+		Sites:    &mongoSites{m},
+		Stats:    &mongoStats{m},
+		Reports:  &mongoReports{m},
+		Alerts:   &mongoAlerts{m},
+		Webhooks: &mongoWebhooks{m},
+	}
+}
+
+// Users
+
+func (r *mongoUsers) Find(ctx context.Context, u *model.User) error {
+	return model.UserFind(ctx, r.m, u)
+}
+
+func (r *mongoUsers) GetAll(ctx context.Context, id primitive.ObjectID) ([]model.UserMinimalData, error) {
+	return model.UserGetAll(ctx, r.m, id)
+}
+
+func (r *mongoUsers) EmailExists(ctx context.Context, i *model.Invite) error {
+	return model.UserEmailExists(ctx, r.m, i)
+}
+
+func (r *mongoUsers) Create(ctx context.Context, u *model.User) error {
+	return model.UserCreate(ctx, r.m, u)
+}
+
+func (r *mongoUsers) ValidateInvite(ctx context.Context, i *model.ValidateInvite) error {
+	return model.UserValidateInvite(ctx, r.m, i)
+}
+
+func (r *mongoUsers) SignUp(ctx context.Context, s *model.SignUp) (*model.UserMinimalData, error) {
+	return model.UserSignUp(ctx, r.m, s)
+}
+
+func (r *mongoUsers) Update(ctx context.Context, u *model.UserUpdateData) error {
+	return model.UserUpdate(ctx, r.m, u)
+}
+
+func (r *mongoUsers) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return model.UserDelete(ctx, r.m, id)
+}
+
+// Sites
+
+func (r *mongoSites) Get(ctx context.Context, id primitive.ObjectID) (*model.Site, error) {
+	return model.SiteGet(ctx, r.m, id)
+}
+
+// Stats
+
+func (r *mongoStats) Get(ctx context.Context, sq *model.Stats) error {
+	return model.StatsGet(ctx, r.m, sq)
+}
+
+func (r *mongoStats) Stream(ctx context.Context, sq *model.Stats, fn func(r *model.StatsRow) error) error {
+	return model.StatsStream(ctx, r.m, sq, fn)
+}
+
+// Reports
+
+func (r *mongoReports) Create(ctx context.Context, rp *model.Report) error {
+	return model.ReportCreate(ctx, r.m, rp)
+}
+
+func (r *mongoReports) Get(ctx context.Context, id primitive.ObjectID) (*model.Report, error) {
+	return model.ReportGet(ctx, r.m, id)
+}
+
+func (r *mongoReports) GetAll(ctx context.Context) ([]model.Report, error) {
+	return model.ReportGetAll(ctx, r.m)
+}
+
+func (r *mongoReports) Update(ctx context.Context, rp *model.Report) error {
+	return model.ReportUpdate(ctx, r.m, rp)
+}
+
+func (r *mongoReports) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return model.ReportDelete(ctx, r.m, id)
+}
+
+func (r *mongoReports) GetDeliveries(ctx context.Context, id primitive.ObjectID) ([]model.ReportDelivery, error) {
+	return model.ReportDeliveryGetAll(ctx, r.m, id)
+}
+
+// Alerts
+
+func (r *mongoAlerts) Create(ctx context.Context, a *model.Alert) error {
+	return model.AlertCreate(ctx, r.m, a)
+}
+
+func (r *mongoAlerts) Get(ctx context.Context, id primitive.ObjectID) (*model.Alert, error) {
+	return model.AlertGet(ctx, r.m, id)
+}
+
+func (r *mongoAlerts) GetAll(ctx context.Context) ([]model.Alert, error) {
+	return model.AlertGetAll(ctx, r.m)
+}
+
+func (r *mongoAlerts) Update(ctx context.Context, a *model.Alert) error {
+	return model.AlertUpdate(ctx, r.m, a)
+}
+
+func (r *mongoAlerts) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return model.AlertDelete(ctx, r.m, id)
+}
+
+func (r *mongoAlerts) GetHistory(ctx context.Context, id *primitive.ObjectID) ([]model.AlertEvent, error) {
+	return model.AlertEventGetAll(ctx, r.m, id)
+}
+
+// Webhooks
+
+func (r *mongoWebhooks) Create(ctx context.Context, w *model.Webhook) error {
+	return model.WebhookCreate(ctx, r.m, w)
+}
+
+func (r *mongoWebhooks) Get(ctx context.Context, id primitive.ObjectID) (*model.Webhook, error) {
+	return model.WebhookGet(ctx, r.m, id)
+}
+
+func (r *mongoWebhooks) GetAll(ctx context.Context) ([]model.Webhook, error) {
+	return model.WebhookGetAll(ctx, r.m)
+}
+
+func (r *mongoWebhooks) Update(ctx context.Context, w *model.Webhook) error {
+	return model.WebhookUpdate(ctx, r.m, w)
+}
+
+func (r *mongoWebhooks) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return model.WebhookDelete(ctx, r.m, id)
+}
+
+func (r *mongoWebhooks) GetDeliveries(ctx context.Context, id primitive.ObjectID) ([]model.WebhookDelivery, error) {
+	return model.WebhookDeliveryGetAll(ctx, r.m, id)
+}
+
+func (r *mongoWebhooks) Publish(ctx context.Context, event string, data interface{}) error {
+	return model.WebhookPublish(ctx, r.m, event, data)
+}
+
+func (r *mongoWebhooks) Enqueue(ctx context.Context, w []model.Webhook, event string, data interface{}) ([]primitive.ObjectID, error) {
+	return model.WebhookEnqueue(ctx, r.m, w, event, data)
+}
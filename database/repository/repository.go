@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"echo_rest_api/database/model"
+)
+
+type (
+	// UserRepository stores the user accounts and their invites
+	UserRepository interface {
+		Find(ctx context.Context, u *model.User) error
+		GetAll(ctx context.Context, id primitive.ObjectID) ([]model.UserMinimalData, error)
+		EmailExists(ctx context.Context, i *model.Invite) error
+		Create(ctx context.Context, u *model.User) error
+		ValidateInvite(ctx context.Context, i *model.ValidateInvite) error
+		SignUp(ctx context.Context, s *model.SignUp) (*model.UserMinimalData, error)
+		Update(ctx context.Context, u *model.UserUpdateData) error
+		Delete(ctx context.Context, id primitive.ObjectID) error
+	}
+
+	// SiteRepository stores the sites grouping the gates and spaces
+	SiteRepository interface {
+		Get(ctx context.Context, id primitive.ObjectID) (*model.Site, error)
+	}
+
+	// StatsRepository aggregates the gate crossings and space counts into stats
+	StatsRepository interface {
+		Get(ctx context.Context, sq *model.Stats) error
+		Stream(ctx context.Context, sq *model.Stats, fn func(r *model.StatsRow) error) error
+	}
+
+	// ReportRepository stores the scheduled reports and their deliveries
+	ReportRepository interface {
+		Create(ctx context.Context, r *model.Report) error
+		Get(ctx context.Context, id primitive.ObjectID) (*model.Report, error)
+		GetAll(ctx context.Context) ([]model.Report, error)
+		Update(ctx context.Context, r *model.Report) error
+		Delete(ctx context.Context, id primitive.ObjectID) error
+		GetDeliveries(ctx context.Context, id primitive.ObjectID) ([]model.ReportDelivery, error)
+	}
+
+	// AlertRepository stores the alert rules and their state changes
+	AlertRepository interface {
+		Create(ctx context.Context, a *model.Alert) error
+		Get(ctx context.Context, id primitive.ObjectID) (*model.Alert, error)
+		GetAll(ctx context.Context) ([]model.Alert, error)
+		Update(ctx context.Context, a *model.Alert) error
+		Delete(ctx context.Context, id primitive.ObjectID) error
+		GetHistory(ctx context.Context, id *primitive.ObjectID) ([]model.AlertEvent, error)
+	}
+
+	// WebhookRepository stores the webhook subscriptions, their outbox and their deliveries
+	WebhookRepository interface {
+		Create(ctx context.Context, w *model.Webhook) error
+		Get(ctx context.Context, id primitive.ObjectID) (*model.Webhook, error)
+		GetAll(ctx context.Context) ([]model.Webhook, error)
+		Update(ctx context.Context, w *model.Webhook) error
+		Delete(ctx context.Context, id primitive.ObjectID) error
+		GetDeliveries(ctx context.Context, id primitive.ObjectID) ([]model.WebhookDelivery, error)
+		Publish(ctx context.Context, event string, data interface{}) error
+		Enqueue(ctx context.Context, w []model.Webhook, event string, data interface{}) ([]primitive.ObjectID, error)
+	}
+
+	// Repositories holds a repository of each kind, all backed by the same storage
+	Repositories struct {
+		Users    UserRepository
+		Sites    SiteRepository
+		Stats    StatsRepository
+		Reports  ReportRepository
+		Alerts   AlertRepository
+		Webhooks WebhookRepository
+	}
+)
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"echo_rest_api/database/model"
+)
+
+const (
+	timeSeriesCopyBatch = 10000 // The legacy documents copied at once
+)
+
+// SetRawExpiry keeps the data expiry of the raw time-series collections up to date, while holding the migrations lock
+func SetRawExpiry(ctx context.Context, m *mongo.Database, expiry time.Duration, logger echo.Logger) error {
+	return withMigrationLock(ctx, m, logger, func(extend func() error) error {
+		for _, name := range model.TimeSeriesCollections {
+			ts, err := model.TimeSeriesGet(ctx, m, name, false)
+			if err != nil {
+				return err
+			}
+			if ts == nil || !ts.TimeSeries || ts.Expiry == expiry {
+				continue
+			}
+
+			logger.Infof("Changing the data expiry of %s from %s to %s", name, ts.Expiry, expiry)
+			if err = model.TimeSeriesSetExpiry(ctx, m, name, expiry); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Migration storing the gate events and space results as time-series collections, when supported by the DB: missing
+// collections are created and plain ones are converted by copying their documents, without expiry until it is set by
+// SetRawExpiry; their indexes are created in any case
+func timeSeriesMigration(logger echo.Logger) func(ctx context.Context, m *mongo.Database) error {
+	return func(ctx context.Context, m *mongo.Database) error {
+		supported, err := model.TimeSeriesSupported(ctx, m)
+		if err != nil {
+			return err
+		}
+		if !supported {
+			logger.Warn("Time-series collections require MongoDB 5.0+, the raw data is kept in plain collections")
+		}
+
+		for _, name := range model.TimeSeriesCollections {
+			if err = migrateTimeSeries(ctx, m, name, supported, logger); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// Store a raw data collection as a time series if supported, then index it
+func migrateTimeSeries(ctx context.Context, m *mongo.Database, name string, supported bool, logger echo.Logger) error {
+	if supported {
+		if err := convertTimeSeries(ctx, m, name, logger); err != nil {
+			return err
+		}
+	}
+
+	// Index the data for the stats, alerts and tailers
+	if err := model.TimeSeriesEnsureIndex(ctx, m, name); err != nil {
+		return err
+	}
+
+	ts, err := model.TimeSeriesGet(ctx, m, name, false)
+	if err != nil {
+		return err
+	}
+	if ts != nil && ts.TimeSeries {
+		if err = model.TimeSeriesEnsureIDIndex(ctx, m, name); err != nil {
+			logger.Warnf("Failed to index %s by insertion order, the rollups and webhooks will scan it: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Convert a raw data collection into a time series
+func convertTimeSeries(ctx context.Context, m *mongo.Database, name string, logger echo.Logger) error {
+	ts, err := model.TimeSeriesGet(ctx, m, name, false)
+	if err != nil {
+		return err
+	}
+	legacy, err := model.TimeSeriesGet(ctx, m, name, true)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case ts != nil && ts.TimeSeries:
+		// Already converted, possibly with documents left to copy
+	case ts != nil && legacy != nil:
+		// Documents were added to a new plain collection while converting the previous one
+		return fmt.Errorf("both %s and %s_legacy exist as plain collections, one must be merged into the other by hand", name, name)
+	default:
+		// Set the plain collection aside, then create the time series in its place
+		if ts != nil {
+			logger.Infof("Converting %s into a time-series collection", name)
+			if err = model.TimeSeriesSetAside(ctx, m, name); err != nil {
+				return err
+			}
+			legacy = ts
+		}
+
+		if err = model.TimeSeriesCreate(ctx, m, name, 0); err != nil {
+			return err
+		}
+	}
+
+	if legacy == nil {
+		return nil
+	}
+
+	// Copy the documents of the plain collection, resuming after the last copied batch if interrupted
+	cursor := "timeseries." + name
+	last, err := model.CursorGet(ctx, m, cursor)
+	if err != nil {
+		return err
+	}
+
+	for resume, n := ts != nil && ts.TimeSeries, 0; ; resume, n = false, n+1 {
+		id, err := model.TimeSeriesCopy(ctx, m, name, last, timeSeriesCopyBatch, resume)
+		if err != nil {
+			return err
+		}
+		if id.IsZero() {
+			break
+		}
+
+		if _, err = model.CursorAdvance(ctx, m, cursor, last, id); err != nil {
+			return err
+		}
+		last = id
+
+		if n%100 == 99 {
+			logger.Infof("Copied %d documents into %s", (n+1)*timeSeriesCopyBatch, name)
+		}
+	}
+
+	// Drop the plain collection once fully copied
+	logger.Infof("Converted %s into a time-series collection", name)
+	return model.TimeSeriesDropLegacy(ctx, m, name)
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"echo_rest_api/internal"
+)
+
+type (
+	// Traces the MongoDB commands, keeping the span of each command until it completes
+	commandTracer struct {
+		spans   sync.Map
+		metrics *internal.Metrics
+	}
+	commandKey struct {
+		connectionID string
+		requestID    int64
+	}
+)
+
+// Create a command monitor tracing every MongoDB command as a child of the span of the operation sending it, and
+// measuring its duration
+func newCommandMonitor(metrics *internal.Metrics) *event.CommandMonitor {
+	t := &commandTracer{metrics: metrics}
+
+	return &event.CommandMonitor{
+		Started:   t.started,
+		Succeeded: t.succeeded,
+		Failed:    t.failed,
+	}
+}
+
+// Start the span of a command
+func (t *commandTracer) started(ctx context.Context, e *event.CommandStartedEvent) {
+	// The collection is the value of the command name field, for the commands working on one
+	collection, _ := e.Command.Lookup(e.CommandName).StringValueOK()
+
+	_, span := internal.StartSpan(ctx, "mongodb."+e.CommandName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemMongoDB,
+			semconv.DBNameKey.String(e.DatabaseName),
+			semconv.DBOperationKey.String(e.CommandName),
+			semconv.DBMongoDBCollectionKey.String(collection),
+			semconv.NetPeerNameKey.String(e.ConnectionID),
+		),
+	)
+
+	t.spans.Store(commandKey{e.ConnectionID, e.RequestID}, span)
+}
+
+// End the span of a successful command
+func (t *commandTracer) succeeded(_ context.Context, e *event.CommandSucceededEvent) {
+	t.end(e.CommandFinishedEvent, nil)
+}
+
+// End the span of a failed command
+func (t *commandTracer) failed(_ context.Context, e *event.CommandFailedEvent) {
+	t.end(e.CommandFinishedEvent, errors.New(e.Failure))
+}
+
+// End the span of a command, if it was started, and measure it
+func (t *commandTracer) end(e event.CommandFinishedEvent, err error) {
+	t.metrics.DBCommand(e.CommandName, time.Duration(e.DurationNanos), err != nil)
+
+	if span, ok := t.spans.LoadAndDelete(commandKey{e.ConnectionID, e.RequestID}); ok {
+		internal.EndSpan(span.(trace.Span), err)
+	}
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type (
+	// Origins matches the request origins against the allowed origin patterns, and the ones of the requested tenant
+	Origins struct {
+		patterns []originPattern
+		tenants  map[string][]originPattern
+	}
+
+	// An allowed origin, e.g. https://app.example.com or https://*.example.com for any of its subdomains
+	originPattern struct {
+		scheme   string
+		host     string // The parent domain, with its leading dot, when matching the subdomains
+		port     string
+		wildcard bool
+	}
+)
+
+const (
+	wildcardPrefix    = "*."
+	tenantOriginSep   = "="
+	originSchemeHTTP  = "http"
+	originSchemeHTTPS = "https"
+)
+
+// NewOrigins parses the allowed origin patterns, and the ones of the tenants given as tenant=pattern
+func NewOrigins(patterns, tenantPatterns []string) (*Origins, error) {
+	o := &Origins{patterns: make([]originPattern, 0, len(patterns)), tenants: map[string][]originPattern{}}
+	for _, p := range patterns {
+		op, err := parseOriginPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		o.patterns = append(o.patterns, op)
+	}
+
+	for _, entry := range tenantPatterns {
+		tenant, p, err := ParseTenantOrigin(entry)
+		if err != nil {
+			return nil, err
+		}
+		op, _ := parseOriginPattern(p)
+		o.tenants[tenant] = append(o.tenants[tenant], op)
+	}
+
+	return o, nil
+}
+
+// Allowed tells whether an origin matches one of the patterns, or one of the given tenant if any
+func (o *Origins) Allowed(origin, tenant string) bool {
+	if o == nil {
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	scheme, host := strings.ToLower(u.Scheme), strings.ToLower(u.Hostname())
+	port := originPort(scheme, u.Port())
+
+	match := func(patterns []originPattern) bool {
+		for _, p := range patterns {
+			if p.scheme != scheme || p.port != port {
+				continue
+			}
+			if p.wildcard {
+				if strings.HasSuffix(host, p.host) {
+					return true
+				}
+			} else if host == p.host {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return match(o.patterns) || (tenant != "" && match(o.tenants[strings.ToLower(tenant)]))
+}
+
+// ParseTenantOrigin splits a tenant origin, given as tenant=pattern, the tenant being lowercased
+func ParseTenantOrigin(entry string) (tenant, pattern string, err error) {
+	parts := strings.SplitN(entry, tenantOriginSep, 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return "", "", fmt.Errorf("invalid tenant origin %q, want tenant=origin", entry)
+	}
+	tenant, pattern = strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+	if _, err = parseOriginPattern(pattern); err != nil {
+		return "", "", err
+	}
+
+	return tenant, pattern, nil
+}
+
+// Parse an origin pattern, which must have a scheme and a host, and nothing else
+func parseOriginPattern(pattern string) (originPattern, error) {
+	// Take the wildcard out, as it is not a valid host
+	rest, wildcard := pattern, false
+	if i := strings.Index(pattern, "://"); i >= 0 && strings.HasPrefix(pattern[i+3:], wildcardPrefix) {
+		rest, wildcard = pattern[:i+3]+pattern[i+3+len(wildcardPrefix):], true
+	}
+
+	u, err := url.Parse(rest)
+	if err != nil || u.Host == "" || (u.Scheme != originSchemeHTTP && u.Scheme != originSchemeHTTPS) ||
+		strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return originPattern{}, fmt.Errorf("invalid origin %q, want e.g. https://app.example.com or https://*.example.com", pattern)
+	}
+	if strings.Contains(u.Host, "*") {
+		return originPattern{}, fmt.Errorf("invalid origin %q, the wildcard must be the first label of the host", pattern)
+	}
+
+	p := originPattern{scheme: u.Scheme, host: strings.ToLower(u.Hostname()), port: originPort(u.Scheme, u.Port()), wildcard: wildcard}
+	if wildcard {
+		p.host = "." + p.host
+	}
+
+	return p, nil
+}
+
+// Get the port of an origin, empty for the default port of its scheme as browsers leave it out
+func originPort(scheme, port string) string {
+	if (scheme == originSchemeHTTP && port == "80") || (scheme == originSchemeHTTPS && port == "443") {
+		return ""
+	}
+
+	return port
+}
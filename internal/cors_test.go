@@ -0,0 +1,75 @@
+package internal
+
+import "testing"
+
+func TestOriginsAllowed(t *testing.T) {
+	o, err := NewOrigins([]string{"https://app.example.com", "https://*.white-label.com", "http://localhost:3000"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.example.com:443", true},
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://other.example.com", false},
+		{"https://acme.white-label.com", true},
+		{"https://eu.acme.white-label.com", true},
+		{"https://white-label.com", false},
+		{"https://evilwhite-label.com", false},
+		{"http://localhost:3000", true},
+		{"http://localhost", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		if got := o.Allowed(tt.origin, ""); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestOriginPatterns(t *testing.T) {
+	for _, p := range []string{"*", "https://*", "app.example.com", "ftp://app.example.com", "https://app.*.com", "https://app.example.com/path"} {
+		if _, err := NewOrigins([]string{p}, nil); err == nil {
+			t.Errorf("%s: got no error, want an invalid origin", p)
+		}
+	}
+}
+
+func TestTenantOrigins(t *testing.T) {
+	o, err := NewOrigins([]string{"https://app.example.com"}, []string{"ACME=https://portal.acme.com", "acme = https://*.acme.io", "globex=https://globex.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		origin string
+		tenant string
+		want   bool
+	}{
+		{"https://app.example.com", "", true},
+		{"https://app.example.com", "acme", true},
+		{"https://portal.acme.com", "", false},
+		{"https://portal.acme.com", "acme", true},
+		{"https://portal.acme.com", "Acme", true},
+		{"https://eu.acme.io", "acme", true},
+		{"https://portal.acme.com", "globex", false},
+		{"https://globex.com", "acme", false},
+		{"https://globex.com", "globex", true},
+	}
+	for _, tt := range tests {
+		if got := o.Allowed(tt.origin, tt.tenant); got != tt.want {
+			t.Errorf("%s for %q: got %t, want %t", tt.origin, tt.tenant, got, tt.want)
+		}
+	}
+
+	for _, entry := range []string{"https://acme.com", "acme=", "=https://acme.com", "acme=acme.com"} {
+		if _, _, err := ParseTenantOrigin(entry); err == nil {
+			t.Errorf("%s: got no error, want an invalid tenant origin", entry)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Cron holds a parsed cron schedule, with a bit set for the allowed values of each field
+	Cron struct {
+		minute uint64
+		hour   uint64
+		dom    uint64
+		month  uint64
+		dow    uint64
+		anyDom bool
+		anyDow bool
+	}
+
+	cronField struct {
+		min int
+		max int
+	}
+)
+
+var (
+	cronFields = []cronField{
+		{0, 59}, // Minute
+		{0, 23}, // Hour
+		{1, 31}, // Day of month
+		{1, 12}, // Month
+		{0, 7},  // Day of week (both 0 and 7 are Sunday)
+	}
+
+	cronPresets = map[string]string{
+		"@hourly":  "0 * * * *",
+		"@daily":   "0 0 * * *",
+		"@weekly":  "0 0 * * 1",
+		"@monthly": "0 0 1 * *",
+		"@yearly":  "0 0 1 1 *",
+	}
+)
+
+const (
+	cronMaxYears = 5 // The furthest a schedule is searched for its next time
+)
+
+// ParseCron parses a standard 5-field cron expression (minute hour day-of-month month day-of-week),
+// supporting lists, ranges, steps and the @hourly, @daily, @weekly, @monthly and @yearly presets
+func ParseCron(spec string) (*Cron, error) {
+	if p, ok := cronPresets[strings.TrimSpace(spec)]; ok {
+		spec = p
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected %d fields", spec, len(cronFields))
+	}
+
+	var sets [5]uint64
+	for i, f := range fields {
+		s, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %s", spec, err)
+		}
+
+		sets[i] = s
+	}
+
+	// Sunday can be given as 7 as well
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &Cron{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		anyDom: fields[2] == "*",
+		anyDow: fields[4] == "*",
+	}, nil
+}
+
+// Parse a comma-separated list of values, ranges and steps into a bit set
+func parseCronField(f string, cf cronField) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(f, ",") {
+		// Split the optional step
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+
+			step, part = s, part[:i]
+		}
+
+		// Parse the range, a wildcard being the full one
+		lo, hi := cf.min, cf.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[0])
+			}
+
+			// A single value with a step runs up to the maximum
+			if hi = lo; len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				hi = cf.max
+			}
+		}
+
+		if lo < cf.min || hi > cf.max || lo > hi {
+			return 0, fmt.Errorf("value out of range in %q", part)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+
+	return set, nil
+}
+
+// Next gets the first scheduled time after the given one, in the time's location
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+
+	// Start from the following minute
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronMaxYears, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			// Step in absolute time, as the local hour may be skipped or repeated during a DST change
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// Check if a day matches the schedule; when both day fields are restricted, matching either of them is enough
+func (c *Cron) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -1,39 +1,466 @@
 package internal
 
 import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/kelseyhightower/envconfig"
+	"github.com/BurntSushi/toml"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v2"
 )
 
 type (
 	Environ struct {
-		ServerPort int           `required:"true" envconfig:"PORT"`
-		SkipChecks bool          `required:"true" envconfig:"SKIP_CHECKS"`
-		Secure     bool          `required:"true" envconfig:"SECURE"`
-		JwtSecret  string        `required:"true" envconfig:"SECRET_KEY"`
-		JwtExp     time.Duration `required:"true" envconfig:"JWT_EXP"`
-		DBUri      string        `required:"true" envconfig:"DB_URI"`
-		DBName     string        `required:"true" envconfig:"DB_NAME"`
-		DBRootUser string        `required:"true" envconfig:"DB_ROOT_USER"`
-		DBRootPass string        `required:"true" envconfig:"DB_ROOT_PASS"`
-		SMTPHost   string        `required:"false" envconfig:"SMTP_HOST"`
-		SMTPPort   int           `required:"false" envconfig:"SMTP_PORT"`
-		SMTPUser   string        `required:"false" envconfig:"SMTP_USER"`
-		SMTPPass   string        `required:"false" envconfig:"SMTP_PASS"`
-		FEndpoint  string        `required:"true" envconfig:"FE_ENDPOINT"`
+		ServerPort         int           `required:"true" envconfig:"PORT"`
+		SkipChecks         bool          `required:"true" envconfig:"SKIP_CHECKS"`
+		Secure             bool          `required:"true" envconfig:"SECURE"`
+		JwtSecret          string        `required:"true" envconfig:"SECRET_KEY"`
+		JwtExp             time.Duration `required:"true" envconfig:"JWT_EXP"`
+		DBUri              string        `required:"true" envconfig:"DB_URI"`
+		DBName             string        `required:"true" envconfig:"DB_NAME"`
+		DBRootUser         string        `required:"true" envconfig:"DB_ROOT_USER"`
+		DBRootPass         string        `required:"true" envconfig:"DB_ROOT_PASS"`
+		SMTPHost           string        `required:"false" envconfig:"SMTP_HOST"`
+		SMTPPort           int           `required:"false" envconfig:"SMTP_PORT"`
+		SMTPUser           string        `required:"false" envconfig:"SMTP_USER"`
+		SMTPPass           string        `required:"false" envconfig:"SMTP_PASS"`
+		FEndpoint          string        `required:"true" envconfig:"FE_ENDPOINT"`
+		RawExpiry          time.Duration `required:"false" envconfig:"RAW_EXPIRY"`
+		Migrate            bool          `required:"false" envconfig:"MIGRATE"`
+		DBTimeout          time.Duration `required:"false" default:"10s" envconfig:"DB_TIMEOUT"`
+		DBAggregateTimeout time.Duration `required:"false" default:"1m" envconfig:"DB_AGGREGATE_TIMEOUT"`
+		LogLevel           string        `required:"false" default:"info" envconfig:"LOG_LEVEL"`
+		LogFormat          string        `required:"false" default:"json" envconfig:"LOG_FORMAT"`
+		TraceExporter      string        `required:"false" envconfig:"TRACE_EXPORTER"`
+		AdminAddr          string        `required:"false" default:"127.0.0.1:8081" envconfig:"ADMIN_ADDR"`
+		AdminUser          string        `required:"false" envconfig:"ADMIN_USER"`
+		AdminPass          string        `required:"false" envconfig:"ADMIN_PASS"`
+		AdminAllowedIPs    []string      `required:"false" envconfig:"ADMIN_ALLOWED_IPS"`
+		ProbeAddr          string        `required:"false" default:":8082" envconfig:"PROBE_ADDR"`
+		ShutdownDrain      time.Duration `required:"false" default:"5s" envconfig:"SHUTDOWN_DRAIN"`
+		ConfigPoll         time.Duration `required:"false" default:"10s" envconfig:"CONFIG_POLL"`
+		CORSOrigins        []string      `required:"false" envconfig:"CORS_ORIGINS"`
+		CORSTenantOrigins  []string      `required:"false" envconfig:"CORS_TENANT_ORIGINS"`
+		CORSAllowHeaders   []string      `required:"false" default:"Origin,Content-Type,Accept,Authorization,X-CSRF-Token,X-Request-ID" envconfig:"CORS_ALLOW_HEADERS"`
+		CORSExposeHeaders  []string      `required:"false" default:"X-CSRF-Token,X-Request-ID" envconfig:"CORS_EXPOSE_HEADERS"`
+		CORSMaxAge         time.Duration `required:"false" default:"10m" envconfig:"CORS_MAX_AGE"`
+		InviteURL          string        `required:"false" envconfig:"INVITE_URL"`
+
+		ConfigFile string `envconfig:"-"` // The config file the configuration was read from, if any
+	}
+
+	// ConfigError lists all the problems found in the configuration
+	ConfigError struct {
+		Problems []string
 	}
 )
 
-// GetEnv gets required environment variables
-func GetEnv() (*Environ, error) {
+const (
+	envPrefix        = "INST"
+	configFileKey    = "CONFIG_FILE"
+	secretFileSuffix = "_FILE"
+	minJwtSecretLen  = 32
+)
+
+// Error function for error interface
+func (e *ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// GetEnv gets the configuration, each setting being taken from the first source defining it among: the given CLI
+// flags, the INST_* environment variables, the files named by the INST_*_FILE variables, the config file named by the
+// --config flag or INST_CONFIG_FILE, and the defaults. All the problems found are reported at once.
+func GetEnv(args []string) (*Environ, error) {
 	e := new(Environ)
+	var problems []string
 
-	// Process and validate the required environment variables
-	err := envconfig.Process("INST", e)
-	if err != nil {
-		return nil, err
+	fields := environFields()
+
+	// Parse the CLI flags, one for each setting, e.g. --db-uri for INST_DB_URI
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(envPrefix+"_"+configFileKey), "Path of a YAML or TOML config file")
+	flags := make(map[string]*string, len(fields))
+	for _, f := range fields {
+		flags[f.key] = fs.String(f.flag(), "", fmt.Sprintf("Overrides %s_%s", envPrefix, f.key))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, &ConfigError{Problems: []string{err.Error()}}
+	}
+	set := map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	// Read the config file
+	file := map[string]string{}
+	if *configFile != "" {
+		var err error
+		if file, err = readConfigFile(*configFile); err != nil {
+			return nil, &ConfigError{Problems: []string{err.Error()}}
+		}
+	}
+
+	// Take each setting from its first source
+	v := reflect.ValueOf(e).Elem()
+	for _, f := range fields {
+		raw, source, err := f.lookup(set[f.flag()], *flags[f.key], file)
+		delete(file, strings.ToLower(f.key))
+		delete(file, strings.ToLower(f.key+secretFileSuffix))
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+
+		if raw == "" {
+			if f.required {
+				problems = append(problems, fmt.Sprintf("%s_%s is required", envPrefix, f.key))
+			}
+			continue
+		}
+
+		if err = setField(v.Field(f.index), raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s_%s from %s: %s", envPrefix, f.key, source, err))
+		}
+	}
+
+	// Reject the unknown settings of the config file, most likely typos
+	for k := range file {
+		problems = append(problems, fmt.Sprintf("unknown setting %q in %s", k, *configFile))
+	}
+
+	// Check the values themselves
+	problems = append(problems, e.validate()...)
+	e.ConfigFile = *configFile
+
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
 	}
 
 	return e, nil
 }
+
+type (
+	// A setting of the configuration
+	environField struct {
+		index    int
+		key      string
+		required bool
+		def      string
+	}
+)
+
+// Get the settings of the configuration from the tags of its fields
+func environFields() []environField {
+	t := reflect.TypeOf(Environ{})
+
+	fields := make([]environField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Tag.Get("envconfig") == "-" {
+			continue
+		}
+
+		fields = append(fields, environField{
+			index:    i,
+			key:      sf.Tag.Get("envconfig"),
+			required: sf.Tag.Get("required") == "true",
+			def:      sf.Tag.Get("default"),
+		})
+	}
+
+	return fields
+}
+
+// Get the CLI flag name of a setting
+func (f environField) flag() string {
+	return strings.ToLower(strings.ReplaceAll(f.key, "_", "-"))
+}
+
+// Get the raw value of a setting from its first source, along with the source name
+func (f environField) lookup(flagSet bool, flagValue string, file map[string]string) (string, string, error) {
+	name := envPrefix + "_" + f.key
+
+	if flagSet {
+		return flagValue, "--" + f.flag(), nil
+	}
+
+	// A value and a secret file for the same setting are ambiguous
+	value, hasValue := os.LookupEnv(name)
+	path, hasPath := os.LookupEnv(name + secretFileSuffix)
+	if hasValue && hasPath {
+		return "", "", fmt.Errorf("%s and %s%s are both set", name, name, secretFileSuffix)
+	}
+	if hasValue {
+		return value, name, nil
+	}
+	if hasPath {
+		s, err := readSecretFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("%s%s: %s", name, secretFileSuffix, err)
+		}
+		return s, name + secretFileSuffix, nil
+	}
+
+	// The config file keys are the lowercase variable names, without prefix
+	key := strings.ToLower(f.key)
+	if value, ok := file[key]; ok {
+		return value, "the config file", nil
+	}
+	if path, ok := file[key+strings.ToLower(secretFileSuffix)]; ok {
+		s, err := readSecretFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("%s%s in the config file: %s", key, strings.ToLower(secretFileSuffix), err)
+		}
+		return s, "the config file", nil
+	}
+
+	return f.def, "the default", nil
+}
+
+// Read a secret from a file, e.g. a Docker or Kubernetes secret
+func readSecretFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %s", err)
+	}
+
+	// Ignore the final line break most editors add
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// Read a flat YAML or TOML config file, based on its extension, into raw values by lowercase key
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %s", err)
+	}
+
+	var m map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &m)
+	case ".toml":
+		err = toml.Unmarshal(b, &m)
+	default:
+		return nil, fmt.Errorf("unsupported config file %s, expected a .yaml, .yml or .toml file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %s", path, err)
+	}
+
+	file := make(map[string]string, len(m))
+	for k, v := range m {
+		k = strings.ToLower(strings.ReplaceAll(k, "-", "_"))
+
+		switch v := v.(type) {
+		case []interface{}: // Lists are given as comma-separated values
+			items := make([]string, len(v))
+			for i := range v {
+				items[i] = fmt.Sprint(v[i])
+			}
+			file[k] = strings.Join(items, ",")
+		case map[interface{}]interface{}, map[string]interface{}:
+			return nil, fmt.Errorf("invalid setting %q in config file %s, nested settings are not supported", k, path)
+		default:
+			file[k] = fmt.Sprint(v)
+		}
+	}
+
+	return file, nil
+}
+
+// Set a field from its raw value
+func setField(f reflect.Value, raw string) error {
+	switch f.Interface().(type) {
+	case string:
+		f.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		f.SetBool(b)
+	case int:
+		i, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		f.SetInt(int64(i))
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		f.SetInt(int64(d))
+	case []string:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.Type())
+	}
+
+	return nil
+}
+
+// EnvironDiff lists the names of the settings that differ between two configurations
+func EnvironDiff(a, b *Environ) []string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+
+	var names []string
+	for _, f := range environFields() {
+		if !reflect.DeepEqual(va.Field(f.index).Interface(), vb.Field(f.index).Interface()) {
+			names = append(names, envPrefix+"_"+f.key)
+		}
+	}
+
+	return names
+}
+
+// FEURL returns the URL of the FE endpoint, with the scheme matching the security of the server
+func (e *Environ) FEURL() string {
+	if e.Secure {
+		return "https://" + e.FEndpoint
+	}
+
+	return "http://" + e.FEndpoint
+}
+
+// AllowedOrigins returns the origin patterns allowed by CORS, the FE endpoint if none are set
+func (e *Environ) AllowedOrigins() []string {
+	if len(e.CORSOrigins) == 0 {
+		return []string{e.FEURL()}
+	}
+
+	return e.CORSOrigins
+}
+
+// InviteBaseURL returns the base URL of the invite links, the FE endpoint if none is set
+func (e *Environ) InviteBaseURL() string {
+	if e.InviteURL != "" {
+		return strings.TrimRight(e.InviteURL, "/")
+	}
+
+	return e.FEURL()
+}
+
+// Check the values of the configuration, returning all the problems found
+func (e *Environ) validate() []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// Server
+	if e.ServerPort < 1 || e.ServerPort > 65535 {
+		add("%s_PORT must be between 1 and 65535, got %d", envPrefix, e.ServerPort)
+	}
+	if len(e.JwtSecret) > 0 && len(e.JwtSecret) < minJwtSecretLen {
+		add("%s_SECRET_KEY must be at least %d characters long, got %d", envPrefix, minJwtSecretLen, len(e.JwtSecret))
+	}
+	if e.JwtExp < 0 {
+		add("%s_JWT_EXP must be positive, got %s", envPrefix, e.JwtExp)
+	}
+	if e.FEndpoint != "" {
+		if strings.Contains(e.FEndpoint, "://") {
+			add("%s_FE_ENDPOINT must not have a scheme, it is set by %s_SECURE, got %q", envPrefix, envPrefix, e.FEndpoint)
+		} else if u, err := url.Parse("http://" + e.FEndpoint); err != nil || u.Host == "" {
+			add("%s_FE_ENDPOINT must be a host with an optional port, got %q", envPrefix, e.FEndpoint)
+		}
+	}
+
+	// CORS and links
+	for _, origin := range e.CORSOrigins {
+		if _, err := parseOriginPattern(origin); err != nil {
+			add("%s_CORS_ORIGINS: %s", envPrefix, err)
+		}
+	}
+	for _, entry := range e.CORSTenantOrigins {
+		if tenant, _, err := ParseTenantOrigin(entry); err != nil {
+			add("%s_CORS_TENANT_ORIGINS: %s", envPrefix, err)
+		} else if !primitive.IsValidObjectID(tenant) {
+			add("%s_CORS_TENANT_ORIGINS: the tenant of %q must be a site ID", envPrefix, entry)
+		}
+	}
+	if e.InviteURL != "" {
+		if u, err := url.Parse(e.InviteURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("%s_INVITE_URL must be an http:// or https:// URL, got %q", envPrefix, e.InviteURL)
+		}
+	}
+
+	// DB
+	if e.DBUri != "" {
+		u, err := url.Parse(e.DBUri)
+		if err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") || u.Host == "" {
+			add("%s_DB_URI must be a mongodb:// or mongodb+srv:// URI", envPrefix)
+		}
+	}
+	for name, d := range map[string]time.Duration{
+		"RAW_EXPIRY":           e.RawExpiry,
+		"DB_TIMEOUT":           e.DBTimeout,
+		"DB_AGGREGATE_TIMEOUT": e.DBAggregateTimeout,
+		"SHUTDOWN_DRAIN":       e.ShutdownDrain,
+		"CONFIG_POLL":          e.ConfigPoll,
+		"CORS_MAX_AGE":         e.CORSMaxAge,
+	} {
+		if d < 0 {
+			add("%s_%s must not be negative, got %s", envPrefix, name, d)
+		}
+	}
+
+	// SMTP
+	if e.SMTPHost != "" && e.SMTPPort == 0 {
+		add("%s_SMTP_PORT is required when %s_SMTP_HOST is set", envPrefix, envPrefix)
+	}
+	if e.SMTPHost == "" && e.SMTPPort != 0 {
+		add("%s_SMTP_HOST is required when %s_SMTP_PORT is set", envPrefix, envPrefix)
+	}
+	if e.SMTPPort < 0 || e.SMTPPort > 65535 {
+		add("%s_SMTP_PORT must be between 1 and 65535, got %d", envPrefix, e.SMTPPort)
+	}
+
+	// Observability
+	if lvl, err := zerolog.ParseLevel(e.LogLevel); err != nil || lvl == zerolog.NoLevel {
+		add("%s_LOG_LEVEL must be debug, info, warn or error, got %q", envPrefix, e.LogLevel)
+	}
+	if e.LogFormat != LogFormatJSON && e.LogFormat != LogFormatConsole {
+		add("%s_LOG_FORMAT must be %s or %s, got %q", envPrefix, LogFormatJSON, LogFormatConsole, e.LogFormat)
+	}
+	if e.TraceExporter != TraceExporterNone && e.TraceExporter != TraceExporterOTLP && e.TraceExporter != TraceExporterStdout {
+		add("%s_TRACE_EXPORTER must be empty, %s or %s, got %q", envPrefix, TraceExporterOTLP, TraceExporterStdout, e.TraceExporter)
+	}
+
+	// Admin and probe servers
+	if e.AdminAddr != "" {
+		if _, _, err := net.SplitHostPort(e.AdminAddr); err != nil {
+			add("%s_ADMIN_ADDR must be a [host]:port address, got %q", envPrefix, e.AdminAddr)
+		}
+	}
+	if e.ProbeAddr != "" {
+		if _, _, err := net.SplitHostPort(e.ProbeAddr); err != nil {
+			add("%s_PROBE_ADDR must be a [host]:port address, got %q", envPrefix, e.ProbeAddr)
+		}
+	}
+	if (e.AdminUser == "") != (e.AdminPass == "") {
+		add("%s_ADMIN_USER and %s_ADMIN_PASS must be set together", envPrefix, envPrefix)
+	}
+	for _, entry := range e.AdminAllowedIPs {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			add("%s_ADMIN_ALLOWED_IPS must list IPs or CIDR ranges, got %q", envPrefix, entry)
+		}
+	}
+
+	return problems
+}
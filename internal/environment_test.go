@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Set environment variables for the duration of a test
+func setEnv(t *testing.T, vars map[string]string) {
+	t.Helper()
+
+	for k, v := range vars {
+		k := k
+		old, had := os.LookupEnv(k)
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if had {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+// Write a file in the test directory
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestGetEnvLayers(t *testing.T) {
+	secret := writeFile(t, "secret", strings.Repeat("s", minJwtSecretLen)+"\n")
+	config := writeFile(t, "config.yaml", `
+port: 8080
+skip_checks: true
+secure: false
+jwt_exp: 72h
+db_uri: mongodb://file:27017
+db_name: file_db
+db_root_user: owner@local.com
+db_root_pass: file-pass
+fe_endpoint: localhost:3000
+admin_allowed_ips: [127.0.0.1, 10.0.0.0/8]
+`)
+	setEnv(t, map[string]string{
+		"INST_CONFIG_FILE":     config,
+		"INST_SECRET_KEY_FILE": secret,
+		"INST_DB_NAME":         "env_db",
+		"INST_PORT":            "9000",
+	})
+
+	e, err := GetEnv([]string{"--port", "7000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"flag over env", e.ServerPort, 7000},
+		{"env over file", e.DBName, "env_db"},
+		{"file", e.DBUri, "mongodb://file:27017"},
+		{"file list", strings.Join(e.AdminAllowedIPs, ","), "127.0.0.1,10.0.0.0/8"},
+		{"secret file", e.JwtSecret, strings.Repeat("s", minJwtSecretLen)},
+		{"default", e.DBTimeout, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestGetEnvProblems(t *testing.T) {
+	config := writeFile(t, "config.toml", `
+port = 8080
+skip_checks = true
+secure = false
+secret_key = "short"
+jwt_exp = "forever"
+db_uri = "http://localhost:27017"
+db_name = "db"
+db_root_user = "owner@local.com"
+fe_endpoint = "localhost:3000"
+smtp_host = "smtp.example.com"
+cors_origins = ["https://app.example.com", "app.example.com"]
+cors_tenant_origins = ["65f1c0d2a4b5c6d7e8f90123=https://portal.acme.com", "acme=https://portal.acme.com"]
+invite_url = "app.example.com/invite"
+probe_addr = "8082"
+db_nmae = "typo"
+`)
+
+	_, err := GetEnv([]string{"--config", config})
+
+	var ce *ConfigError
+	if !errors.As(err, &ce) {
+		t.Fatalf("got error %v, want a config error", err)
+	}
+
+	// All the problems are reported at once
+	for _, want := range []string{
+		"INST_DB_ROOT_PASS is required",
+		"INST_JWT_EXP from the config file: invalid duration",
+		"INST_SECRET_KEY must be at least",
+		"INST_DB_URI must be a mongodb://",
+		"INST_SMTP_PORT is required when INST_SMTP_HOST is set",
+		`INST_CORS_ORIGINS: invalid origin "app.example.com"`,
+		`INST_CORS_TENANT_ORIGINS: the tenant of "acme=https://portal.acme.com" must be a site ID`,
+		"INST_INVITE_URL must be an http:// or https:// URL",
+		"INST_PROBE_ADDR must be a [host]:port address",
+		`unknown setting "db_nmae"`,
+	} {
+		found := false
+		for _, p := range ce.Problems {
+			found = found || strings.Contains(p, want)
+		}
+		if !found {
+			t.Errorf("got problems %q, want one containing %q", ce.Problems, want)
+		}
+	}
+}
+
+func TestEnvironDiff(t *testing.T) {
+	a := &Environ{LogLevel: "info", JwtExp: time.Hour, AdminAllowedIPs: []string{"127.0.0.1"}, ConfigFile: "a.yaml"}
+	b := *a
+	b.LogLevel = "debug"
+	b.AdminAllowedIPs = []string{"10.0.0.1"}
+	b.ConfigFile = "b.yaml"
+
+	// Only the settings are compared, by their variable names
+	if got, want := strings.Join(EnvironDiff(a, &b), ","), "INST_LOG_LEVEL,INST_ADMIN_ALLOWED_IPS"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := EnvironDiff(a, a); len(got) != 0 {
+		t.Errorf("got %q, want no differences", got)
+	}
+}
@@ -1,12 +1,16 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"runtime"
 
 	"github.com/go-playground/validator"
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type (
@@ -23,7 +27,8 @@ type (
 )
 
 const (
-	ErrBEEmail           = "Error occurred while sending the invite email"
+	ErrBEEmail           = "Error occurred while sending an email"
+	ErrBEExport          = "Error occurred while writing the export file"
 	ErrBEHashSalt        = "Error occurred while generating salt for hashing the given password"
 	ErrBEInvalidInvite   = "The given invite token is no longer available"
 	ErrBEInvalidPassword = "The given email or password do not match any available data"
@@ -33,26 +38,38 @@ const (
 	ErrBENotAdmin        = "This logged in account does not have permissions in this section"
 	ErrBETimeConversion  = "Error occurred while converting a time field"
 	ErrBEUserExists      = "A user account is already associated to this email"
+	ErrBEWebhookPayload  = "Error occurred while serializing a webhook payload"
 
+	ErrBEQPInvalidCapacity     = "The current request has an invalid or empty capacity query parameter"
 	ErrBEQPInvalidChartType    = "The current request has an invalid or empty chartType query parameter"
+	ErrBEQPInvalidCompareTo    = "The current request has an invalid compareTo query parameter"
 	ErrBEQPInvalidDateTime     = "The current request has an invalid or empty date query parameter"
+	ErrBEQPInvalidFill         = "The current request has an invalid fill query parameter"
 	ErrBEQPInvalidIsInside     = "The current request has an invalid or empty isInside query parameter"
+	ErrBEQPInvalidFormat       = "The current request has an invalid format query parameter"
+	ErrBEQPInvalidIntervalSize = "The current request has an invalid intervalSize query parameter"
 	ErrBEQPInvalidIntervalType = "The current request has an invalid or empty intervalType query parameter"
 	ErrBEQPInvalidLocation     = "The current request has an invalid or empty location query parameter"
+	ErrBEQPInvalidMetrics      = "The current request has an invalid metrics query parameter"
 	ErrBEQPInvalidMobile       = "The current request has an invalid or empty mobile query parameter"
+	ErrBEQPInvalidPerEntity    = "The current request has an invalid perEntity query parameter"
 	ErrBEQPInvalidTimezone     = "The current request has an invalid or empty timezone query parameter"
 	ErrBEQPMissing             = "The current request is missing one or more query parameters"
 	ErrBEQPNoRawOnGate         = "The current request is trying to retrieve non-existing raw data on gates"
+	ErrBEQPTooManyPoints       = "The current request interval contains too many data points"
 
 	ErrDBCursorClose   = "Error occurred while closing the MongoDB cursor"
 	ErrDBCursorIterate = "Error occurred while iterating over the MongoDB cursor"
 	ErrDBDecode        = "Error occurred while decoding MongoDB documents"
 	ErrDBDelete        = "Error occurred while deleting MongoDB documents"
+	ErrDBIndex         = "Error occurred while creating MongoDB indexes"
 	ErrDBInsert        = "Error occurred while inserting MongoDB documents"
 	ErrDBQuery         = "Error occurred while querying MongoDB documents"
 	ErrDBUpdate        = "Error occurred while updating MongoDB documents"
 	ErrDBNoData        = "No data found to be grabbed in MongoDB query"
 	ErrDBNoUpdate      = "No data found to be updated in MongoDB query"
+	ErrDBTimeout       = "The MongoDB query did not complete in time"
+	ErrDBCanceled      = "The MongoDB query was canceled before completing"
 )
 
 // NewError creates a new backend Error
@@ -98,36 +115,81 @@ func ErrorHandler(err error, c echo.Context) {
 			}
 
 		case *Error: // Handle a backend error
-			// Log the error
-			c.Logger().Errorf(
-				"BackendError :: File:%s - Line:%d :: %s -> %v", e.Location.File, e.Location.Line, e.Message, e.Original,
-			)
-
-			// Construct the response
+			// Construct the response; the DB operations cut short by their deadline or by a cancellation are reported as such
 			message = e.Message
+			switch {
+			case isTimeout(e.Original):
+				message = ErrDBTimeout
+			case errors.Is(e.Original, context.Canceled):
+				message = ErrDBCanceled
+			}
 
 			switch message {
 			case ErrDBNoData, ErrDBNoUpdate:
 				code = http.StatusNotFound
+			case ErrDBTimeout:
+				code = http.StatusGatewayTimeout
+			case ErrDBCanceled:
+				code = http.StatusServiceUnavailable
 			case ErrBEInvalidPassword, ErrBENotAdmin:
 				code = http.StatusUnauthorized
-			case ErrBEEmail, ErrBEHashSalt, ErrBEMongoIDCast, ErrBETimeConversion,
-				ErrDBCursorClose, ErrDBCursorIterate, ErrDBDecode, ErrDBDelete, ErrDBInsert, ErrDBQuery, ErrDBUpdate:
+			case ErrBEEmail, ErrBEExport, ErrBEHashSalt, ErrBEMongoIDCast, ErrBETimeConversion, ErrBEWebhookPayload,
+				ErrDBCursorClose, ErrDBCursorIterate, ErrDBDecode, ErrDBDelete, ErrDBIndex, ErrDBInsert, ErrDBQuery, ErrDBUpdate:
 				code = http.StatusInternalServerError
 			case ErrBEInvalidInvite, ErrBEMongoIDEmpty, ErrBEUserExists,
-				ErrBEQPInvalidChartType, ErrBEQPInvalidDateTime, ErrBEQPInvalidIsInside, ErrBEQPInvalidIntervalType,
-				ErrBEQPInvalidLocation, ErrBEQPInvalidMobile, ErrBEQPInvalidTimezone, ErrBEQPMissing, ErrBEQPNoRawOnGate:
+				ErrBEQPInvalidCapacity, ErrBEQPInvalidChartType, ErrBEQPInvalidCompareTo, ErrBEQPInvalidDateTime, ErrBEQPInvalidFill,
+				ErrBEQPInvalidFormat, ErrBEQPInvalidIsInside, ErrBEQPInvalidIntervalSize, ErrBEQPInvalidIntervalType, ErrBEQPInvalidLocation,
+				ErrBEQPInvalidMetrics, ErrBEQPInvalidMobile, ErrBEQPInvalidPerEntity, ErrBEQPInvalidTimezone, ErrBEQPMissing,
+				ErrBEQPNoRawOnGate, ErrBEQPTooManyPoints:
 				code = http.StatusBadRequest
 			}
+
+			// Log the error
+			RequestLog(c).Error().
+				Str("file", e.Location.File).
+				Int("line", e.Location.Line).
+				AnErr("original", e.Original).
+				Int("status", code).
+				Msg(e.Message)
+
+		default: // Handle a deadline or cancellation outside of the DB
+			switch {
+			case isTimeout(err):
+				code, message = http.StatusGatewayTimeout, http.StatusText(http.StatusGatewayTimeout)
+			case errors.Is(err, context.Canceled):
+				code, message = http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable)
+			}
+
+			// Log the error
+			RequestLog(c).Error().Err(err).Int("status", code).Msg(message)
 		}
 
-		// Send the error response
-		if err := c.JSON(code, map[string]interface{}{
+		// Record the error on the request span
+		if span := trace.SpanFromContext(c.Request().Context()); span.IsRecording() {
+			span.RecordError(err)
+		}
+
+		// Send the error response, along with the trace to look for
+		res := map[string]interface{}{
 			"error":   true,
 			"message": message,
 			"data":    nil,
-		}); err != nil {
-			c.Logger().Error(err)
+		}
+		if id := TraceID(c.Request().Context()); id != "" {
+			res["traceId"] = id
+		}
+		if err := c.JSON(code, res); err != nil {
+			RequestLog(c).Error().Err(err).Msg("Failed to send the error response")
 		}
 	}
 }
+
+// Check if an error comes from a context deadline, a network timeout or the maxTimeMS of a MongoDB query
+func isTimeout(err error) bool {
+	var ce mongo.CommandError
+	if errors.As(err, &ce) && ce.IsMaxTimeMSExpiredError() {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err)
+}
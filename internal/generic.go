@@ -1,7 +1,10 @@
 package internal
 
 import (
+	"fmt"
 	"net/url"
+	"strconv"
+	"time"
 )
 
 // InSlice checks if a string is in a slice
@@ -33,3 +36,22 @@ func GetDomainFromURL(u string) (string, error) {
 
 	return p.Hostname(), nil
 }
+
+// LoadTimezone loads a location from an IANA timezone name (e.g. "Europe/Bucharest");
+// legacy whole-hour offsets (e.g. "3" or "-5") are still accepted as fixed zones
+func LoadTimezone(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("invalid timezone: %q", name)
+	}
+
+	// Handle legacy integer offsets; the zone is named after the offset so it can be passed to MongoDB as-is
+	if o, err := strconv.Atoi(name); err == nil {
+		if o < -12 || o > 14 {
+			return nil, fmt.Errorf("invalid timezone offset value: %d", o)
+		}
+
+		return time.FixedZone(fmt.Sprintf("%+03d:00", o), o*60*60), nil
+	}
+
+	return time.LoadLocation(name)
+}
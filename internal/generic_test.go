@@ -0,0 +1,31 @@
+package internal
+
+import "testing"
+
+func TestLoadTimezone(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+		want  string
+	}{
+		{"", false, ""},
+		{"Local", false, ""},
+		{"Mars/Base", false, ""},
+		{"15", false, ""},
+		{"-13", false, ""},
+		{"Europe/Bucharest", true, "Europe/Bucharest"},
+		{"UTC", true, "UTC"},
+		{"3", true, "+03:00"},
+		{"-5", true, "-05:00"},
+	}
+	for _, tt := range tests {
+		loc, err := LoadTimezone(tt.name)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: got error %v, want valid=%t", tt.name, err, tt.valid)
+			continue
+		}
+		if tt.valid && loc.String() != tt.want {
+			t.Errorf("%q: got zone %q, want %q", tt.name, loc, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"net/url"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -35,3 +36,29 @@ func DecodeQueryParameterID(q url.Values, p string) (primitive.ObjectID, error)
 
 	return id, nil
 }
+
+// DecodeQueryParameterIDs decodes a repeated or comma-separated input query parameter into MongoDB ObjectIDs
+func DecodeQueryParameterIDs(q url.Values, p string) ([]primitive.ObjectID, error) {
+	var ids []primitive.ObjectID
+
+	for _, raw := range q[p] {
+		for _, rawID := range strings.Split(raw, ",") {
+			if rawID == "" {
+				continue
+			}
+
+			id, err := primitive.ObjectIDFromHex(rawID)
+			if err != nil {
+				return nil, NewError(ErrBEMongoIDCast, err, 2)
+			}
+
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, NewError(ErrBEMongoIDEmpty, nil, 2)
+	}
+
+	return ids, nil
+}
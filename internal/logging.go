@@ -0,0 +1,260 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/rs/zerolog"
+)
+
+type (
+	// Logger writes leveled, structured log lines; it implements the Echo logger so it can be used everywhere
+	Logger struct {
+		zl     zerolog.Logger
+		level  *int32 // The minimum level, shared by the copies of the logger so it can be changed while running
+		out    io.Writer
+		format string
+		prefix string
+	}
+
+	// Discards the events below a level that can be changed at any time
+	levelHook struct {
+		level *int32
+	}
+)
+
+const (
+	LogFormatJSON    = "json"    // One JSON object per line
+	LogFormatConsole = "console" // Human-readable lines, for local development
+)
+
+// Context keys of the request logging
+const (
+	logStartKey = "log_start"
+)
+
+// NewLogger creates a logger writing to the standard output with the given level and format
+func NewLogger(level, format string) (*Logger, error) {
+	// Check the format
+	if format != LogFormatJSON && format != LogFormatConsole {
+		return nil, fmt.Errorf("invalid log format %q, expected %s or %s", format, LogFormatJSON, LogFormatConsole)
+	}
+
+	l := &Logger{level: new(int32), out: os.Stdout, format: format}
+	if err := l.SetLevelName(level); err != nil {
+		return nil, err
+	}
+	l.zl = zerolog.New(l.writer(os.Stdout)).Hook(levelHook{l.level}).With().Timestamp().Logger()
+
+	return l, nil
+}
+
+// SetLevelName changes the minimum level of the logger, and of all its copies, from its name
+func (l *Logger) SetLevelName(level string) error {
+	lvl, err := zerolog.ParseLevel(level)
+	if err != nil || lvl == zerolog.NoLevel {
+		return fmt.Errorf("invalid log level %q, expected debug, info, warn or error", level)
+	}
+
+	atomic.StoreInt32(l.level, int32(lvl))
+	return nil
+}
+
+// Run the hook, discarding the leveled events below the minimum level
+func (h levelHook) Run(e *zerolog.Event, level zerolog.Level, _ string) {
+	if level != zerolog.NoLevel && level < zerolog.Level(atomic.LoadInt32(h.level)) {
+		e.Discard()
+	}
+}
+
+// Zerolog returns the underlying structured logger, to log with custom fields
+func (l *Logger) Zerolog() *zerolog.Logger {
+	return &l.zl
+}
+
+// Create a copy of the logger with extra fields on every line
+func (l *Logger) with(fn func(zerolog.Context) zerolog.Context) *Logger {
+	n := *l
+	n.zl = fn(l.zl.With()).Logger()
+	return &n
+}
+
+// Output returns the writer of the logger
+func (l *Logger) Output() io.Writer {
+	return l.out
+}
+
+// SetOutput changes the writer of the logger, keeping its level and fields
+func (l *Logger) SetOutput(w io.Writer) {
+	l.out = w
+	l.zl = l.zl.Output(l.writer(w))
+}
+
+// Wrap a writer to produce the lines in the format of the logger
+func (l *Logger) writer(w io.Writer) io.Writer {
+	if l.format == LogFormatConsole {
+		return zerolog.ConsoleWriter{Out: w, TimeFormat: time.RFC3339}
+	}
+
+	return w
+}
+
+// Prefix returns the component name of the logger
+func (l *Logger) Prefix() string {
+	return l.prefix
+}
+
+// SetPrefix adds the component name to every line of the logger
+func (l *Logger) SetPrefix(p string) {
+	l.prefix = p
+	l.zl = l.zl.With().Str("component", p).Logger()
+}
+
+// Level returns the minimum level of the logger
+func (l *Logger) Level() log.Lvl {
+	switch zerolog.Level(atomic.LoadInt32(l.level)) {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return log.DEBUG
+	case zerolog.InfoLevel:
+		return log.INFO
+	case zerolog.WarnLevel:
+		return log.WARN
+	case zerolog.ErrorLevel:
+		return log.ERROR
+	default:
+		return log.OFF
+	}
+}
+
+// SetLevel changes the minimum level of the logger, and of all its copies
+func (l *Logger) SetLevel(v log.Lvl) {
+	lvl := zerolog.Disabled
+	switch v {
+	case log.DEBUG:
+		lvl = zerolog.DebugLevel
+	case log.INFO:
+		lvl = zerolog.InfoLevel
+	case log.WARN:
+		lvl = zerolog.WarnLevel
+	case log.ERROR:
+		lvl = zerolog.ErrorLevel
+	}
+
+	atomic.StoreInt32(l.level, int32(lvl))
+}
+
+// SetHeader is a no-op, as the lines are structured
+func (l *Logger) SetHeader(string) {}
+
+func (l *Logger) Print(i ...interface{})                    { l.zl.Log().Msg(fmt.Sprint(i...)) }
+func (l *Logger) Printf(format string, args ...interface{}) { l.zl.Log().Msgf(format, args...) }
+func (l *Logger) Printj(j log.JSON)                         { l.zl.Log().Fields(map[string]interface{}(j)).Send() }
+func (l *Logger) Debug(i ...interface{})                    { l.zl.Debug().Msg(fmt.Sprint(i...)) }
+func (l *Logger) Debugf(format string, args ...interface{}) { l.zl.Debug().Msgf(format, args...) }
+func (l *Logger) Debugj(j log.JSON)                         { l.zl.Debug().Fields(map[string]interface{}(j)).Send() }
+func (l *Logger) Info(i ...interface{})                     { l.zl.Info().Msg(fmt.Sprint(i...)) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.zl.Info().Msgf(format, args...) }
+func (l *Logger) Infoj(j log.JSON)                          { l.zl.Info().Fields(map[string]interface{}(j)).Send() }
+func (l *Logger) Warn(i ...interface{})                     { l.zl.Warn().Msg(fmt.Sprint(i...)) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.zl.Warn().Msgf(format, args...) }
+func (l *Logger) Warnj(j log.JSON)                          { l.zl.Warn().Fields(map[string]interface{}(j)).Send() }
+func (l *Logger) Error(i ...interface{})                    { l.zl.Error().Msg(fmt.Sprint(i...)) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.zl.Error().Msgf(format, args...) }
+func (l *Logger) Errorj(j log.JSON)                         { l.zl.Error().Fields(map[string]interface{}(j)).Send() }
+func (l *Logger) Fatal(i ...interface{})                    { l.zl.Fatal().Msg(fmt.Sprint(i...)) }
+func (l *Logger) Fatalf(format string, args ...interface{}) { l.zl.Fatal().Msgf(format, args...) }
+func (l *Logger) Fatalj(j log.JSON)                         { l.zl.Fatal().Fields(map[string]interface{}(j)).Send() }
+func (l *Logger) Panic(i ...interface{})                    { l.zl.Panic().Msg(fmt.Sprint(i...)) }
+func (l *Logger) Panicf(format string, args ...interface{}) { l.zl.Panic().Msgf(format, args...) }
+func (l *Logger) Panicj(j log.JSON)                         { l.zl.Panic().Fields(map[string]interface{}(j)).Send() }
+
+// RequestLogger logs every request once it is handled, with its ID, user, route, status and latency, and gives the
+// request a logger carrying its ID, route and trace; it must run after the request ID and tracing middlewares
+func RequestLogger(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// Start timing
+		c.Set(logStartKey, time.Now())
+
+		// Give the request its own logger
+		if l, ok := c.Logger().(*Logger); ok {
+			c.SetLogger(l.with(func(zc zerolog.Context) zerolog.Context {
+				return requestFields(zc, c)
+			}))
+		}
+
+		// Execute the request, sending the error response if any
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+
+		// Log the request at a level matching its status
+		status := c.Response().Status
+		l := RequestLog(c)
+		ev := l.Info()
+		switch {
+		case status >= 500:
+			ev = l.Error()
+		case status >= 400:
+			ev = l.Warn()
+		}
+		ev.Str("uri", c.Request().RequestURI).
+			Str("remote_ip", c.RealIP()).
+			Int64("bytes_out", c.Response().Size).
+			Msg("Request handled")
+
+		return nil
+	}
+}
+
+// RequestLog returns the logger of the current request, along with its user, its latency so far and its status once
+// the response is sent
+func RequestLog(c echo.Context) *zerolog.Logger {
+	// Use the request logger, or a plain one if the request was not logged by the middleware
+	var zc zerolog.Context
+	if l, ok := c.Logger().(*Logger); ok {
+		zc = l.zl.With()
+	} else {
+		zc = requestFields(zerolog.New(c.Logger().Output()).With().Timestamp(), c)
+	}
+
+	// Add the logged in user
+	if t, ok := c.Get("user").(*jwt.Token); ok {
+		if claims, ok := t.Claims.(*JWTClaims); ok {
+			zc = zc.Str("user_id", claims.Id)
+		}
+	}
+
+	// Add the status, once known
+	if c.Response().Committed {
+		zc = zc.Int("status", c.Response().Status)
+	}
+
+	// Add the time spent so far
+	if start, ok := c.Get(logStartKey).(time.Time); ok {
+		zc = zc.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000)
+	}
+
+	l := zc.Logger()
+	return &l
+}
+
+// Add the fields identifying a request to a logger
+func requestFields(zc zerolog.Context, c echo.Context) zerolog.Context {
+	zc = zc.
+		Str("request_id", c.Response().Header().Get(echo.HeaderXRequestID)).
+		Str("method", c.Request().Method).
+		Str("route", c.Path())
+
+	// Link the lines to the trace of the request
+	if id := TraceID(c.Request().Context()); id != "" {
+		zc = zc.Str("trace_id", id)
+	}
+
+	return zc
+}
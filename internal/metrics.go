@@ -1,62 +1,274 @@
 package internal
 
 import (
+	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
 )
 
 type Metrics struct {
-	httpEndpointResponseTime *prometheus.SummaryVec
-	httpResponseCode         *prometheus.CounterVec
+	registry *prometheus.Registry
+
+	httpRequestDuration *prometheus.HistogramVec
+	httpRequestSize     *prometheus.HistogramVec
+	httpResponseSize    *prometheus.HistogramVec
+	httpInFlight        prometheus.Gauge
+
+	dbCommandDuration    *prometheus.HistogramVec
+	dbConnections        prometheus.Gauge
+	dbConnectionsInUse   prometheus.Gauge
+	dbConnectionFailures prometheus.Counter
+
+	configVersion prometheus.Gauge
+	configReloads *prometheus.CounterVec
+
+	cacheRequests *prometheus.CounterVec
+	logins        *prometheus.CounterVec
+	emails        *prometheus.CounterVec
 }
 
-// NewMetrics creates a new Metrics handler
+const (
+	metricsNamespace = "echo_rest_api"
+	unmatchedRoute   = "unmatched"
+)
+
+// Label values of the outcome of an operation
+const (
+	resultSuccess = "success"
+	resultFailure = "failure"
+)
+
+// NewMetrics creates a new Metrics handler, with its own registry
 func NewMetrics() *Metrics {
-	// Summarize endpoint response times
-	httpEndpointResponseTime := prometheus.NewSummaryVec(prometheus.SummaryOpts{
-		Namespace: "echo_rest_api",
-		Name:      "endpoint_response_times",
-		Help:      "Endpoint response times",
-	}, []string{"endpoint"})
-	prometheus.MustRegister(httpEndpointResponseTime)
+	sizeBuckets := prometheus.ExponentialBuckets(100, 10, 6) // 100 B to 10 MB
+
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		// Measure the requests by route, the route being the registered path so the labels stay bounded
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of the HTTP requests",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		httpRequestSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_size_bytes",
+			Help:      "Size of the HTTP request bodies",
+			Buckets:   sizeBuckets,
+		}, []string{"method", "route"}),
+		httpResponseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_response_size_bytes",
+			Help:      "Size of the HTTP response bodies",
+			Buckets:   sizeBuckets,
+		}, []string{"method", "route", "status"}),
+		httpInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_in_flight",
+			Help:      "Number of HTTP requests being served",
+		}),
+
+		// Measure the DB commands and connection pool
+		dbCommandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "mongodb_command_duration_seconds",
+			Help:      "Duration of the MongoDB commands",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"command", "result"}),
+		dbConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "mongodb_pool_connections",
+			Help:      "Number of open MongoDB connections",
+		}),
+		dbConnectionsInUse: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "mongodb_pool_connections_in_use",
+			Help:      "Number of MongoDB connections checked out of the pool",
+		}),
+		dbConnectionFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "mongodb_pool_checkout_failures_total",
+			Help:      "Number of failures to check a MongoDB connection out of the pool",
+		}),
 
-	// Count response codes
-	httpResponseCode := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace:   "echo_rest_api",
-		Name:        "response_codes",
-		Help:        "Response codes",
-		ConstLabels: nil,
-	}, []string{"code"})
-	prometheus.MustRegister(httpResponseCode)
+		// Track the configuration reloads
+		configVersion: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "config_version",
+			Help:      "Version of the active configuration, incremented on every applied reload",
+		}),
+		configReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "config_reloads_total",
+			Help:      "Number of configuration reloads",
+		}, []string{"result"}),
 
-	return &Metrics{
-		httpEndpointResponseTime: httpEndpointResponseTime,
-		httpResponseCode:         httpResponseCode,
+		// Count the cache lookups, the hit ratio being hits over all lookups
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_requests_total",
+			Help:      "Number of data cache lookups",
+		}, []string{"result"}),
+
+		// Count the business events
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "logins_total",
+			Help:      "Number of login attempts",
+		}, []string{"result"}),
+		emails: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "emails_sent_total",
+			Help:      "Number of emails sent",
+		}, []string{"result"}),
 	}
+
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.httpRequestDuration, m.httpRequestSize, m.httpResponseSize, m.httpInFlight,
+		m.dbCommandDuration, m.dbConnections, m.dbConnectionsInUse, m.dbConnectionFailures,
+		m.configVersion, m.configReloads,
+		m.cacheRequests, m.logins, m.emails,
+	)
+	m.configVersion.Set(1)
+
+	return m
+}
+
+// Registry returns the registry of the metrics, to add more collectors
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the metrics to Prometheus
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
 }
 
 // Handle metrics processing for all incoming requests
 func (m *Metrics) Handle(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		// Count the request while it is served
+		m.httpInFlight.Inc()
+		defer m.httpInFlight.Dec()
+
 		// Start timing
 		start := time.Now()
 
-		// Execute the request
-		err := next(c)
-		if err != nil {
+		// Execute the request, sending the error response if any so its status is measured
+		if err := next(c); err != nil {
 			c.Error(err)
 		}
 
-		// Observe the time passed for execution
-		end := time.Since(start).Seconds()
-		m.httpEndpointResponseTime.WithLabelValues(c.Path()).Observe(end)
+		// Label by the registered route, not by the requested path
+		method, route := c.Request().Method, c.Path()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(c.Response().Status)
+
+		// Observe the time passed for execution and the sizes
+		m.httpRequestDuration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+		if size := c.Request().ContentLength; size >= 0 {
+			m.httpRequestSize.WithLabelValues(method, route).Observe(float64(size))
+		}
+		m.httpResponseSize.WithLabelValues(method, route, status).Observe(float64(c.Response().Size))
+
+		return nil
+	}
+}
 
-		// Increment the count for the response code
-		m.httpResponseCode.WithLabelValues(strconv.Itoa(c.Response().Status)).Inc()
+// DBCommand observes the duration of a DB command
+func (m *Metrics) DBCommand(command string, d time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+
+	m.dbCommandDuration.WithLabelValues(command, result(!failed)).Observe(d.Seconds())
+}
 
-		return err
+// DBPoolEvent tracks the DB connection pool
+func (m *Metrics) DBPoolEvent(e *event.PoolEvent) {
+	if m == nil {
+		return
 	}
+
+	switch e.Type {
+	case event.ConnectionCreated:
+		m.dbConnections.Inc()
+	case event.ConnectionClosed:
+		m.dbConnections.Dec()
+	case event.GetSucceeded:
+		m.dbConnectionsInUse.Inc()
+	case event.ConnectionReturned:
+		m.dbConnectionsInUse.Dec()
+	case event.GetFailed:
+		m.dbConnectionFailures.Inc()
+	}
+}
+
+// ConfigVersion sets the version of the active configuration
+func (m *Metrics) ConfigVersion(version uint64) {
+	if m == nil {
+		return
+	}
+
+	m.configVersion.Set(float64(version))
+}
+
+// ConfigReload counts a configuration reload, applied or rejected
+func (m *Metrics) ConfigReload(success bool) {
+	if m == nil {
+		return
+	}
+
+	m.configReloads.WithLabelValues(result(success)).Inc()
+}
+
+// CacheLookup counts a data cache lookup
+func (m *Metrics) CacheLookup(hit bool) {
+	if m == nil {
+		return
+	}
+
+	if hit {
+		m.cacheRequests.WithLabelValues("hit").Inc()
+	} else {
+		m.cacheRequests.WithLabelValues("miss").Inc()
+	}
+}
+
+// Login counts a login attempt
+func (m *Metrics) Login(success bool) {
+	if m == nil {
+		return
+	}
+
+	m.logins.WithLabelValues(result(success)).Inc()
+}
+
+// Email counts a sent email
+func (m *Metrics) Email(success bool) {
+	if m == nil {
+		return
+	}
+
+	m.emails.WithLabelValues(result(success)).Inc()
+}
+
+// Get the label value of the outcome of an operation
+func result(success bool) string {
+	if success {
+		return resultSuccess
+	}
+
+	return resultFailure
 }
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Settings are the settings of the handlers and workers that can be reloaded while the server runs
+	Settings struct {
+		JwtExp    time.Duration
+		Origins   *Origins // The origins allowed by CORS
+		InviteURL string   // The base URL of the invite links
+		SMTP      SMTP
+	}
+
+	// LiveSettings holds the active settings, swapped atomically on reload, along with their version
+	LiveSettings struct {
+		v atomic.Value
+	}
+	versionedSettings struct {
+		Settings
+		version uint64
+	}
+)
+
+// NewLiveSettings creates the live settings with their first version
+func NewLiveSettings(s Settings) *LiveSettings {
+	l := new(LiveSettings)
+	l.v.Store(&versionedSettings{Settings: s, version: 1})
+	return l
+}
+
+// Get returns the active settings
+func (l *LiveSettings) Get() Settings {
+	return l.v.Load().(*versionedSettings).Settings
+}
+
+// Version returns the version of the active settings, incremented on every reload
+func (l *LiveSettings) Version() uint64 {
+	return l.v.Load().(*versionedSettings).version
+}
+
+// Set replaces the active settings, returning their new version; it must not be called concurrently
+func (l *LiveSettings) Set(s Settings) uint64 {
+	version := l.Version() + 1
+	l.v.Store(&versionedSettings{Settings: s, version: version})
+	return version
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/gomail.v2"
+)
+
+type (
+	// SMTP sends the emails of the handlers and workers through the configured SMTP server
+	SMTP struct {
+		Host string
+		Port int
+		User string
+		Pass string
+
+		Metrics *Metrics
+	}
+)
+
+// NewMessage creates an email message sent from the configured SMTP user
+func (s SMTP) NewMessage(to []string, subject string) *gomail.Message {
+	m := gomail.NewMessage()
+
+	// Set the required headers
+	m.SetHeaders(map[string][]string{
+		"From":    {m.FormatAddress(s.User, "REST.API")},
+		"To":      to,
+		"Subject": {subject},
+	})
+
+	return m
+}
+
+// Send sends an email message through the configured SMTP server
+func (s SMTP) Send(ctx context.Context, m *gomail.Message) (err error) {
+	// Trace the sending
+	_, span := StartSpan(ctx, "smtp.send", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.NetPeerNameKey.String(s.Host),
+		semconv.NetPeerPortKey.Int(s.Port),
+		attribute.Int("smtp.recipients", len(m.GetHeader("To"))),
+	))
+	defer func() {
+		s.Metrics.Email(err == nil)
+		EndSpan(span, err)
+	}()
+
+	d := gomail.NewDialer(s.Host, s.Port, s.User, s.Pass)
+	return d.DialAndSend(m)
+}
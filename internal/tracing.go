@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TraceExporterNone   = ""       // No spans are exported, the traces are still created and propagated
+	TraceExporterOTLP   = "otlp"   // Spans are exported over OTLP/HTTP, configured by the standard OTEL_EXPORTER_OTLP_* variables
+	TraceExporterStdout = "stdout" // Spans are printed on the standard output, for local testing
+)
+
+const (
+	serviceName = "echo_rest_api"
+)
+
+// NewTracing configures the global tracer provider with the given exporter and the W3C trace context propagation; the
+// returned function flushes the pending spans and must be called on shutdown
+func NewTracing(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	// Propagate the W3C trace context and baggage
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	// Describe the service, letting the standard OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES variables override it
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceNameKey.String(serviceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	// Create the exporter; without one, the traces are still created so their IDs show up in the logs
+	var exp sdktrace.SpanExporter
+	switch exporter {
+	case TraceExporterNone:
+	case TraceExporterOTLP:
+		exp, err = otlptracehttp.New(ctx)
+	case TraceExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("invalid trace exporter %q, expected %s or %s", exporter, TraceExporterOTLP, TraceExporterStdout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if exp != nil {
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// StartSpan starts a span of the service as a child of the span of the given context, if any
+func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(serviceName).Start(ctx, name, opts...)
+}
+
+// EndSpan records the error of an operation, if any, and ends its span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceID returns the ID of the trace of the given context, or an empty string if it is not traced
+func TraceID(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+
+	return ""
+}
+
+// TraceRequests traces every request, continuing the trace given by the requester if any
+func TraceRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+
+		// Continue the trace of the requester
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+		// Start the request span, named after its route
+		ctx, span := StartSpan(ctx, fmt.Sprintf("%s %s", req.Method, c.Path()),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.NetAttributesFromHTTPRequest("tcp", req)...),
+			trace.WithAttributes(semconv.EndUserAttributesFromHTTPRequest(req)...),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(serviceName, c.Path(), req)...),
+		)
+		defer span.End()
+		c.SetRequest(req.WithContext(ctx))
+
+		// Execute the request, sending the error response if any
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+
+		// Record the response status
+		status := c.Response().Status
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status), semconv.HTTPResponseContentLengthKey.Int64(c.Response().Size))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+
+		return nil
+	}
+}
@@ -3,6 +3,7 @@ package internal
 import (
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator"
 )
@@ -33,6 +34,18 @@ func CreateValidator() (*Validator, error) {
 		return nil, errors.New("could not assign password validator")
 	}
 
+	// Register cron schedule validation
+	err = v.RegisterValidation("cron", ValidateCron)
+	if err != nil {
+		return nil, errors.New("could not assign cron validator")
+	}
+
+	// Register timezone validation
+	err = v.RegisterValidation("timezone", ValidateTimezone)
+	if err != nil {
+		return nil, errors.New("could not assign timezone validator")
+	}
+
 	return &Validator{validator: v}, nil
 }
 
@@ -54,3 +67,15 @@ func ValidatePassword(f validator.FieldLevel) bool {
 		strings.ContainsAny(s, "0123456789") &&
 		strings.ContainsAny(s, "{}[]:;'\"\\/?.<>,=+-_()!@#$%^&*~`")
 }
+
+// ValidateCron will validate a cron schedule field, which must have an upcoming time
+func ValidateCron(f validator.FieldLevel) bool {
+	c, err := ParseCron(f.Field().String())
+	return err == nil && !c.Next(time.Now()).IsZero()
+}
+
+// ValidateTimezone will validate a timezone field
+func ValidateTimezone(f validator.FieldLevel) bool {
+	_, err := LoadTimezone(f.Field().String())
+	return err == nil
+}
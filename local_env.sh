@@ -1,7 +1,7 @@
 export INST_PORT='8080'
 export INST_SKIP_CHECKS='false'
 export INST_SECURE='false'
-export INST_SECRET_KEY='Dx-G}ZfY45{!`yg^KS1caZBEVC)xV7V@mckbnV]ZhH*I~@8oyw6cb~}vgI+BAu7'
+export INST_SECRET_KEY='local-only-secret-replace-me-with-32+-random-chars'
 export INST_JWT_EXP='72h'
 export INST_DB_URI='mongodb://localhost:27017'
 export INST_DB_NAME='testing_db'
@@ -0,0 +1,224 @@
+package render
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+	"github.com/xuri/excelize/v2"
+
+	"echo_rest_api/database/model"
+)
+
+type (
+	// Exporter writes stats rows into a file, one row per bucket and one column per series
+	Exporter interface {
+		Write(r *model.StatsRow) error
+		Close() error
+	}
+
+	csvExporter struct {
+		w    *csv.Writer
+		sq   *model.Stats
+		rows int
+	}
+
+	xlsxExporter struct {
+		w   io.Writer
+		f   *excelize.File
+		sw  *excelize.StreamWriter
+		sq  *model.Stats
+		row int
+	}
+
+	parquetExporter struct {
+		w    io.Writer
+		pw   *writer.CSVWriter
+		sq   *model.Stats
+		cols []model.StatsColumn
+	}
+)
+
+const (
+	ExportCSV     = "csv"     // Comma-separated values
+	ExportXLSX    = "xlsx"    // Excel workbook
+	ExportParquet = "parquet" // Apache Parquet
+
+	exportFlushRows     = 1000            // The rows buffered before flushing a CSV export
+	parquetRowGroupSize = 8 * 1024 * 1024 // The bytes buffered before flushing a Parquet row group
+	xlsxSheet           = "Sheet1"
+)
+
+var (
+	// ExportFormats holds all the available export formats
+	ExportFormats = []string{ExportCSV, ExportXLSX, ExportParquet}
+
+	// ExportContentTypes holds the media type of each export format
+	ExportContentTypes = map[string]string{
+		ExportCSV:     "text/csv",
+		ExportXLSX:    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		ExportParquet: "application/vnd.apache.parquet",
+	}
+)
+
+// NewExporter creates an exporter of stats rows in the given format, writing the header right away
+func NewExporter(format string, w io.Writer, sq *model.Stats, cols []model.StatsColumn) (Exporter, error) {
+	switch format {
+	case ExportXLSX:
+		x := &xlsxExporter{w: w, f: excelize.NewFile(), sq: sq, row: 1}
+
+		sw, err := x.f.NewStreamWriter(xlsxSheet)
+		if err != nil {
+			return nil, err
+		}
+		x.sw = sw
+
+		header := make([]interface{}, 0, len(cols)+1)
+		header = append(header, "time")
+		for _, col := range cols {
+			header = append(header, col.Name)
+		}
+
+		return x, x.setRow(header)
+	case ExportParquet:
+		// The Parquet writer starts writing the file as soon as it is created, so wait for the first row
+		return &parquetExporter{w: w, sq: sq, cols: cols}, nil
+	default:
+		x := &csvExporter{w: csv.NewWriter(w), sq: sq}
+
+		header := make([]string, 0, len(cols)+1)
+		header = append(header, "time")
+		for _, col := range cols {
+			header = append(header, col.Name)
+		}
+
+		return x, x.w.Write(header)
+	}
+}
+
+// Format the time of a stats row as an ISO timestamp in the requested timezone
+func exportTime(sq *model.Stats, t time.Time) string {
+	return t.In(sq.TZ).Format(time.RFC3339)
+}
+
+// Write a stats row as a CSV record, with the missing values left empty
+func (x *csvExporter) Write(r *model.StatsRow) error {
+	record := make([]string, 0, len(r.Values)+1)
+	record = append(record, exportTime(x.sq, r.Time))
+	for _, v := range r.Values {
+		if v == nil {
+			record = append(record, "")
+			continue
+		}
+
+		record = append(record, strconv.FormatFloat(*v, 'f', -1, 64))
+	}
+
+	if err := x.w.Write(record); err != nil {
+		return err
+	}
+
+	// Flush regularly, so the rows are streamed instead of buffered
+	if x.rows++; x.rows%exportFlushRows == 0 {
+		x.w.Flush()
+		return x.w.Error()
+	}
+
+	return nil
+}
+
+// Close flushes the remaining CSV records
+func (x *csvExporter) Close() error {
+	x.w.Flush()
+	return x.w.Error()
+}
+
+// Write a stats row as a worksheet row, with the missing values left blank
+func (x *xlsxExporter) Write(r *model.StatsRow) error {
+	values := make([]interface{}, 0, len(r.Values)+1)
+	values = append(values, exportTime(x.sq, r.Time))
+	for _, v := range r.Values {
+		if v == nil {
+			values = append(values, nil)
+			continue
+		}
+
+		values = append(values, *v)
+	}
+
+	return x.setRow(values)
+}
+
+// Add a row to the worksheet
+func (x *xlsxExporter) setRow(values []interface{}) error {
+	axis, err := excelize.CoordinatesToCellName(1, x.row)
+	if err != nil {
+		return err
+	}
+	x.row++
+
+	return x.sw.SetRow(axis, values)
+}
+
+// Close completes the worksheet and writes the workbook
+func (x *xlsxExporter) Close() error {
+	if err := x.sw.Flush(); err != nil {
+		return err
+	}
+
+	return x.f.Write(x.w)
+}
+
+// Create the Parquet writer, with an optional double column per series
+func (x *parquetExporter) open() error {
+	md := make([]string, 0, len(x.cols)+1)
+	md = append(md, "name=time, type=BYTE_ARRAY, convertedtype=UTF8")
+	for _, col := range x.cols {
+		md = append(md, fmt.Sprintf("name=%s, type=DOUBLE, repetitiontype=OPTIONAL", col.Key))
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(md, x.w, 1)
+	if err != nil {
+		return err
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	x.pw = pw
+
+	return nil
+}
+
+// Write a stats row as a Parquet record, with the missing values as nulls
+func (x *parquetExporter) Write(r *model.StatsRow) error {
+	if x.pw == nil {
+		if err := x.open(); err != nil {
+			return err
+		}
+	}
+
+	record := make([]interface{}, 0, len(r.Values)+1)
+	record = append(record, exportTime(x.sq, r.Time))
+	for _, v := range r.Values {
+		if v == nil {
+			record = append(record, nil)
+			continue
+		}
+
+		record = append(record, *v)
+	}
+
+	return x.pw.Write(record)
+}
+
+// Close writes the remaining row group and the Parquet footer
+func (x *parquetExporter) Close() error {
+	if x.pw == nil {
+		if err := x.open(); err != nil {
+			return err
+		}
+	}
+
+	return x.pw.WriteStop()
+}
@@ -0,0 +1,92 @@
+package render
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+
+	"echo_rest_api/database/model"
+)
+
+// Export the rows in a format, with a missing value in the middle
+func export(t *testing.T, format string) *bytes.Buffer {
+	t.Helper()
+
+	v := func(f float64) *float64 { return &f }
+	tz := time.FixedZone("+02:00", 2*60*60)
+	sq := &model.Stats{TZ: tz}
+	cols := []model.StatsColumn{{Name: "Entered"}, {Name: "Exited"}}
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	b := new(bytes.Buffer)
+	x, err := NewExporter(format, b, sq, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*model.StatsRow{
+		{Time: start, Values: []*float64{v(3), v(1.5)}},
+		{Time: start.Add(time.Hour), Values: []*float64{nil, v(0)}},
+		{Time: start.Add(2 * time.Hour), Values: []*float64{v(2), nil}},
+	} {
+		if err = x.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = x.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestExportMissingValues(t *testing.T) {
+	want := [][]string{
+		{"time", "Entered", "Exited"},
+		{"2024-03-01T02:00:00+02:00", "3", "1.5"},
+		{"2024-03-01T03:00:00+02:00", "", "0"},
+		{"2024-03-01T04:00:00+02:00", "2", ""},
+	}
+
+	tests := []struct {
+		format string
+		rows   func(t *testing.T, b *bytes.Buffer) [][]string
+	}{
+		{ExportCSV, func(t *testing.T, b *bytes.Buffer) [][]string {
+			var rows [][]string
+			for _, l := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+				rows = append(rows, strings.Split(l, ","))
+			}
+			return rows
+		}},
+		{ExportXLSX, func(t *testing.T, b *bytes.Buffer) [][]string {
+			f, err := excelize.OpenReader(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := f.GetRows(xlsxSheet)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The trailing blank cells are left out of the rows
+			for i := range rows {
+				for len(rows[i]) < len(want[i]) {
+					rows[i] = append(rows[i], "")
+				}
+			}
+			return rows
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			// The missing values are exported as empty fields or blank cells, not zeroes
+			if got := tt.rows(t, export(t, tt.format)); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package render
+
+import (
+	"html/template"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"echo_rest_api/database/model"
+	"echo_rest_api/internal"
+)
+
+type (
+	// Summary accumulates the totals and extremes of each series of streamed stats rows
+	Summary struct {
+		sq     *model.Stats
+		Series []SummarySeries
+	}
+
+	// SummarySeries holds the summary of a single series
+	SummarySeries struct {
+		Name     string
+		Additive bool
+		Total    float64
+		Min      float64
+		Max      float64
+		MaxTime  time.Time
+		count    int
+	}
+
+	reportData struct {
+		Report *model.Report
+		Start  string
+		End    string
+		Series []reportSeries
+	}
+
+	reportSeries struct {
+		Name    string
+		Total   string
+		Average string
+		Min     string
+		Max     string
+		MaxTime string
+	}
+)
+
+var (
+	// The metrics whose values can be added up over the buckets
+	additiveMetrics = []string{
+		model.StatsMetricEntered, model.StatsMetricExited, model.StatsMetricNet, model.StatsMetricAboveCapacity,
+	}
+
+	reportTemplate = template.Must(template.New("report").Parse(`<html>
+<body style="font-family: sans-serif;">
+<h2>{{.Report.Name}}</h2>
+<p>Statistics from {{.Start}} to {{.End}} ({{.Report.Timezone}}).</p>
+<table cellpadding="6" style="border-collapse: collapse;" border="1">
+<tr><th align="left">Series</th><th>Total</th><th>Average</th><th>Min</th><th>Max</th><th>Max at</th></tr>
+{{range .Series}}<tr><td>{{.Name}}</td><td align="right">{{.Total}}</td><td align="right">{{.Average}}</td><td align="right">{{.Min}}</td><td align="right">{{.Max}}</td><td>{{.MaxTime}}</td></tr>
+{{end}}</table>
+<p>The data of every {{.Report.IntervalType}} is attached.</p>
+</body>
+</html>`))
+)
+
+// NewSummary creates an empty summary of the given stats columns
+func NewSummary(sq *model.Stats, cols []model.StatsColumn) *Summary {
+	s := &Summary{sq: sq, Series: make([]SummarySeries, len(cols))}
+	for i, col := range cols {
+		s.Series[i] = SummarySeries{
+			Name:     col.Name,
+			Additive: internal.InSlice(col.Metric, additiveMetrics),
+			Min:      math.Inf(1),
+			Max:      math.Inf(-1),
+		}
+	}
+
+	return s
+}
+
+// Add the values of a stats row to the summary
+func (s *Summary) Add(r *model.StatsRow) {
+	for i, v := range r.Values {
+		if v == nil {
+			continue
+		}
+
+		ss := &s.Series[i]
+		ss.count++
+		ss.Total += *v
+		ss.Min = math.Min(ss.Min, *v)
+		if *v > ss.Max {
+			ss.Max, ss.MaxTime = *v, r.Time
+		}
+	}
+}
+
+// Report renders the HTML email of a report from the summary of its stats
+func Report(w io.Writer, r *model.Report, s *Summary) error {
+	d := reportData{
+		Report: r,
+		Start:  s.sq.Start.In(s.sq.TZ).Format("2006-01-02 15:04"),
+		End:    s.sq.End.In(s.sq.TZ).Format("2006-01-02 15:04"),
+		Series: make([]reportSeries, 0, len(s.Series)),
+	}
+
+	for _, ss := range s.Series {
+		rs := reportSeries{Name: ss.Name, Total: "-", Average: "-", Min: "-", Max: "-", MaxTime: "-"}
+		if ss.count > 0 {
+			if ss.Additive {
+				rs.Total = formatValue(ss.Total)
+			}
+			rs.Average = formatValue(ss.Total / float64(ss.count))
+			rs.Min = formatValue(ss.Min)
+			rs.Max = formatValue(ss.Max)
+			rs.MaxTime = Label(ss.MaxTime.In(s.sq.TZ), s.sq.Interval)
+		}
+
+		d.Series = append(d.Series, rs)
+	}
+
+	return reportTemplate.Execute(w, d)
+}
+
+// Format a summary value with at most two decimals
+func formatValue(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
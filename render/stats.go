@@ -0,0 +1,411 @@
+package render
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"echo_rest_api/database/model"
+)
+
+type (
+	// Options holds the chart rendering options of stats data
+	Options struct {
+		Format    string
+		ChartType string
+	}
+
+	canvasJS struct {
+		*model.Stats
+		ChartType  string              `json:"chartType"`
+		AxisX      canvasJSAxisX       `json:"axisX"`
+		Data       []canvasJSData      `json:"data"`
+		Series     interface{}         `json:"series,omitempty"`
+		Comparison *canvasJSComparison `json:"comparison,omitempty"`
+	}
+
+	canvasJSAxisX struct {
+		ValueFormat  string `json:"valueFormatString"`
+		IntervalType string `json:"intervalType"`
+		IntervalSize int    `json:"intervalSize,omitempty"`
+	}
+
+	canvasJSData struct {
+		Type         string              `json:"type"`
+		Name         string              `json:"name"`
+		Entity       string              `json:"entity,omitempty"`
+		Legend       bool                `json:"showInLegend"`
+		XValueType   string              `json:"xValueType"`
+		XValueFormat string              `json:"xValueFormatString"`
+		Data         []canvasJSDataPoint `json:"dataPoints"`
+	}
+
+	canvasJSDataPoint struct {
+		Label string   `json:"label"`
+		X     int64    `json:"x"`
+		Y     *float64 `json:"y"`
+	}
+
+	canvasJSComparison struct {
+		Start  time.Time          `json:"start"`
+		End    time.Time          `json:"end"`
+		Data   []canvasJSData     `json:"data"`
+		Deltas []model.StatsDelta `json:"deltas"`
+	}
+
+	chartJS struct {
+		Type string      `json:"type"`
+		Data chartJSData `json:"data"`
+	}
+
+	chartJSData struct {
+		Labels   []string         `json:"labels"`
+		Datasets []chartJSDataset `json:"datasets"`
+	}
+
+	chartJSDataset struct {
+		Label      string         `json:"label"`
+		Data       []chartJSPoint `json:"data"`
+		Fill       bool           `json:"fill"`
+		Tension    float64        `json:"tension,omitempty"`
+		Stack      string         `json:"stack,omitempty"`
+		BorderDash []int          `json:"borderDash,omitempty"`
+	}
+
+	chartJSPoint struct {
+		X string   `json:"x"`
+		Y *float64 `json:"y"`
+	}
+
+	vegaLite struct {
+		Schema   string           `json:"$schema"`
+		Data     vegaLiteData     `json:"data"`
+		Mark     vegaLiteMark     `json:"mark"`
+		Encoding vegaLiteEncoding `json:"encoding"`
+	}
+
+	vegaLiteData struct {
+		Values []vegaLiteValue `json:"values"`
+	}
+
+	vegaLiteValue struct {
+		Time   time.Time `json:"time"`
+		Label  string    `json:"label"`
+		Series string    `json:"series"`
+		Metric string    `json:"metric"`
+		Entity string    `json:"entity,omitempty"`
+		Period string    `json:"period"`
+		Value  *float64  `json:"value"`
+	}
+
+	vegaLiteMark struct {
+		Type  string `json:"type"`
+		Point bool   `json:"point,omitempty"`
+	}
+
+	vegaLiteEncoding struct {
+		X          vegaLiteChannel  `json:"x"`
+		Y          vegaLiteChannel  `json:"y"`
+		Color      vegaLiteChannel  `json:"color"`
+		StrokeDash *vegaLiteChannel `json:"strokeDash,omitempty"`
+	}
+
+	vegaLiteChannel struct {
+		Field string `json:"field"`
+		Type  string `json:"type"`
+		Title string `json:"title,omitempty"`
+	}
+)
+
+const (
+	FormatPlain    = "plain"    // The chart-neutral time series
+	FormatCanvasJS = "canvasjs" // The CanvasJS chart options
+	FormatChartJS  = "chartjs"  // The Chart.js chart configuration
+	FormatVegaLite = "vegalite" // The Vega-Lite chart specification
+
+	vegaLiteSchema = "https://vega.github.io/schema/vega-lite/v5.json"
+)
+
+var (
+	// Formats holds all the available chart formats
+	Formats = []string{FormatPlain, FormatCanvasJS, FormatChartJS, FormatVegaLite}
+
+	// ChartTypes holds all the available chart types
+	ChartTypes = []string{"area", "line", "spline", "column", "stackedColumn", "bar"}
+)
+
+// Stats renders stats data in the requested chart format
+func Stats(sq *model.Stats, o Options) interface{} {
+	switch o.Format {
+	case FormatCanvasJS:
+		return statsCanvasJS(sq, o.ChartType)
+	case FormatChartJS:
+		return statsChartJS(sq, o.ChartType)
+	case FormatVegaLite:
+		return statsVegaLite(sq, o.ChartType)
+	default:
+		return sq
+	}
+}
+
+// Label formats the start time of a bucket as a label for its interval type
+func Label(t time.Time, iv model.StatsInterval) string {
+	var label string
+	switch iv.Type {
+	case model.StatsIntervalNone:
+		label = t.Format("2006-01-02 15:04:05")
+	case model.StatsIntervalMinute:
+		label = t.Format("2006-01-02 15:04")
+	case model.StatsIntervalHour:
+		label = t.Format("2006-01-02 15:00 - 15:") + "59"
+	case model.StatsIntervalDay:
+		label = t.Format("2006-01-02")
+	case model.StatsIntervalWeek:
+		y, w := t.ISOWeek()
+		label = fmt.Sprintf("%d-W%02d", y, w)
+	case model.StatsIntervalMonth:
+		label = t.Format("2006-01")
+	case model.StatsIntervalQuarter:
+		label = fmt.Sprintf("%d-Q%d", t.Year(), (t.Month()-1)/3+1)
+	case model.StatsIntervalYear:
+		label = t.Format("2006")
+	}
+
+	return label
+}
+
+// Render stats data as CanvasJS chart options
+func statsCanvasJS(sq *model.Stats, ct string) *canvasJS {
+	// Build the moment-style format of the X axis values
+	var vf string
+	switch sq.Interval.Type {
+	case model.StatsIntervalNone, model.StatsIntervalHour:
+		vf = "DD-MM-YYYY HH:mm:ss"
+	case model.StatsIntervalMinute:
+		vf = "DD-MM-YYYY HH:mm"
+	case model.StatsIntervalDay, model.StatsIntervalWeek:
+		vf = "DD-MM-YYYY"
+	case model.StatsIntervalMonth:
+		vf = "MM-YYYY"
+	case model.StatsIntervalQuarter:
+		vf = "MMM YYYY"
+	case model.StatsIntervalYear:
+		vf = "YYYY"
+	}
+
+	// Convert a series into a chart entry, optionally placed over the buckets of another series
+	data := func(ss model.StatsSeries, over []model.StatsPoint) canvasJSData {
+		d := canvasJSData{
+			Type:         ct,
+			Name:         ss.Name,
+			Entity:       ss.Entity,
+			Legend:       true,
+			XValueType:   "dateTime",
+			XValueFormat: vf,
+			Data:         make([]canvasJSDataPoint, 0, len(ss.Points)),
+		}
+
+		for i, p := range ss.Points {
+			t := p.Time
+			if over != nil {
+				if i >= len(over) {
+					break
+				}
+				t = over[i].Time
+			}
+
+			d.Data = append(d.Data, canvasJSDataPoint{
+				Label: Label(t, sq.Interval),
+				X:     t.UnixNano() / int64(time.Millisecond),
+				Y:     p.Value,
+			})
+		}
+
+		return d
+	}
+
+	c := &canvasJS{
+		Stats:     sq,
+		ChartType: ct,
+		AxisX: canvasJSAxisX{
+			ValueFormat:  vf,
+			IntervalType: sq.Interval.Type,
+			IntervalSize: sq.Interval.Size,
+		},
+		Data: make([]canvasJSData, 0, len(sq.Series)),
+	}
+	for _, ss := range sq.Series {
+		c.Data = append(c.Data, data(ss, nil))
+	}
+
+	// Place the comparison series over the requested buckets, so both can be drawn over the same axis
+	if sq.Comparison != nil {
+		c.Comparison = &canvasJSComparison{
+			Start:  sq.Comparison.Start,
+			End:    sq.Comparison.End,
+			Data:   make([]canvasJSData, 0, len(sq.Comparison.Series)),
+			Deltas: sq.Comparison.Deltas,
+		}
+		for i, ss := range sq.Comparison.Series {
+			ss.Name = fmt.Sprintf("%s (%s)", ss.Name, compareLabel(sq.CompareTo))
+			c.Comparison.Data = append(c.Comparison.Data, data(ss, sq.Series[i].Points))
+		}
+	}
+
+	return c
+}
+
+// Render stats data as a Chart.js chart configuration
+func statsChartJS(sq *model.Stats, ct string) *chartJS {
+	c := &chartJS{
+		Type: "line",
+		Data: chartJSData{
+			Labels:   []string{},
+			Datasets: []chartJSDataset{},
+		},
+	}
+
+	// Map the chart type onto the Chart.js one
+	var fill, stacked bool
+	var tension float64
+	switch ct {
+	case "area":
+		fill = true
+	case "spline":
+		tension = 0.4
+	case "column", "bar":
+		c.Type = "bar"
+	case "stackedColumn":
+		c.Type, stacked = "bar", true
+	}
+
+	// Collect the labels of all buckets, ordered by time
+	labels := map[string]time.Time{}
+	for _, ss := range sq.Series {
+		for _, p := range ss.Points {
+			labels[Label(p.Time, sq.Interval)] = p.Time
+		}
+	}
+	for l := range labels {
+		c.Data.Labels = append(c.Data.Labels, l)
+	}
+	sort.Slice(c.Data.Labels, func(i, j int) bool {
+		return labels[c.Data.Labels[i]].Before(labels[c.Data.Labels[j]])
+	})
+
+	// Convert a series into a dataset, optionally placed over the buckets of another series
+	dataset := func(ss model.StatsSeries, over []model.StatsPoint) chartJSDataset {
+		d := chartJSDataset{
+			Label:   ss.Name,
+			Data:    make([]chartJSPoint, 0, len(ss.Points)),
+			Fill:    fill,
+			Tension: tension,
+		}
+		if stacked {
+			d.Stack = "stack"
+		}
+
+		for i, p := range ss.Points {
+			t := p.Time
+			if over != nil {
+				if i >= len(over) {
+					break
+				}
+				t = over[i].Time
+			}
+
+			d.Data = append(d.Data, chartJSPoint{X: Label(t, sq.Interval), Y: p.Value})
+		}
+
+		return d
+	}
+
+	for _, ss := range sq.Series {
+		c.Data.Datasets = append(c.Data.Datasets, dataset(ss, nil))
+	}
+
+	// Draw the comparison series dashed, over the requested buckets
+	if sq.Comparison != nil {
+		for i, ss := range sq.Comparison.Series {
+			ss.Name = fmt.Sprintf("%s (%s)", ss.Name, compareLabel(sq.CompareTo))
+			d := dataset(ss, sq.Series[i].Points)
+			d.BorderDash = []int{5, 5}
+			c.Data.Datasets = append(c.Data.Datasets, d)
+		}
+	}
+
+	return c
+}
+
+// Render stats data as a Vega-Lite chart specification
+func statsVegaLite(sq *model.Stats, ct string) *vegaLite {
+	v := &vegaLite{
+		Schema: vegaLiteSchema,
+		Data: vegaLiteData{
+			Values: []vegaLiteValue{},
+		},
+		Mark: vegaLiteMark{Type: "line", Point: true},
+		Encoding: vegaLiteEncoding{
+			X:     vegaLiteChannel{Field: "time", Type: "temporal", Title: "Time"},
+			Y:     vegaLiteChannel{Field: "value", Type: "quantitative", Title: "Value"},
+			Color: vegaLiteChannel{Field: "series", Type: "nominal", Title: "Series"},
+		},
+	}
+
+	// Map the chart type onto the Vega-Lite mark
+	switch ct {
+	case "area":
+		v.Mark = vegaLiteMark{Type: "area"}
+	case "column", "stackedColumn", "bar":
+		v.Mark = vegaLiteMark{Type: "bar"}
+	}
+
+	// Flatten a series into data values, optionally placed over the buckets of another series
+	values := func(ss model.StatsSeries, period string, over []model.StatsPoint) {
+		for i, p := range ss.Points {
+			t := p.Time
+			if over != nil {
+				if i >= len(over) {
+					break
+				}
+				t = over[i].Time
+			}
+
+			v.Data.Values = append(v.Data.Values, vegaLiteValue{
+				Time:   t,
+				Label:  Label(t, sq.Interval),
+				Series: ss.Name,
+				Metric: ss.Metric,
+				Entity: ss.Entity,
+				Period: period,
+				Value:  p.Value,
+			})
+		}
+	}
+
+	for _, ss := range sq.Series {
+		values(ss, "current", nil)
+	}
+
+	// Dash the comparison series, drawn over the requested buckets
+	if sq.Comparison != nil {
+		for i, ss := range sq.Comparison.Series {
+			values(ss, compareLabel(sq.CompareTo), sq.Series[i].Points)
+		}
+		v.Encoding.StrokeDash = &vegaLiteChannel{Field: "period", Type: "nominal", Title: "Period"}
+	}
+
+	return v
+}
+
+// Get the label of a comparison period
+func compareLabel(ct string) string {
+	switch ct {
+	case model.StatsComparePrevious:
+		return "previous period"
+	case model.StatsCompareLastYear:
+		return "last year"
+	default:
+		return "comparison period"
+	}
+}
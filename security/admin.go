@@ -0,0 +1,91 @@
+package security
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"echo_rest_api/internal"
+)
+
+// AdminRestricted checks if the access to the admin server is restricted, by basic auth or by an IP allowlist
+func AdminRestricted(env *internal.Environ) bool {
+	return len(env.AdminAllowedIPs) > 0 || env.AdminUser != "" || env.AdminPass != ""
+}
+
+// ConfigureAdminSecurity restricts the admin server to the allowed IPs and to basic auth, when configured
+func ConfigureAdminSecurity(e *echo.Echo, env *internal.Environ) {
+	// Configure the IP allowlist
+	if len(env.AdminAllowedIPs) > 0 {
+		allow, err := IPAllowlist(env.AdminAllowedIPs)
+		if err != nil {
+			e.Logger.Fatal(err)
+		}
+		e.Use(allow)
+	}
+
+	// Configure basic auth
+	if env.AdminUser != "" || env.AdminPass != "" {
+		e.Use(middleware.BasicAuth(func(user, pass string, c echo.Context) (bool, error) {
+			// Compare both in constant time, so the response time does not tell which one is wrong
+			userOK := subtle.ConstantTimeCompare([]byte(user), []byte(env.AdminUser)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(env.AdminPass)) == 1
+			return userOK && passOK, nil
+		}))
+	}
+}
+
+// IPAllowlist creates a middleware rejecting the requests not coming from the given IPs or CIDR ranges; the address
+// of the connection is checked, not the forwarding headers, as they can be set by anyone
+func IPAllowlist(entries []string) (echo.MiddlewareFunc, error) {
+	// Parse the allowed networks
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+
+		// Consider a single IP as a network of one address
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid allowed IP %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed IP range %q: %s", entry, err)
+		}
+		nets = append(nets, n)
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// Get the address of the connection
+			host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+			if err != nil {
+				host = c.Request().RemoteAddr
+			}
+
+			if ip := net.ParseIP(host); ip != nil {
+				for _, n := range nets {
+					if n.Contains(ip) {
+						return next(c)
+					}
+				}
+			}
+
+			return echo.NewHTTPError(http.StatusForbidden, http.StatusText(http.StatusForbidden))
+		}
+	}, nil
+}
@@ -0,0 +1,54 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestIPAllowlist(t *testing.T) {
+	allow, err := IPAllowlist([]string{"10.0.0.0/8", " 192.168.1.10", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(allow)
+	e.GET("/metrics", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		code       int
+	}{
+		{"10.1.2.3:5000", "", http.StatusOK},
+		{"192.168.1.10:5000", "", http.StatusOK},
+		{"[::1]:5000", "", http.StatusOK},
+		{"192.168.1.11:5000", "", http.StatusForbidden},
+		{"203.0.113.5:5000", "10.1.2.3", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			req.Header.Set(echo.HeaderXForwardedFor, tt.forwarded)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != tt.code {
+			t.Errorf("%s (forwarded for %q): got status %d, want %d", tt.remoteAddr, tt.forwarded, rec.Code, tt.code)
+		}
+	}
+
+	for _, entry := range []string{"10.0.0.0/33", "localhost"} {
+		if _, err := IPAllowlist([]string{entry}); err == nil {
+			t.Errorf("%q: got no error, want an invalid entry error", entry)
+		}
+	}
+}
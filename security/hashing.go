@@ -1,6 +1,7 @@
 package security
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 
@@ -17,7 +18,11 @@ const (
 )
 
 // HashPassword hashes password using the given salt
-func HashPassword(password string, salt []byte) string {
+func HashPassword(ctx context.Context, password string, salt []byte) string {
+	// Trace the hashing, as it is expensive by design
+	_, span := internal.StartSpan(ctx, "argon2.hash")
+	defer span.End()
+
 	// Convert the password to bytes
 	bytesPassword := []byte(password)
 
@@ -20,7 +20,7 @@ func ConfigureEchoSecurity(e *echo.Echo, env *internal.Environ) {
 	}))
 
 	// Get the required cookie domain
-	cookieDomain, cErr := internal.GetDomainFromURL(env.FEndpoint)
+	cookieDomain, cErr := internal.GetDomainFromURL(env.FEURL())
 	if cErr != nil {
 		e.Logger.Fatal(cErr)
 	}
@@ -0,0 +1,18 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// SignWebhook signs a webhook body sent at the given Unix time with a HMAC-SHA256 of "<time>.<body>",
+// returning the signature header value as "t=<time>,v1=<hex signature>"
+func SignWebhook(secret string, t int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%d.", t)
+	_, _ = mac.Write(body)
+
+	return fmt.Sprintf("t=%d,v1=%s", t, hex.EncodeToString(mac.Sum(nil)))
+}
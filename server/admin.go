@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"echo_rest_api/internal"
+	"echo_rest_api/security"
+	"echo_rest_api/server/handler"
+)
+
+// Create the admin server, serving the metrics and profiling apart from the public API, with the health probes when
+// they have no listener of their own
+func newAdminServer(env *internal.Environ, h *handler.Handler, logger echo.Logger) *echo.Echo {
+	a := echo.New()
+
+	// Remove Echo startup banner and port, which are not structured
+	a.HideBanner = true
+	a.HidePort = true
+
+	// Configure Echo port
+	a.Server.Addr = env.AdminAddr
+
+	// Share the server logging
+	a.Logger = logger
+
+	// Configure Echo panic recovery
+	a.Use(middleware.Recover())
+
+	// Configure Echo error handler
+	a.HTTPErrorHandler = internal.ErrorHandler
+
+	// Restrict the access
+	security.ConfigureAdminSecurity(a, env)
+
+	// Metrics management
+	a.GET("/metrics", echo.WrapHandler(h.Metrics.Handler()))
+
+	// Health probes, if not served by the probe server
+	if env.ProbeAddr == "" {
+		a.GET("/healthz", h.Healthz)
+		a.GET("/readyz", h.Readyz)
+	}
+
+	// Profiling, only when the access is restricted as the profiles expose the server internals; the command line is
+	// left out as it may hold secrets given as flags
+	if !security.AdminRestricted(env) {
+		logger.Warn("No admin auth or allowed IPs configured, the profiling endpoints are disabled")
+		return a
+	}
+	pp := a.Group("/debug/pprof")
+	pp.GET("/profile", echo.WrapHandler(http.HandlerFunc(pprof.Profile)))
+	pp.GET("/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	pp.POST("/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	pp.GET("/trace", echo.WrapHandler(http.HandlerFunc(pprof.Trace)))
+	pp.GET("/*", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+
+	return a
+}
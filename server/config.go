@@ -11,9 +11,14 @@ import (
 	"echo_rest_api/internal"
 )
 
-func configureEcho(e *echo.Echo, env *internal.Environ) {
-	// Remove Echo startup banner
+const (
+	corsTenantParam = "siteId" // The query parameter holding the tenant of a request
+)
+
+func configureEcho(e *echo.Echo, env *internal.Environ, metrics *internal.Metrics, settings *internal.LiveSettings) {
+	// Remove Echo startup banner and port, which are not structured
 	e.HideBanner = true
+	e.HidePort = true
 
 	// Configure Echo port
 	e.Server.Addr = fmt.Sprintf(":%d", env.ServerPort)
@@ -21,18 +26,23 @@ func configureEcho(e *echo.Echo, env *internal.Environ) {
 	// Configure Echo to remove all trailing slashes
 	e.Pre(middleware.RemoveTrailingSlash())
 
+	// Configure Echo request IDs, reusing the ID given by the requester if any
+	e.Use(middleware.RequestID())
+
+	// Configure Echo request tracing
+	e.Use(internal.TraceRequests)
+
+	// Configure Echo request logging
+	e.Use(internal.RequestLogger)
+
 	// Configure Echo panic recovery
 	e.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{
 		StackSize: 4 << 10, // 4 KB
+		LogLevel:  log.ERROR,
 	}))
 
-	// Configure Echo logging
-	e.Logger.SetLevel(log.INFO)
-	e.Use(middleware.Logger())
-
 	// Configure Echo Prometheus
-	prom := internal.NewMetrics()
-	e.Use(prom.Handle)
+	e.Use(metrics.Handle)
 
 	// Configure Echo validator
 	v, err := internal.CreateValidator()
@@ -44,17 +54,38 @@ func configureEcho(e *echo.Echo, env *internal.Environ) {
 	// Configure Echo error handler
 	e.HTTPErrorHandler = internal.ErrorHandler
 
-	// Set a schema for the FE endpoint
-	var schema string
-	if schema = "http://"; env.Secure {
-		schema = "https://"
-	}
-	env.FEndpoint = schema + env.FEndpoint
-
-	// Configure Echo CORS
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins:     []string{env.FEndpoint},
+	// Configure Echo CORS, allowing the origins of the active settings
+	e.Use(tenantCORS(middleware.CORSConfig{
 		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions},
+		AllowHeaders:     env.CORSAllowHeaders,
+		ExposeHeaders:    env.CORSExposeHeaders,
+		MaxAge:           int(env.CORSMaxAge.Seconds()),
 		AllowCredentials: true,
-	}))
+	}, settings))
+}
+
+// Create the CORS middleware allowing the global origins, along with the ones of the tenant of the request; the
+// tenant is the requested site, taken from the query parameters as the preflight requests carry them, unlike the
+// headers
+func tenantCORS(config middleware.CORSConfig, settings *internal.LiveSettings) echo.MiddlewareFunc {
+	origins := func(tenant string) middleware.CORSConfig {
+		c := config
+		c.AllowOriginFunc = func(origin string) (bool, error) {
+			return settings.Get().Origins.Allowed(origin, tenant), nil
+		}
+		return c
+	}
+	global := middleware.CORSWithConfig(origins(""))
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		globalNext := global(next)
+		return func(c echo.Context) error {
+			tenant := c.QueryParam(corsTenantParam)
+			if tenant == "" {
+				return globalNext(c)
+			}
+
+			return middleware.CORSWithConfig(origins(tenant))(next)(c)
+		}
+	}
 }
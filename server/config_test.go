@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"echo_rest_api/internal"
+)
+
+func TestTenantCORS(t *testing.T) {
+	const site = "65f1c0d2a4b5c6d7e8f90123"
+	origins, err := internal.NewOrigins([]string{"https://app.example.com"}, []string{site + "=https://portal.acme.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(tenantCORS(middleware.CORSConfig{AllowMethods: []string{http.MethodGet}}, internal.NewLiveSettings(internal.Settings{Origins: origins})))
+	e.GET("/stats", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	tests := []struct {
+		name   string
+		origin string
+		target string
+		want   bool
+	}{
+		{"global origin", "https://app.example.com", "/stats", true},
+		{"global origin on a site", "https://app.example.com", "/stats?siteId=" + site, true},
+		{"tenant origin on its site", "https://portal.acme.com", "/stats?siteId=" + site, true},
+		{"tenant origin without a site", "https://portal.acme.com", "/stats", false},
+		{"tenant origin on another site", "https://portal.acme.com", "/stats?siteId=65f1c0d2a4b5c6d7e8f90124", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The preflight request carries the query parameters of the actual one, but none of its headers
+			req := httptest.NewRequest(http.MethodOptions, tt.target, nil)
+			req.Header.Set(echo.HeaderOrigin, tt.origin)
+			req.Header.Set(echo.HeaderAccessControlRequestMethod, http.MethodGet)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin) == tt.origin; got != tt.want {
+				t.Errorf("got allowed=%t (%q), want %t", got, rec.Header().Get(echo.HeaderAccessControlAllowOrigin), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"echo_rest_api/database/model"
+	"echo_rest_api/internal"
+)
+
+// GET
+
+// AlertGetAll gets all alerts, without their webhook secrets
+func (h *Handler) AlertGetAll(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Retrieve all alerts from the DB
+	a, err := h.Alerts.GetAll(c.Request().Context())
+	if err != nil {
+		return
+	}
+
+	for i := range a {
+		a[i].Secret = ""
+	}
+
+	return HTTPSuccess(c, a)
+}
+
+// AlertGet gets an alert from a given ID, without its webhook secret
+func (h *Handler) AlertGet(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	id, err := internal.DecodeParameterID(c, "alertID")
+	if err != nil {
+		return
+	}
+
+	// Retrieve the alert from the DB
+	a, err := h.Alerts.Get(c.Request().Context(), id)
+	if err != nil {
+		return
+	}
+	a.Secret = ""
+
+	return HTTPSuccess(c, a)
+}
+
+// AlertGetHistory gets the latest state changes of all alerts, or of the one given by the alertId query parameter
+func (h *Handler) AlertGetHistory(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Get input query parameters
+	qp := c.QueryParams()
+
+	// Decode the optional alert ID
+	var id *primitive.ObjectID
+	if qp.Get("alertId") != "" {
+		var aid primitive.ObjectID
+		aid, err = internal.DecodeQueryParameterID(qp, "alertId")
+		if err != nil {
+			return
+		}
+		id = &aid
+	}
+
+	// Retrieve the latest alert events from the DB
+	e, err := h.Alerts.GetHistory(c.Request().Context(), id)
+	if err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, e)
+}
+
+// POST
+
+// AlertCreate creates a new alert
+func (h *Handler) AlertCreate(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	a := new(model.Alert)
+	if err = c.Bind(a); err != nil {
+		return
+	}
+
+	// Validate request data
+	if err = c.Validate(a); err != nil {
+		return
+	}
+
+	// New alerts start from a clear state
+	a.State, a.Since = model.AlertStateOK, nil
+
+	// Get the logged-in user ID to set them as the creator
+	a.CreatedBy, err = claims.GetUserID()
+	if err != nil {
+		return
+	}
+
+	// Add the alert to the DB
+	if err = h.Alerts.Create(c.Request().Context(), a); err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, a)
+}
+
+// PUT
+
+// AlertUpdate updates an alert from a given ID
+func (h *Handler) AlertUpdate(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	a := new(model.Alert)
+	if err = c.Bind(a); err != nil {
+		return
+	}
+
+	a.ID, err = internal.DecodeParameterID(c, "alertID")
+	if err != nil {
+		return
+	}
+
+	// Validate request data
+	if err = c.Validate(a); err != nil {
+		return
+	}
+
+	// Update the alert settings
+	if err = h.Alerts.Update(c.Request().Context(), a); err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, nil)
+}
+
+// DELETE
+
+// AlertDelete deletes an alert from a given ID
+func (h *Handler) AlertDelete(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	id, err := internal.DecodeParameterID(c, "alertID")
+	if err != nil {
+		return
+	}
+
+	// Delete the alert and its history in the DB
+	if err = h.Alerts.Delete(c.Request().Context(), id); err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, nil)
+}
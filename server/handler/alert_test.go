@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"echo_rest_api/database/model"
+)
+
+func TestAlertCreate(t *testing.T) {
+	h, mem, e := newTestHandler(t)
+	admin := testClaims(primitive.NewObjectID(), "admin")
+	alert := func(webhooks []string, secret string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":      "Lobby full",
+			"type":      model.AlertTypeOccupancy,
+			"entityId":  primitive.NewObjectID().Hex(),
+			"threshold": 50,
+			"minutes":   10,
+			"webhooks":  webhooks,
+			"secret":    secret,
+		}
+	}
+
+	tests := []struct {
+		name string
+		body map[string]interface{}
+		code int
+	}{
+		{"no webhook", alert(nil, ""), http.StatusOK},
+		{"webhook without secret", alert([]string{"https://example.com/hook"}, ""), http.StatusBadRequest},
+		{"webhook with a short secret", alert([]string{"https://example.com/hook"}, "short"), http.StatusBadRequest},
+		{"signed webhook", alert([]string{"https://example.com/hook"}, "0123456789abcdef"), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, res := serve(t, e, h.AlertCreate, jsonRequest(http.MethodPost, "/alerts", tt.body), admin)
+			if code != tt.code {
+				t.Errorf("got status %d, want %d: %s", code, tt.code, res.Message)
+			}
+		})
+	}
+
+	// The webhook secrets are never returned
+	as, err := mem.Alerts.GetAll(context.Background())
+	if err != nil || len(as) != 2 || as[1].Secret == "" {
+		t.Fatalf("got alerts %+v (%v), want two with the secret stored", as, err)
+	}
+	code, res := serve(t, e, h.AlertGetAll, jsonRequest(http.MethodGet, "/alerts", nil), admin)
+	var got []model.Alert
+	if err = json.Unmarshal(res.Data, &got); err != nil || code != http.StatusOK {
+		t.Fatalf("got status %d (%v), want the alerts", code, err)
+	}
+	for _, a := range got {
+		if a.Secret != "" {
+			t.Errorf("got alert %q with its secret, want none", a.Name)
+		}
+	}
+}
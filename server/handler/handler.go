@@ -2,27 +2,23 @@ package handler
 
 import (
 	"net/http"
-	"time"
 
 	"github.com/ReneKroon/ttlcache"
 	"github.com/labstack/echo/v4"
-	"go.mongodb.org/mongo-driver/mongo"
+
+	"echo_rest_api/database/repository"
+	"echo_rest_api/internal"
 )
 
 type (
 	Handler struct {
 		JwtSecret string
-		JwtExp    time.Duration
-		DB        *mongo.Database
+		Densify   bool
 		Cache     *ttlcache.Cache
-		FEndpoint string
-		SMTP
-	}
-	SMTP struct {
-		Host string
-		Port int
-		User string
-		Pass string
+		Settings  *internal.LiveSettings
+		Metrics   *internal.Metrics
+		Health    *Health
+		*repository.Repositories
 	}
 )
 
@@ -34,3 +30,10 @@ func HTTPSuccess(c echo.Context, d interface{}) error {
 		"data":    d,
 	})
 }
+
+// Publish a domain event to the subscribed webhooks; failures are only logged, as the action itself succeeded
+func (h *Handler) publish(c echo.Context, event string, data interface{}) {
+	if err := h.Webhooks.Publish(c.Request().Context(), event, data); err != nil {
+		internal.RequestLog(c).Error().Err(err).Str("event", event).Msg("Failed to publish the webhook event")
+	}
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"echo_rest_api/database/repository"
+	"echo_rest_api/internal"
+)
+
+type (
+	// The standard body of all responses
+	testResponse struct {
+		Error   bool            `json:"error"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+		TraceID string          `json:"traceId"`
+	}
+)
+
+// Create a handler backed by empty in-memory repositories, with an Echo instance to run it
+func newTestHandler(t *testing.T) (*Handler, *repository.Memory, *echo.Echo) {
+	t.Helper()
+
+	e := echo.New()
+	e.HTTPErrorHandler = internal.ErrorHandler
+	e.Logger.SetOutput(io.Discard)
+
+	v, err := internal.CreateValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Validator = v
+
+	mem := repository.NewMemory()
+	h := &Handler{
+		JwtSecret:    "test-secret",
+		Settings:     internal.NewLiveSettings(internal.Settings{JwtExp: time.Hour}),
+		Repositories: mem.Repositories(),
+	}
+
+	return h, mem, e
+}
+
+// Run a handler on a request, logged in with the given claims if any, and decode its response
+func serve(t *testing.T, e *echo.Echo, fn echo.HandlerFunc, req *http.Request, claims *internal.JWTClaims) (int, *testResponse) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	if claims != nil {
+		c.Set("user", jwt.NewWithClaims(jwt.SigningMethodHS512, claims))
+	}
+
+	if err := fn(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+
+	res := new(testResponse)
+	if err := json.Unmarshal(rec.Body.Bytes(), res); err != nil {
+		t.Fatalf("invalid response body %q: %s", rec.Body.String(), err)
+	}
+
+	return rec.Code, res
+}
+
+// Build a JSON request
+func jsonRequest(method, target string, body interface{}) *http.Request {
+	b, _ := json.Marshal(body)
+
+	req := httptest.NewRequest(method, target, strings.NewReader(string(b)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	return req
+}
+
+// Build the claims of a logged-in user
+func testClaims(id primitive.ObjectID, role string) *internal.JWTClaims {
+	return &internal.JWTClaims{
+		Role:           role,
+		StandardClaims: jwt.StandardClaims{Id: id.Hex()},
+	}
+}
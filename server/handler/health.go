@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	// Health tells whether the server can take requests, based on the checks of its dependencies
+	Health struct {
+		Checks   []HealthCheck
+		draining int32
+	}
+	// HealthCheck checks that a dependency of the server is available
+	HealthCheck struct {
+		Name       string
+		Check      func(ctx context.Context) error
+		ReportOnly bool // The check is reported but does not affect the readiness, for the non-essential dependencies
+	}
+	// HealthCheckResult is the outcome of a health check
+	HealthCheckResult struct {
+		Status    string  `json:"status"`
+		LatencyMs float64 `json:"latencyMs"`
+		Error     string  `json:"error,omitempty"`
+	}
+	// Readiness is the outcome of all the health checks
+	Readiness struct {
+		Status string                       `json:"status"`
+		Checks map[string]HealthCheckResult `json:"checks"`
+	}
+)
+
+const (
+	HealthStatusOK       = "ok"       // The check passed, or the server is ready
+	HealthStatusFailed   = "failed"   // The check failed, or one of them did
+	HealthStatusDraining = "draining" // The server is shutting down
+)
+
+const (
+	healthCheckTimeout = 2 * time.Second
+)
+
+// Drain reports the server as not ready from now on, so the load balancers stop sending requests before it shuts down
+func (hl *Health) Drain() {
+	atomic.StoreInt32(&hl.draining, 1)
+}
+
+// Draining checks if the server is shutting down
+func (hl *Health) Draining() bool {
+	return atomic.LoadInt32(&hl.draining) == 1
+}
+
+// Run all the checks concurrently, each within the health check timeout
+func (hl *Health) run(ctx context.Context) *Readiness {
+	r := &Readiness{Status: HealthStatusOK, Checks: make(map[string]HealthCheckResult, len(hl.Checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, hc := range hl.Checks {
+		wg.Add(1)
+		go func(hc HealthCheck) {
+			defer wg.Done()
+
+			cCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			// Time the check
+			start := time.Now()
+			err := hc.Check(cCtx)
+			res := HealthCheckResult{
+				Status:    HealthStatusOK,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				res.Status, res.Error = HealthStatusFailed, err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			r.Checks[hc.Name] = res
+			if err != nil && !hc.ReportOnly {
+				r.Status = HealthStatusFailed
+			}
+		}(hc)
+	}
+	wg.Wait()
+
+	return r
+}
+
+// Healthz tells that the server is alive, without checking its dependencies, along with its configuration version
+func (h *Handler) Healthz(c echo.Context) error {
+	return HTTPSuccess(c, map[string]interface{}{"status": HealthStatusOK, "configVersion": h.Settings.Version()})
+}
+
+// Readyz tells whether the server can take requests, with the result of every dependency check
+func (h *Handler) Readyz(c echo.Context) error {
+	// Without any checks, the server is always ready
+	if h.Health == nil {
+		return HTTPSuccess(c, &Readiness{Status: HealthStatusOK, Checks: map[string]HealthCheckResult{}})
+	}
+
+	// Report the server as not ready as soon as it starts shutting down, without checking anything
+	if h.Health.Draining() {
+		return notReady(c, &Readiness{Status: HealthStatusDraining, Checks: map[string]HealthCheckResult{}})
+	}
+
+	r := h.Health.run(c.Request().Context())
+	if r.Status != HealthStatusOK {
+		return notReady(c, r)
+	}
+
+	return HTTPSuccess(c, r)
+}
+
+// Send a not ready response, along with the check results
+func notReady(c echo.Context, r *Readiness) error {
+	return c.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+		"error":   true,
+		"message": "The service is not ready to take requests",
+		"data":    r,
+	})
+}
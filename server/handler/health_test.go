@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"echo_rest_api/internal"
+)
+
+func TestReadyz(t *testing.T) {
+	ok := HealthCheck{Name: "mongodb", Check: func(context.Context) error { return nil }}
+	failing := HealthCheck{Name: "smtp", Check: func(context.Context) error { return errors.New("connection refused") }}
+	reported := HealthCheck{Name: "smtp", Check: failing.Check, ReportOnly: true}
+
+	tests := []struct {
+		name     string
+		checks   []HealthCheck
+		draining bool
+		code     int
+		status   string
+		failed   string
+	}{
+		{"all checks pass", []HealthCheck{ok}, false, http.StatusOK, HealthStatusOK, ""},
+		{"a check fails", []HealthCheck{ok, failing}, false, http.StatusServiceUnavailable, HealthStatusFailed, "smtp"},
+		{"a report-only check fails", []HealthCheck{ok, reported}, false, http.StatusOK, HealthStatusOK, "smtp"},
+		{"draining", []HealthCheck{ok}, true, http.StatusServiceUnavailable, HealthStatusDraining, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _, e := newTestHandler(t)
+			h.Health = &Health{Checks: tt.checks}
+			if tt.draining {
+				h.Health.Drain()
+			}
+
+			code, res := serve(t, e, h.Readyz, httptest.NewRequest(http.MethodGet, "/readyz", nil), nil)
+			if code != tt.code {
+				t.Fatalf("got status %d, want %d", code, tt.code)
+			}
+
+			r := new(Readiness)
+			if err := json.Unmarshal(res.Data, r); err != nil {
+				t.Fatal(err)
+			}
+			if r.Status != tt.status {
+				t.Errorf("got readiness %q, want %q", r.Status, tt.status)
+			}
+			if tt.draining {
+				return
+			}
+
+			// Every check is reported, with its outcome
+			for _, hc := range tt.checks {
+				res, found := r.Checks[hc.Name]
+				if !found {
+					t.Fatalf("got checks %v, want a %q check", r.Checks, hc.Name)
+				}
+				if failed := hc.Name == tt.failed; failed != (res.Status == HealthStatusFailed) || failed != (res.Error != "") {
+					t.Errorf("got %q check %+v, want failed=%t", hc.Name, res, failed)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	h, _, e := newTestHandler(t)
+	h.Health = &Health{Checks: []HealthCheck{{Name: "mongodb", Check: func(context.Context) error { return errors.New("down") }}}}
+	h.Health.Drain()
+	h.Settings.Set(internal.Settings{JwtExp: 2 * time.Hour})
+
+	// The server stays alive whatever its dependencies and while draining
+	code, res := serve(t, e, h.Healthz, httptest.NewRequest(http.MethodGet, "/healthz", nil), nil)
+	if code != http.StatusOK {
+		t.Errorf("got status %d, want %d", code, http.StatusOK)
+	}
+
+	// The version of the reloaded settings is reported
+	var data struct {
+		ConfigVersion uint64 `json:"configVersion"`
+	}
+	if err := json.Unmarshal(res.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.ConfigVersion != 2 {
+		t.Errorf("got config version %d, want 2", data.ConfigVersion)
+	}
+	if got := h.Settings.Get().JwtExp; got != 2*time.Hour {
+		t.Errorf("got JWT expiry %s, want 2h", got)
+	}
+}
@@ -38,6 +38,7 @@ func (h *Handler) CacheTest(c echo.Context) error {
 
 	// Check the cache for the key
 	value, exists := h.Cache.Get(k)
+	h.Metrics.CacheLookup(exists)
 
 	if !exists {
 		if v == "" {
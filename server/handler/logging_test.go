@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"echo_rest_api/internal"
+)
+
+func TestRequestLogging(t *testing.T) {
+	h, _, e := newTestHandler(t)
+
+	// Log the requests as JSON lines into a buffer
+	logger, err := internal.NewLogger("info", internal.LogFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	logger.SetOutput(buf)
+	e.Logger = logger
+
+	e.Use(middleware.RequestID(), internal.RequestLogger)
+	e.POST("/login", h.Login)
+
+	tests := []struct {
+		name      string
+		requestID string
+	}{
+		{"given request ID", "test-request-id"},
+		{"generated request ID", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+
+			// Log in with an unknown email, to get a backend error
+			req := jsonRequest(http.MethodPost, "/login", map[string]string{"email": "nobody@example.com", "password": testPassword})
+			if tt.requestID != "" {
+				req.Header.Set(echo.HeaderXRequestID, tt.requestID)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("got status %d, want %d", rec.Code, http.StatusNotFound)
+			}
+
+			// The request ID is sent back, as given or generated
+			id := rec.Header().Get(echo.HeaderXRequestID)
+			if id == "" || (tt.requestID != "" && id != tt.requestID) {
+				t.Fatalf("got request ID %q, want %q", id, tt.requestID)
+			}
+
+			// Both the backend error and the request are logged with the request fields
+			var lines []map[string]interface{}
+			dec := json.NewDecoder(buf)
+			for dec.More() {
+				var l map[string]interface{}
+				if err := dec.Decode(&l); err != nil {
+					t.Fatalf("invalid log line: %s", err)
+				}
+				lines = append(lines, l)
+			}
+			if len(lines) != 2 {
+				t.Fatalf("got %d log lines, want 2", len(lines))
+			}
+
+			for _, l := range lines {
+				if l["request_id"] != id || l["route"] != "/login" || l["status"] != float64(http.StatusNotFound) {
+					t.Errorf("got log line %v, want request ID %q, route /login and status 404", l, id)
+				}
+				if _, ok := l["latency_ms"]; !ok {
+					t.Errorf("got log line %v, want a latency", l)
+				}
+			}
+			if l := lines[0]; l["message"] != internal.ErrDBNoData || l["file"] == nil || l["line"] == nil {
+				t.Errorf("got error log line %v, want the backend error with its location", l)
+			}
+		})
+	}
+}
+
+func TestRequestTracing(t *testing.T) {
+	h, mem, e := newTestHandler(t)
+	addTestUser(t, mem, "admin@example.com", "admin", true)
+
+	// Record the spans
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+
+	// Log the requests as JSON lines into a buffer
+	logger, err := internal.NewLogger("info", internal.LogFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	logger.SetOutput(buf)
+	e.Logger = logger
+
+	e.Use(middleware.RequestID(), internal.TraceRequests, internal.RequestLogger)
+	e.POST("/login", h.Login)
+
+	// Log in with a wrong password, continuing the trace of the requester
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := jsonRequest(http.MethodPost, "/login", map[string]string{"email": "admin@example.com", "password": "wr0ng!pass"})
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	res := httptest.NewRecorder()
+	e.ServeHTTP(res, req)
+
+	// The trace ID is sent back in the error response and logged on every line
+	body := new(testResponse)
+	if err := json.Unmarshal(res.Body.Bytes(), body); err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != http.StatusUnauthorized || body.TraceID != traceID {
+		t.Fatalf("got status %d and trace ID %q, want %d and %q", res.Code, body.TraceID, http.StatusUnauthorized, traceID)
+	}
+
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var l map[string]interface{}
+		if err := dec.Decode(&l); err != nil {
+			t.Fatalf("invalid log line: %s", err)
+		}
+		if l["trace_id"] != traceID {
+			t.Errorf("got log line %v, want trace ID %q", l, traceID)
+		}
+	}
+
+	// The request and the password hashing are traced in the trace of the requester
+	spans := map[string]bool{}
+	for _, s := range rec.Ended() {
+		if s.SpanContext().TraceID().String() != traceID {
+			t.Errorf("got span %q in trace %s, want %s", s.Name(), s.SpanContext().TraceID(), traceID)
+		}
+		spans[s.Name()] = true
+	}
+	for _, name := range []string{"POST /login", "argon2.hash"} {
+		if !spans[name] {
+			t.Errorf("got spans %v, want a %q span", spans, name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"echo_rest_api/database/model"
+	"echo_rest_api/database/repository"
+	"echo_rest_api/internal"
+)
+
+func TestMetrics(t *testing.T) {
+	// Run two servers side by side, each with its own metrics
+	for i := 0; i < 2; i++ {
+		h, mem, e := newTestHandler(t)
+		addTestUser(t, mem, "admin@example.com", "admin", true)
+
+		h.Metrics = internal.NewMetrics()
+		e.Use(h.Metrics.Handle)
+		e.POST("/login", h.Login)
+		e.GET("/metrics", echo.WrapHandler(h.Metrics.Handler()))
+
+		// Log in once successfully, twice with a wrong password, once with an unknown email and once without any
+		// password, which is not a login attempt
+		for _, login := range [][2]string{
+			{"admin@example.com", testPassword},
+			{"admin@example.com", "wr0ng!pass"},
+			{"admin@example.com", "wr0ng!pass"},
+			{"nobody@example.com", "wr0ng!pass"},
+			{"admin@example.com", ""},
+		} {
+			req := jsonRequest(http.MethodPost, "/login", map[string]string{"email": login[0], "password": login[1]})
+			e.ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		// Scrape the metrics
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		body := rec.Body.String()
+
+		for _, want := range []string{
+			`echo_rest_api_logins_total{result="success"} 1`,
+			`echo_rest_api_logins_total{result="failure"} 3`,
+			`echo_rest_api_http_request_duration_seconds_count{method="POST",route="/login",status="200"} 1`,
+			`echo_rest_api_http_request_duration_seconds_count{method="POST",route="/login",status="401"} 2`,
+			`echo_rest_api_http_request_duration_seconds_count{method="POST",route="/login",status="404"} 1`,
+			`echo_rest_api_http_response_size_bytes_count{method="POST",route="/login",status="401"} 2`,
+			`echo_rest_api_http_requests_in_flight 1`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("server %d: got metrics without %q", i, want)
+			}
+		}
+	}
+}
+
+// A user repository whose DB is unreachable
+type failingUsers struct {
+	repository.UserRepository
+}
+
+func (r *failingUsers) Find(ctx context.Context, u *model.User) error {
+	return internal.NewError(internal.ErrDBQuery, context.DeadlineExceeded, 1)
+}
+
+func TestLoginMetricsDBFailure(t *testing.T) {
+	h, _, e := newTestHandler(t)
+	h.Users = &failingUsers{}
+	h.Metrics = internal.NewMetrics()
+	e.POST("/login", h.Login)
+	e.GET("/metrics", echo.WrapHandler(h.Metrics.Handler()))
+
+	req := jsonRequest(http.MethodPost, "/login", map[string]string{"email": "admin@example.com", "password": testPassword})
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	// A DB failure is not a failed login attempt
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if body := rec.Body.String(); strings.Contains(body, `echo_rest_api_logins_total{result="failure"}`) {
+		t.Errorf("got a failed login counted for a DB failure")
+	}
+}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"echo_rest_api/database/model"
+	"echo_rest_api/internal"
+	"echo_rest_api/render"
+)
+
+// GET
+
+// ReportGetAll gets all scheduled reports
+func (h *Handler) ReportGetAll(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Retrieve all reports from the DB
+	r, err := h.Reports.GetAll(c.Request().Context())
+	if err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, r)
+}
+
+// ReportGet gets a scheduled report from a given ID
+func (h *Handler) ReportGet(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	id, err := internal.DecodeParameterID(c, "reportID")
+	if err != nil {
+		return
+	}
+
+	// Retrieve the report from the DB
+	r, err := h.Reports.Get(c.Request().Context(), id)
+	if err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, r)
+}
+
+// ReportGetDeliveries gets the delivery history of a scheduled report from a given ID
+func (h *Handler) ReportGetDeliveries(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	id, err := internal.DecodeParameterID(c, "reportID")
+	if err != nil {
+		return
+	}
+
+	// Retrieve the latest deliveries of the report from the DB
+	d, err := h.Reports.GetDeliveries(c.Request().Context(), id)
+	if err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, d)
+}
+
+// POST
+
+// ReportCreate creates a new scheduled report
+func (h *Handler) ReportCreate(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	r := new(model.Report)
+	if err = c.Bind(r); err != nil {
+		return
+	}
+
+	// Validate request data
+	if err = c.Validate(r); err != nil {
+		return
+	}
+	if err = prepareReport(r); err != nil {
+		return
+	}
+
+	// Get the logged-in user ID to set them as the creator
+	r.CreatedBy, err = claims.GetUserID()
+	if err != nil {
+		return
+	}
+
+	// Add the report to the DB
+	if err = h.Reports.Create(c.Request().Context(), r); err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, r)
+}
+
+// PUT
+
+// ReportUpdate updates a scheduled report from a given ID
+func (h *Handler) ReportUpdate(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	r := new(model.Report)
+	if err = c.Bind(r); err != nil {
+		return
+	}
+
+	r.ID, err = internal.DecodeParameterID(c, "reportID")
+	if err != nil {
+		return
+	}
+
+	// Validate request data
+	if err = c.Validate(r); err != nil {
+		return
+	}
+	if err = prepareReport(r); err != nil {
+		return
+	}
+
+	// Update the report settings
+	if err = h.Reports.Update(c.Request().Context(), r); err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, r)
+}
+
+// DELETE
+
+// ReportDelete deletes a scheduled report from a given ID
+func (h *Handler) ReportDelete(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	id, err := internal.DecodeParameterID(c, "reportID")
+	if err != nil {
+		return
+	}
+
+	// Delete the report and its delivery history in the DB
+	if err = h.Reports.Delete(c.Request().Context(), id); err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, nil)
+}
+
+// Check the stats settings of a report, set their defaults and schedule its next run
+func prepareReport(r *model.Report) error {
+	// Check the requested metrics (entered/exited for gates and max count for spaces by default)
+	ms, am := []string{model.StatsMetricEntered, model.StatsMetricExited}, model.GateMetrics
+	if r.Location == space {
+		ms, am = []string{model.StatsMetricMax}, model.SpaceMetrics
+	}
+	if len(r.Metrics) == 0 {
+		r.Metrics = ms
+	}
+	for _, m := range r.Metrics {
+		if !internal.InSlice(m, am) {
+			return internal.NewError(internal.ErrBEQPInvalidMetrics, nil, 2)
+		}
+	}
+
+	// The capacity threshold is required by the time above capacity
+	if internal.InSlice(model.StatsMetricAboveCapacity, r.Metrics) && r.Capacity == 0 {
+		return internal.NewError(internal.ErrBEQPInvalidCapacity, nil, 2)
+	}
+
+	// Attach CSV files by default
+	if r.Format == "" {
+		r.Format = render.ExportCSV
+	}
+
+	// Schedule the next run (both the schedule and timezone are already validated)
+	cr, _ := internal.ParseCron(r.Schedule)
+	tz, _ := internal.LoadTimezone(r.Timezone)
+	r.NextRun = cr.Next(time.Now().In(tz))
+
+	return nil
+}
@@ -1,20 +1,39 @@
 package handler
 
 import (
+	"context"
 	"fmt"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"echo_rest_api/database/model"
 	"echo_rest_api/internal"
+	"echo_rest_api/render"
 )
 
 const (
-	gate = "gate"
-	none = "none"
-	hour = "hour"
+	gate  = model.StatsLocationGate
+	space = model.StatsLocationSpace
+)
+
+var (
+	statsMediaTypes = map[string]string{
+		"application/vnd.stats+json":    render.FormatPlain,
+		"application/vnd.canvasjs+json": render.FormatCanvasJS,
+		"application/vnd.chartjs+json":  render.FormatChartJS,
+		"application/vnd.vegalite+json": render.FormatVegaLite,
+	}
+
+	exportMediaTypes = map[string]string{
+		render.ExportContentTypes[render.ExportCSV]:     render.ExportCSV,
+		render.ExportContentTypes[render.ExportXLSX]:    render.ExportXLSX,
+		render.ExportContentTypes[render.ExportParquet]: render.ExportParquet,
+	}
 )
 
 // GET
@@ -24,48 +43,166 @@ func (h *Handler) StatsGetData(c echo.Context) (err error) {
 	// Get input query parameters
 	qp := c.QueryParams()
 
-	// Decode the ID into a MongoDB format
-	id, err := internal.DecodeQueryParameterID(qp, "id")
+	// Parse the stats query
+	sq, err := h.parseStatsQuery(c.Request().Context(), qp)
+	if err != nil {
+		return
+	}
+
+	// Parse the optional comparison period
+	cq, err := parseStatsComparison(qp, sq)
+	if err != nil {
+		return
+	}
+
+	// Parse the chart rendering options
+	o, err := parseStatsRender(c)
+	if err != nil {
+		return
+	}
+
+	// Retrieve the statistics
+	if err = h.Stats.Get(c.Request().Context(), sq); err != nil {
+		return
+	}
+
+	// Retrieve the statistics of the comparison period and compare them with the requested ones
+	if cq != nil {
+		if err = h.Stats.Get(c.Request().Context(), cq); err != nil {
+			return
+		}
+
+		sq.Compare(cq)
+	}
+
+	return HTTPSuccess(c, render.Stats(sq, o))
+}
+
+// StatsExport streams the stats data for a given location as a CSV, XLSX or Parquet file
+func (h *Handler) StatsExport(c echo.Context) (err error) {
+	// Parse the stats query, the same as for the stats data
+	sq, err := h.parseStatsQuery(c.Request().Context(), c.QueryParams())
 	if err != nil {
 		return
 	}
 
-	// Parse interval dates
+	// Parse the export format
+	f, err := parseStatsExport(c)
+	if err != nil {
+		return
+	}
+
+	// Set the file headers; they are only sent along with the first rows, so query errors are still reported as usual
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, render.ExportContentTypes[f])
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"stats.%s\"", f))
+	defer func() {
+		if err != nil && !res.Committed {
+			res.Header().Del(echo.HeaderContentDisposition)
+		}
+	}()
+
+	x, err := render.NewExporter(f, res, sq, model.StatsColumns(sq))
+	if err != nil {
+		return internal.NewError(internal.ErrBEExport, err, 1)
+	}
+
+	// Stream the rows straight from the DB cursor into the file
+	if err = h.Stats.Stream(c.Request().Context(), sq, func(r *model.StatsRow) error {
+		if err := x.Write(r); err != nil {
+			return internal.NewError(internal.ErrBEExport, err, 1)
+		}
+
+		return nil
+	}); err != nil {
+		return
+	}
+
+	if err = x.Close(); err != nil {
+		return internal.NewError(internal.ErrBEExport, err, 1)
+	}
+
+	return nil
+}
+
+// Parse the query parameters of a stats request
+func (h *Handler) parseStatsQuery(ctx context.Context, qp url.Values) (sq *model.Stats, err error) {
+	// Parse interval dates (RFC3339 timestamps carry their own offset, so they are absolute instants)
 	s, err := time.Parse(time.RFC3339, qp.Get("start"))
 	if err != nil {
-		return internal.NewError(internal.ErrBEQPInvalidDateTime, nil, 1)
+		return nil, internal.NewError(internal.ErrBEQPInvalidDateTime, nil, 1)
 	}
 	e, err := time.Parse(time.RFC3339, qp.Get("end"))
 	if err != nil {
-		return internal.NewError(internal.ErrBEQPInvalidDateTime, nil, 1)
+		return nil, internal.NewError(internal.ErrBEQPInvalidDateTime, nil, 1)
 	}
 
 	// Parse timezone
-	tRaw := qp.Get("timezone")
-	t, err := strconv.Atoi(tRaw)
-	if err != nil || t < -12 || t > 12 {
-		if err == nil {
-			err = fmt.Errorf("invalid timezone offset value: %d", t)
-		}
-		return internal.NewError(internal.ErrBEQPInvalidTimezone, err, 1)
+	t, err := internal.LoadTimezone(qp.Get("timezone"))
+	if err != nil {
+		return nil, internal.NewError(internal.ErrBEQPInvalidTimezone, err, 1)
 	}
 
 	// Parse location
 	l := qp.Get("location")
-	if l == "" || !internal.InSlice(l, []string{gate, "space"}) {
-		return internal.NewError(internal.ErrBEQPInvalidLocation, nil, 1)
+	if l == "" || !internal.InSlice(l, []string{gate, space}) {
+		return nil, internal.NewError(internal.ErrBEQPInvalidLocation, nil, 1)
 	}
 
-	// Parse chart type
-	ct := qp.Get("chartType")
-	if ct == "" || !internal.InSlice(ct, []string{"area", "line", "spline", "column", "stackedColumn", "bar"}) {
-		return internal.NewError(internal.ErrBEQPInvalidChartType, nil, 1)
+	// Decode the IDs into a MongoDB format; a site ID selects all of its gates or spaces
+	var ids []primitive.ObjectID
+	var site *primitive.ObjectID
+	if qp.Get("siteId") != "" {
+		var sid primitive.ObjectID
+		sid, err = internal.DecodeQueryParameterID(qp, "siteId")
+		if err != nil {
+			return
+		}
+
+		var st *model.Site
+		st, err = h.Sites.Get(ctx, sid)
+		if err != nil {
+			return
+		}
+
+		if ids = st.Gates; l == space {
+			ids = st.Spaces
+		}
+		if len(ids) == 0 {
+			return nil, internal.NewError(internal.ErrDBNoData, nil, 1)
+		}
+		site = &sid
+	} else {
+		ids, err = internal.DecodeQueryParameterIDs(qp, "id")
+		if err != nil {
+			return
+		}
 	}
 
 	// Parse interval type
 	it := qp.Get("intervalType")
-	if it == "" || !internal.InSlice(it, []string{none, hour, "day", "month", "year"}) {
-		return internal.NewError(internal.ErrBEQPInvalidIntervalType, nil, 1)
+	if it == "" || !internal.InSlice(it, model.StatsIntervals) {
+		return nil, internal.NewError(internal.ErrBEQPInvalidIntervalType, nil, 1)
+	}
+
+	// Parse interval size (the number of minutes in a bucket, which must evenly divide an hour)
+	var is int
+	if it == model.StatsIntervalMinute {
+		is = 1
+		if isRaw := qp.Get("intervalSize"); isRaw != "" {
+			is, err = strconv.Atoi(isRaw)
+			if err != nil || is < 1 || is > 60 || 60%is != 0 {
+				return nil, internal.NewError(internal.ErrBEQPInvalidIntervalSize, err, 1)
+			}
+		}
+	}
+
+	// Parse gap filling (missing buckets are zero-filled by default)
+	f := qp.Get("fill")
+	if f == "" {
+		f = model.StatsFillZero
+	} else if !internal.InSlice(f, []string{model.StatsFillZero, model.StatsFillNull, model.StatsFillNone}) {
+		return nil, internal.NewError(internal.ErrBEQPInvalidFill, nil, 1)
 	}
 
 	// Parse isInside
@@ -73,66 +210,165 @@ func (h *Handler) StatsGetData(c echo.Context) (err error) {
 	inRaw := qp.Get("isInside")
 	if l == gate {
 		if inRaw == "" {
-			return internal.NewError(internal.ErrBEQPInvalidIsInside, nil, 1)
+			return nil, internal.NewError(internal.ErrBEQPInvalidIsInside, nil, 1)
 		}
 
 		in, err = strconv.ParseBool(inRaw)
 		if err != nil {
-			return internal.NewError(internal.ErrBEQPInvalidIsInside, nil, 1)
+			return nil, internal.NewError(internal.ErrBEQPInvalidIsInside, nil, 1)
+		}
+	}
+
+	// Parse the requested metrics (entered/exited for gates and max count for spaces by default)
+	ms, am := []string{model.StatsMetricEntered, model.StatsMetricExited}, model.GateMetrics
+	if l == space {
+		ms, am = []string{model.StatsMetricMax}, model.SpaceMetrics
+	}
+	if msRaw := qp.Get("metrics"); msRaw != "" {
+		ms = strings.Split(msRaw, ",")
+		for _, m := range ms {
+			if !internal.InSlice(m, am) {
+				return nil, internal.NewError(internal.ErrBEQPInvalidMetrics, nil, 1)
+			}
+		}
+	}
+
+	// Parse the capacity threshold, required by the time above capacity
+	var cp float64
+	if internal.InSlice(model.StatsMetricAboveCapacity, ms) {
+		cp, err = strconv.ParseFloat(qp.Get("capacity"), 64)
+		if err != nil || cp < 0 {
+			return nil, internal.NewError(internal.ErrBEQPInvalidCapacity, err, 1)
 		}
 	}
 
 	// Check if trying to get raw data from a gate
-	if it == none && l == gate {
-		return internal.NewError(internal.ErrBEQPNoRawOnGate, nil, 1)
-	}
-
-	// Build format for X axis
-	var vf string
-	switch it {
-	case none:
-		it = hour
-		fallthrough
-	case "hour":
-		vf = "DD-MM-YYYY HH:mm:ss"
-	case "day":
-		vf = "DD-MM-YYYY"
-	case "month":
-		vf = "MM-YYY"
-	case "year":
-		vf = "YYYY"
-	}
-
-	// Correct start/end dates for timezone offset
-	s = s.Add(time.Hour * time.Duration(-t))
-	e = e.Add(time.Hour * time.Duration(-t))
+	if it == model.StatsIntervalNone && l == gate {
+		return nil, internal.NewError(internal.ErrBEQPNoRawOnGate, nil, 1)
+	}
+
+	// Parse perEntity (one merged series by default); raw samples of several spaces cannot be merged
+	pe := false
+	if peRaw := qp.Get("perEntity"); peRaw != "" {
+		pe, err = strconv.ParseBool(peRaw)
+		if err != nil {
+			return nil, internal.NewError(internal.ErrBEQPInvalidPerEntity, nil, 1)
+		}
+	}
+	if it == model.StatsIntervalNone && len(ids) > 1 {
+		pe = true
+	}
 
 	// Init the stats model for the data
-	sq := &model.Stats{
-		ID:        id,
+	sq = &model.Stats{
+		IDs:       ids,
+		SiteID:    site,
+		PerEntity: pe,
 		Location:  l,
-		ChartType: ct,
 		IsInside:  in,
 		Start:     s,
 		End:       e,
-		Timezone:  t,
-		AxisX: model.StatsAxisX{
-			ValueFormat:  vf,
-			IntervalType: it,
+		Timezone:  t.String(),
+		TZ:        t,
+		Fill:      f,
+		Metrics:   ms,
+		Capacity:  cp,
+		Densify:   h.Densify,
+		Interval: model.StatsInterval{
+			Type: it,
+			Size: is,
 		},
-		Data: []model.StatsData{},
+		Series: []model.StatsSeries{},
 	}
 
-	// Retrieve the statistics
-	if sq.Location == gate {
-		if err = model.StatsGetGate(h.DB, sq); err != nil {
-			return
+	return sq, nil
+}
+
+// Parse the chart rendering options of a stats request, from the format query parameter or the Accept header
+func parseStatsRender(c echo.Context) (o render.Options, err error) {
+	qp := c.QueryParams()
+
+	// Negotiate the format, keeping the CanvasJS one by default
+	o.Format = qp.Get("format")
+	if o.Format == "" {
+		o.Format = render.FormatCanvasJS
+
+		for _, a := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+			if f, ok := statsMediaTypes[strings.TrimSpace(strings.Split(a, ";")[0])]; ok {
+				o.Format = f
+				break
+			}
 		}
-	} else if sq.Location == "space" {
-		if err = model.StatsGetSpace(h.DB, sq); err != nil {
-			return
+	} else if !internal.InSlice(o.Format, render.Formats) {
+		return o, internal.NewError(internal.ErrBEQPInvalidFormat, nil, 1)
+	}
+
+	// Parse chart type; required by CanvasJS, which has no default one
+	o.ChartType = qp.Get("chartType")
+	if o.ChartType != "" && !internal.InSlice(o.ChartType, render.ChartTypes) ||
+		o.ChartType == "" && o.Format == render.FormatCanvasJS {
+		return o, internal.NewError(internal.ErrBEQPInvalidChartType, nil, 1)
+	}
+
+	return o, nil
+}
+
+// Parse the export format of a stats request, from the format query parameter or the Accept header
+func parseStatsExport(c echo.Context) (string, error) {
+	f := c.QueryParam("format")
+	if f != "" {
+		if !internal.InSlice(f, render.ExportFormats) {
+			return "", internal.NewError(internal.ErrBEQPInvalidFormat, nil, 1)
 		}
+
+		return f, nil
 	}
 
-	return HTTPSuccess(c, sq)
+	// Negotiate the format, keeping CSV by default
+	for _, a := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+		if f, ok := exportMediaTypes[strings.TrimSpace(strings.Split(a, ";")[0])]; ok {
+			return f, nil
+		}
+	}
+
+	return render.ExportCSV, nil
+}
+
+// Parse the optional comparison period of a stats request into a stats query for that period
+func parseStatsComparison(qp url.Values, sq *model.Stats) (*model.Stats, error) {
+	ct := qp.Get("compareTo")
+	if ct == "" {
+		return nil, nil
+	}
+
+	// Copy the requested query and move it to the comparison period
+	cq := *sq
+	cq.Series = []model.StatsSeries{}
+
+	switch ct {
+	case model.StatsComparePrevious:
+		// The period of the same length right before the requested one (the requested end is inclusive, to the second)
+		d := sq.End.Sub(sq.Start) + time.Second
+		cq.Start, cq.End = sq.Start.Add(-d), sq.End.Add(-d)
+	case model.StatsCompareLastYear:
+		s, e := sq.Start.In(sq.TZ), sq.End.In(sq.TZ)
+		cq.Start, cq.End = s.AddDate(-1, 0, 0), e.AddDate(-1, 0, 0)
+	case model.StatsCompareCustom:
+		var err error
+		cq.Start, err = time.Parse(time.RFC3339, qp.Get("compareStart"))
+		if err != nil {
+			return nil, internal.NewError(internal.ErrBEQPInvalidDateTime, nil, 1)
+		}
+		cq.End, err = time.Parse(time.RFC3339, qp.Get("compareEnd"))
+		if err != nil || cq.End.Before(cq.Start) {
+			return nil, internal.NewError(internal.ErrBEQPInvalidDateTime, nil, 1)
+		}
+	default:
+		return nil, internal.NewError(internal.ErrBEQPInvalidCompareTo, nil, 1)
+	}
+
+	// Keep the comparison kind for labelling the comparison series
+	sq.CompareTo = ct
+
+	return &cq, nil
 }
@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"echo_rest_api/database/model"
+	"echo_rest_api/database/repository"
+	"echo_rest_api/internal"
+)
+
+// The stats handler tests cover the query parsing, validation and responses; the stats themselves are computed by the
+// in-memory repository, while the MongoDB pipelines are tested on their built stages in the model package
+
+// Get the values of a stats series, with nil values as -1
+func seriesValues(s model.StatsSeries) []float64 {
+	v := make([]float64, len(s.Points))
+	for i, p := range s.Points {
+		v[i] = -1
+		if p.Value != nil {
+			v[i] = *p.Value
+		}
+	}
+
+	return v
+}
+
+// Request the plain stats series with the given query parameters
+func getStats(t *testing.T, e *echo.Echo, h *Handler, qp url.Values) (int, *testResponse, *model.Stats) {
+	t.Helper()
+
+	qp.Set("format", "plain")
+	req := httptest.NewRequest(http.MethodGet, "/stats?"+qp.Encode(), nil)
+	code, res := serve(t, e, h.StatsGetData, req, nil)
+
+	sq := new(model.Stats)
+	if code == http.StatusOK {
+		if err := json.Unmarshal(res.Data, sq); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return code, res, sq
+}
+
+func TestStatsGate(t *testing.T) {
+	h, mem, e := newTestHandler(t)
+
+	g1, g2 := primitive.NewObjectID(), primitive.NewObjectID()
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+	mem.Stats.AddEvents(
+		model.Event{GateID: g1, Timestamp: at(5), Crossed: 1},
+		model.Event{GateID: g1, Timestamp: at(5), Crossed: 1},
+		model.Event{GateID: g1, Timestamp: at(30), Crossed: -1},
+		model.Event{GateID: g2, Timestamp: at(40), Crossed: 1},
+		model.Event{GateID: g1, Timestamp: at(130), Crossed: -1},
+		// Outside the requested period
+		model.Event{GateID: g1, Timestamp: at(-10), Crossed: 1},
+	)
+
+	site := &model.Site{Name: "Main", Gates: []primitive.ObjectID{g1, g2}}
+	mem.Sites.Add(site)
+
+	query := func(kv ...string) url.Values {
+		qp := url.Values{
+			"start":        {start.Format(time.RFC3339)},
+			"end":          {at(179).Format(time.RFC3339)},
+			"location":     {"gate"},
+			"isInside":     {"false"},
+			"timezone":     {"UTC"},
+			"intervalType": {"hour"},
+		}
+		for i := 0; i < len(kv); i += 2 {
+			qp.Set(kv[i], kv[i+1])
+		}
+
+		return qp
+	}
+
+	tests := []struct {
+		name   string
+		qp     url.Values
+		series map[string][]float64
+	}{
+		{
+			"merged gates",
+			query("id", g1.Hex()+","+g2.Hex(), "metrics", "entered,exited,occupancy"),
+			map[string][]float64{
+				model.StatsMetricEntered:   {3, 0, 0},
+				model.StatsMetricExited:    {1, 0, 1},
+				model.StatsMetricOccupancy: {2, 2, 1},
+			},
+		},
+		{
+			"inside direction",
+			query("id", g1.Hex(), "isInside", "true"),
+			map[string][]float64{
+				model.StatsMetricEntered: {1, 0, 1},
+				model.StatsMetricExited:  {2, 0, 0},
+			},
+		},
+		{
+			"site without gaps",
+			query("siteId", site.ID.Hex(), "metrics", "peakEntered", "fill", "none"),
+			map[string][]float64{
+				model.StatsMetricPeakEntered: {2, 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, res, sq := getStats(t, e, h, tt.qp)
+			if code != http.StatusOK {
+				t.Fatalf("got status %d, want %d: %s", code, http.StatusOK, res.Message)
+			}
+			if len(sq.Series) != len(tt.series) {
+				t.Fatalf("got %d series, want %d", len(sq.Series), len(tt.series))
+			}
+
+			for _, s := range sq.Series {
+				want := tt.series[s.Metric]
+				got := seriesValues(s)
+				if len(got) != len(want) {
+					t.Fatalf("got %s values %v, want %v", s.Metric, got, want)
+				}
+				for i := range want {
+					if got[i] != want[i] {
+						t.Errorf("got %s values %v, want %v", s.Metric, got, want)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStatsSpace(t *testing.T) {
+	h, mem, e := newTestHandler(t)
+
+	s1, s2 := primitive.NewObjectID(), primitive.NewObjectID()
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+	mem.Stats.AddSpaceResults(
+		model.SpaceResult{SpaceID: s1, Timestamp: at(0), Count: 4},
+		model.SpaceResult{SpaceID: s1, Timestamp: at(10), Count: 8},
+		model.SpaceResult{SpaceID: s1, Timestamp: at(20), Count: -2},
+		model.SpaceResult{SpaceID: s2, Timestamp: at(0), Count: 10},
+	)
+
+	qp := url.Values{
+		"start":        {start.Format(time.RFC3339)},
+		"end":          {at(59).Format(time.RFC3339)},
+		"location":     {"space"},
+		"timezone":     {"UTC"},
+		"intervalType": {"hour"},
+		"id":           {s1.Hex() + "," + s2.Hex()},
+		"metrics":      {"max,min,avg"},
+		"perEntity":    {"true"},
+	}
+
+	code, res, sq := getStats(t, e, h, qp)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", code, http.StatusOK, res.Message)
+	}
+
+	want := map[string]map[string]float64{
+		s1.Hex(): {model.StatsMetricMax: 8, model.StatsMetricMin: 0, model.StatsMetricAvg: 4},
+		s2.Hex(): {model.StatsMetricMax: 10, model.StatsMetricMin: 10, model.StatsMetricAvg: 10},
+	}
+	if len(sq.Series) != 6 {
+		t.Fatalf("got %d series, want 6", len(sq.Series))
+	}
+	for _, s := range sq.Series {
+		got := seriesValues(s)
+		if len(got) != 1 || got[0] != want[s.Entity][s.Metric] {
+			t.Errorf("got %s values %v for %s, want [%v]", s.Metric, got, s.Entity, want[s.Entity][s.Metric])
+		}
+	}
+}
+
+func TestStatsInvalidQuery(t *testing.T) {
+	h, _, e := newTestHandler(t)
+
+	id := primitive.NewObjectID().Hex()
+	base := url.Values{
+		"start":        {"2024-03-01T00:00:00Z"},
+		"end":          {"2024-03-02T00:00:00Z"},
+		"location":     {"gate"},
+		"isInside":     {"false"},
+		"timezone":     {"UTC"},
+		"intervalType": {"hour"},
+		"id":           {id},
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		code    int
+		message string
+	}{
+		{"invalid start", "start", "yesterday", http.StatusBadRequest, internal.ErrBEQPInvalidDateTime},
+		{"invalid location", "location", "room", http.StatusBadRequest, internal.ErrBEQPInvalidLocation},
+		{"invalid interval", "intervalType", "decade", http.StatusBadRequest, internal.ErrBEQPInvalidIntervalType},
+		{"invalid metric", "metrics", "max", http.StatusBadRequest, internal.ErrBEQPInvalidMetrics},
+		{"raw gate data", "intervalType", "none", http.StatusBadRequest, internal.ErrBEQPNoRawOnGate},
+		{"unknown site", "siteId", primitive.NewObjectID().Hex(), http.StatusNotFound, internal.ErrDBNoData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qp := url.Values{}
+			for k, v := range base {
+				qp[k] = v
+			}
+			qp.Set(tt.key, tt.value)
+
+			code, res, _ := getStats(t, e, h, qp)
+			if code != tt.code || res.Message != tt.message {
+				t.Errorf("got status %d (%s), want %d (%s)", code, res.Message, tt.code, tt.message)
+			}
+		})
+	}
+}
+
+func TestStatsInvalidComparison(t *testing.T) {
+	h, _, e := newTestHandler(t)
+
+	qp := url.Values{
+		"start":        {"2024-03-01T00:00:00Z"},
+		"end":          {"2024-03-02T00:00:00Z"},
+		"location":     {"gate"},
+		"isInside":     {"false"},
+		"timezone":     {"UTC"},
+		"intervalType": {"hour"},
+		"id":           {primitive.NewObjectID().Hex()},
+		"compareTo":    {"custom"},
+		"compareStart": {"2024-02-02T00:00:00Z"},
+		"compareEnd":   {"2024-02-01T00:00:00Z"},
+	}
+
+	// The comparison period must not end before it starts
+	code, res, _ := getStats(t, e, h, qp)
+	if code != http.StatusBadRequest || res.Message != internal.ErrBEQPInvalidDateTime {
+		t.Errorf("got status %d (%s), want %d (%s)", code, res.Message, http.StatusBadRequest, internal.ErrBEQPInvalidDateTime)
+	}
+}
+
+// A stats repository whose queries never complete in time
+type slowStats struct {
+	repository.StatsRepository
+	err error
+}
+
+func (r *slowStats) Get(ctx context.Context, sq *model.Stats) error {
+	return internal.NewError(internal.ErrDBQuery, r.err, 1)
+}
+
+func TestStatsTimeout(t *testing.T) {
+	h, _, e := newTestHandler(t)
+
+	qp := url.Values{
+		"start":        {"2024-03-01T00:00:00Z"},
+		"end":          {"2024-03-02T00:00:00Z"},
+		"location":     {"space"},
+		"timezone":     {"UTC"},
+		"intervalType": {"hour"},
+		"id":           {primitive.NewObjectID().Hex()},
+	}
+
+	tests := []struct {
+		name    string
+		err     error
+		code    int
+		message string
+	}{
+		{"deadline", context.DeadlineExceeded, http.StatusGatewayTimeout, internal.ErrDBTimeout},
+		{"max time", mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired"}, http.StatusGatewayTimeout, internal.ErrDBTimeout},
+		{"canceled", context.Canceled, http.StatusServiceUnavailable, internal.ErrDBCanceled},
+	}
+
+	stats := h.Stats
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.Stats = &slowStats{StatsRepository: stats, err: tt.err}
+
+			code, res, _ := getStats(t, e, h, qp)
+			if code != tt.code || res.Message != tt.message {
+				t.Errorf("got status %d (%s), want %d (%s)", code, res.Message, tt.code, tt.message)
+			}
+		})
+	}
+}
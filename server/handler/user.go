@@ -9,7 +9,6 @@ import (
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"gopkg.in/gomail.v2"
 
 	"echo_rest_api/database/model"
 	"echo_rest_api/internal"
@@ -30,7 +29,7 @@ func (h *Handler) UserGetAll(c echo.Context) (err error) {
 	}
 
 	// Retrieve all users data from the DB (except the logged in one)
-	u, err := model.UserGetAll(h.DB, id)
+	u, err := h.Users.GetAll(c.Request().Context(), id)
 	if err != nil {
 		return
 	}
@@ -53,13 +52,17 @@ func (h *Handler) Login(c echo.Context) (err error) {
 		return
 	}
 
-	// Find the user in the DB
-	if err = model.UserFind(h.DB, u); err != nil {
+	// Find the user in the DB, counting the failed attempts; the DB failures are not attempts
+	if err = h.Users.Find(c.Request().Context(), u); err != nil {
+		if e, ok := err.(*internal.Error); ok && (e.Message == internal.ErrDBNoData || e.Message == internal.ErrBEInvalidPassword) {
+			h.Metrics.Login(false)
+		}
 		return
 	}
 
 	// Check if the account is active
 	if !u.Active {
+		h.Metrics.Login(false)
 		return internal.NewError(internal.ErrBENotActive, nil, 1)
 	}
 
@@ -83,7 +86,7 @@ func (h *Handler) Login(c echo.Context) (err error) {
 
 	// If we have a mobile request, set a long expiration time
 	var exp time.Duration
-	if exp = h.JwtExp; m {
+	if exp = h.Settings.Get().JwtExp; m {
 		exp, _ = time.ParseDuration("87600h")
 	}
 
@@ -105,6 +108,9 @@ func (h *Handler) Login(c echo.Context) (err error) {
 		return
 	}
 
+	// Count the successful attempt
+	h.Metrics.Login(true)
+
 	return HTTPSuccess(c, u)
 }
 
@@ -130,7 +136,7 @@ func (h *Handler) Invite(c echo.Context) (err error) {
 	}
 
 	// Check if the given email not already registered
-	if err = model.UserEmailExists(h.DB, i); err != nil {
+	if err = h.Users.EmailExists(c.Request().Context(), i); err != nil {
 		return
 	}
 
@@ -152,35 +158,30 @@ func (h *Handler) Invite(c echo.Context) (err error) {
 	}
 
 	// Create a new user and add them to the DB
-	if err = model.UserCreate(h.DB, u); err != nil {
+	if err = h.Users.Create(c.Request().Context(), u); err != nil {
 		return
 	}
 
 	// Send the invite email
-	if h.SMTP.Host != "" {
+	if s := h.Settings.Get(); s.SMTP.Host != "" {
 		// Instantiate a new message
-		m := gomail.NewMessage()
-
-		// Set the required headers
-		m.SetHeaders(map[string][]string{
-			"From":    {m.FormatAddress(h.SMTP.User, "REST.API")},
-			"To":      {i.Email},
-			"Subject": {"Invited to REST.API!"},
-		})
+		m := s.SMTP.NewMessage([]string{i.Email}, "Invited to REST.API!")
 
 		// Set the body of the email
 		b := fmt.Sprintf(
 			"Hello!<br><br>Click on the following link to activate your account: %s/register/:?token=%s.",
-			h.FEndpoint, u.InviteToken,
+			s.InviteURL, u.InviteToken,
 		)
 		m.SetBody("text/html", b)
 
-		d := gomail.NewDialer(h.SMTP.Host, h.SMTP.Port, h.SMTP.User, h.SMTP.Pass)
-		if err := d.DialAndSend(m); err != nil {
+		if err := s.SMTP.Send(c.Request().Context(), m); err != nil {
 			return internal.NewError(internal.ErrBEEmail, err, 1)
 		}
 	}
 
+	// Notify the subscribed webhooks
+	h.publish(c, model.WebhookEventUserInvited, &model.UserMinimalData{ID: u.ID, Email: u.Email, Role: u.Role, Active: u.Active})
+
 	return HTTPSuccess(c, map[string]interface{}{
 		"id": u.ID,
 	})
@@ -200,7 +201,7 @@ func (h *Handler) ValidateInvite(c echo.Context) (err error) {
 	}
 
 	// Check if the given invite token is still available
-	if err = model.UserValidateInvite(h.DB, i); err != nil {
+	if err = h.Users.ValidateInvite(c.Request().Context(), i); err != nil {
 		return
 	}
 
@@ -227,13 +228,17 @@ func (h *Handler) SignUp(c echo.Context) (err error) {
 	}
 
 	// Hash and set the password field
-	s.Password = security.HashPassword(s.Password, s.Salt)
+	s.Password = security.HashPassword(c.Request().Context(), s.Password, s.Salt)
 
 	// Activate the invited user in the DB
-	if err = model.UserSignUp(h.DB, s); err != nil {
+	u, err := h.Users.SignUp(c.Request().Context(), s)
+	if err != nil {
 		return
 	}
 
+	// Notify the subscribed webhooks
+	h.publish(c, model.WebhookEventUserActivated, u)
+
 	return HTTPSuccess(c, nil)
 }
 
@@ -267,10 +272,13 @@ func (h *Handler) UserUpdate(c echo.Context) (err error) {
 	}
 
 	// Update the user details
-	if err = model.UserUpdate(h.DB, u); err != nil {
+	if err = h.Users.Update(c.Request().Context(), u); err != nil {
 		return
 	}
 
+	// Notify the subscribed webhooks
+	h.publish(c, model.WebhookEventUserUpdated, map[string]interface{}{"id": u.ID, "role": u.Role, "active": u.Active})
+
 	return HTTPSuccess(c, nil)
 }
 
@@ -293,9 +301,12 @@ func (h *Handler) UserDelete(c echo.Context) (err error) {
 	}
 
 	// Delete the user in the DB
-	if err = model.UserDelete(h.DB, id); err != nil {
+	if err = h.Users.Delete(c.Request().Context(), id); err != nil {
 		return
 	}
 
+	// Notify the subscribed webhooks
+	h.publish(c, model.WebhookEventUserDeleted, map[string]interface{}{"id": id})
+
 	return HTTPSuccess(c, nil)
 }
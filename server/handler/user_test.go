@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"echo_rest_api/database/model"
+	"echo_rest_api/database/repository"
+	"echo_rest_api/internal"
+	"echo_rest_api/security"
+)
+
+const (
+	testPassword = "s3cret!pass"
+)
+
+// Store a user with the test password
+func addTestUser(t *testing.T, mem *repository.Memory, email, role string, active bool) *model.User {
+	t.Helper()
+
+	salt, err := security.NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &model.User{
+		Email:    email,
+		Password: security.HashPassword(context.Background(), testPassword, salt),
+		Salt:     salt,
+		Role:     role,
+		Active:   active,
+	}
+	mem.Users.Add(u)
+
+	return u
+}
+
+func TestLogin(t *testing.T) {
+	h, mem, e := newTestHandler(t)
+	addTestUser(t, mem, "admin@example.com", "admin", true)
+	addTestUser(t, mem, "pending@example.com", "user", false)
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		code     int
+		message  string
+	}{
+		{"valid", "admin@example.com", testPassword, http.StatusOK, ""},
+		{"wrong password", "admin@example.com", "wr0ng!pass", http.StatusUnauthorized, internal.ErrBEInvalidPassword},
+		{"unknown email", "nobody@example.com", testPassword, http.StatusNotFound, internal.ErrDBNoData},
+		{"not active", "pending@example.com", testPassword, http.StatusInternalServerError, internal.ErrBENotActive},
+		{"invalid email", "admin", testPassword, http.StatusBadRequest, ""},
+		{"invalid password", "admin@example.com", "password", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := jsonRequest(http.MethodPost, "/login", map[string]string{"email": tt.email, "password": tt.password})
+			code, res := serve(t, e, h.Login, req, nil)
+
+			if code != tt.code {
+				t.Fatalf("got status %d, want %d: %s", code, tt.code, res.Message)
+			}
+			if tt.message != "" && res.Message != tt.message {
+				t.Errorf("got message %q, want %q", res.Message, tt.message)
+			}
+			if code != http.StatusOK {
+				return
+			}
+
+			var u struct {
+				Password string `json:"password"`
+				Role     string `json:"role"`
+				Token    string `json:"token"`
+			}
+			if err := json.Unmarshal(res.Data, &u); err != nil {
+				t.Fatal(err)
+			}
+			if u.Token == "" || u.Password != "" || u.Role != "admin" {
+				t.Errorf("got user %+v, want a token, the admin role and no password", u)
+			}
+		})
+	}
+}
+
+func TestInvite(t *testing.T) {
+	h, mem, e := newTestHandler(t)
+	admin := addTestUser(t, mem, "admin@example.com", "admin", true)
+	addTestUser(t, mem, "user@example.com", "user", true)
+
+	// Subscribe a webhook to the invites
+	wh := &model.Webhook{URL: "https://example.com/hook", Events: []string{model.WebhookEventUserInvited}, Active: true}
+	if err := mem.Webhooks.Create(context.Background(), wh); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		claims  *internal.JWTClaims
+		email   string
+		role    string
+		code    int
+		message string
+	}{
+		{"not admin", testClaims(primitive.NewObjectID(), "user"), "new@example.com", "user", http.StatusUnauthorized, internal.ErrBENotAdmin},
+		{"invalid role", testClaims(admin.ID, "admin"), "new@example.com", "owner", http.StatusBadRequest, ""},
+		{"existing email", testClaims(admin.ID, "admin"), "user@example.com", "user", http.StatusBadRequest, internal.ErrBEUserExists},
+		{"valid", testClaims(admin.ID, "admin"), "new@example.com", "user", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := jsonRequest(http.MethodPost, "/invite", map[string]string{"email": tt.email, "role": tt.role})
+			code, res := serve(t, e, h.Invite, req, tt.claims)
+
+			if code != tt.code {
+				t.Fatalf("got status %d, want %d: %s", code, tt.code, res.Message)
+			}
+			if tt.message != "" && res.Message != tt.message {
+				t.Errorf("got message %q, want %q", res.Message, tt.message)
+			}
+			if code != http.StatusOK {
+				return
+			}
+
+			var data struct {
+				ID primitive.ObjectID `json:"id"`
+			}
+			if err := json.Unmarshal(res.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+
+			// The invited user waits for activation, and is linked to its creator
+			u := mem.Users.Get(data.ID)
+			if u == nil || u.Active || u.InviteToken == "" || u.CreatedBy != admin.ID {
+				t.Fatalf("got invited user %+v, want an inactive one with an invite token", u)
+			}
+			if a := mem.Users.Get(admin.ID); len(a.CreatedUsers) != 1 || a.CreatedUsers[0] != u.ID {
+				t.Errorf("got created users %v, want [%s]", a.CreatedUsers, u.ID.Hex())
+			}
+
+			// The subscribed webhook is notified
+			if o := mem.Webhooks.Outbox(); len(o) != 1 || o[0].WebhookID != wh.ID || o[0].Event != model.WebhookEventUserInvited {
+				t.Errorf("got outbox %+v, want a single %s message", o, model.WebhookEventUserInvited)
+			}
+		})
+	}
+}
+
+func TestSignUp(t *testing.T) {
+	h, mem, e := newTestHandler(t)
+	u := &model.User{Email: "new@example.com", Role: "user", InviteToken: "invite-token"}
+	mem.Users.Add(u)
+
+	// An unknown invite token is rejected
+	req := jsonRequest(http.MethodPost, "/validate_invite", map[string]string{"invite_token": "unknown"})
+	if code, res := serve(t, e, h.ValidateInvite, req, nil); code != http.StatusBadRequest || res.Message != internal.ErrBEInvalidInvite {
+		t.Fatalf("got status %d (%s), want %d", code, res.Message, http.StatusBadRequest)
+	}
+
+	// The pending invite token is accepted
+	req = jsonRequest(http.MethodPost, "/validate_invite", map[string]string{"invite_token": "invite-token"})
+	if code, res := serve(t, e, h.ValidateInvite, req, nil); code != http.StatusOK {
+		t.Fatalf("got status %d (%s), want %d", code, res.Message, http.StatusOK)
+	}
+
+	// A weak password is rejected
+	req = jsonRequest(http.MethodPost, "/signup", map[string]string{"invite_token": "invite-token", "password": "password"})
+	if code, res := serve(t, e, h.SignUp, req, nil); code != http.StatusBadRequest {
+		t.Fatalf("got status %d (%s), want %d", code, res.Message, http.StatusBadRequest)
+	}
+
+	// Signing up activates the account with the new password
+	req = jsonRequest(http.MethodPost, "/signup", map[string]string{"invite_token": "invite-token", "password": "n3w!pass"})
+	if code, res := serve(t, e, h.SignUp, req, nil); code != http.StatusOK {
+		t.Fatalf("got status %d (%s), want %d", code, res.Message, http.StatusOK)
+	}
+	if s := mem.Users.Get(u.ID); !s.Active || s.InviteToken != "" {
+		t.Errorf("got user %+v, want an active one without invite token", s)
+	}
+
+	req = jsonRequest(http.MethodPost, "/login", map[string]string{"email": "new@example.com", "password": "n3w!pass"})
+	if code, res := serve(t, e, h.Login, req, nil); code != http.StatusOK {
+		t.Errorf("got login status %d (%s), want %d", code, res.Message, http.StatusOK)
+	}
+
+	// The invite token is used up
+	req = jsonRequest(http.MethodPost, "/signup", map[string]string{"invite_token": "invite-token", "password": "n3w!pass"})
+	if code, res := serve(t, e, h.SignUp, req, nil); code != http.StatusBadRequest || res.Message != internal.ErrBEInvalidInvite {
+		t.Errorf("got status %d (%s), want %d", code, res.Message, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"github.com/labstack/echo/v4"
+
+	"echo_rest_api/database/model"
+	"echo_rest_api/internal"
+)
+
+// GET
+
+// WebhookGetAll gets all webhook subscriptions, without their secrets
+func (h *Handler) WebhookGetAll(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Retrieve all webhooks from the DB
+	w, err := h.Webhooks.GetAll(c.Request().Context())
+	if err != nil {
+		return
+	}
+
+	for i := range w {
+		w[i].Secret = ""
+	}
+
+	return HTTPSuccess(c, w)
+}
+
+// WebhookGet gets a webhook subscription from a given ID, without its secret
+func (h *Handler) WebhookGet(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	id, err := internal.DecodeParameterID(c, "webhookID")
+	if err != nil {
+		return
+	}
+
+	// Retrieve the webhook from the DB
+	w, err := h.Webhooks.Get(c.Request().Context(), id)
+	if err != nil {
+		return
+	}
+	w.Secret = ""
+
+	return HTTPSuccess(c, w)
+}
+
+// WebhookGetDeliveries gets the latest delivery attempts of a webhook subscription from a given ID
+func (h *Handler) WebhookGetDeliveries(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	id, err := internal.DecodeParameterID(c, "webhookID")
+	if err != nil {
+		return
+	}
+
+	// Retrieve the latest deliveries of the webhook from the DB
+	d, err := h.Webhooks.GetDeliveries(c.Request().Context(), id)
+	if err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, d)
+}
+
+// POST
+
+// WebhookCreate creates a new webhook subscription
+func (h *Handler) WebhookCreate(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	w := new(model.Webhook)
+	if err = c.Bind(w); err != nil {
+		return
+	}
+
+	// Validate request data
+	if err = c.Validate(w); err != nil {
+		return
+	}
+
+	// Get the logged-in user ID to set them as the creator
+	w.CreatedBy, err = claims.GetUserID()
+	if err != nil {
+		return
+	}
+
+	// Add the webhook to the DB
+	if err = h.Webhooks.Create(c.Request().Context(), w); err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, map[string]interface{}{
+		"id": w.ID,
+	})
+}
+
+// WebhookTest sends a test event to a webhook subscription from a given ID
+func (h *Handler) WebhookTest(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	id, err := internal.DecodeParameterID(c, "webhookID")
+	if err != nil {
+		return
+	}
+
+	// Retrieve the webhook from the DB
+	w, err := h.Webhooks.Get(c.Request().Context(), id)
+	if err != nil {
+		return
+	}
+
+	// Add the test event to the webhook outbox, whatever events it is subscribed to
+	ids, err := h.Webhooks.Enqueue(c.Request().Context(), []model.Webhook{*w}, model.WebhookEventTest, map[string]interface{}{
+		"message": "This is a test event",
+	})
+	if err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, map[string]interface{}{
+		"messageId": ids[0],
+	})
+}
+
+// PUT
+
+// WebhookUpdate updates a webhook subscription from a given ID
+func (h *Handler) WebhookUpdate(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	w := new(model.Webhook)
+	if err = c.Bind(w); err != nil {
+		return
+	}
+
+	w.ID, err = internal.DecodeParameterID(c, "webhookID")
+	if err != nil {
+		return
+	}
+
+	// Validate request data
+	if err = c.Validate(w); err != nil {
+		return
+	}
+
+	// Update the webhook settings
+	if err = h.Webhooks.Update(c.Request().Context(), w); err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, nil)
+}
+
+// DELETE
+
+// WebhookDelete deletes a webhook subscription from a given ID
+func (h *Handler) WebhookDelete(c echo.Context) (err error) {
+	// Get authenticated user data
+	claims := internal.DecodeClaims(c)
+
+	// Check if the user has rights to this section
+	if err = claims.IsAdmin(); err != nil {
+		return
+	}
+
+	// Bind request data
+	id, err := internal.DecodeParameterID(c, "webhookID")
+	if err != nil {
+		return
+	}
+
+	// Delete the webhook, its outbox and its delivery log in the DB
+	if err = h.Webhooks.Delete(c.Request().Context(), id); err != nil {
+		return
+	}
+
+	return HTTPSuccess(c, nil)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"echo_rest_api/database"
+	"echo_rest_api/internal"
+	"echo_rest_api/server/handler"
+)
+
+// Check that the DB primary answers
+func mongoCheck(dbClient *mongo.Client) handler.HealthCheck {
+	return handler.HealthCheck{
+		Name: "mongodb",
+		Check: func(ctx context.Context) error {
+			return dbClient.Ping(ctx, readpref.Primary())
+		},
+	}
+}
+
+// Check that the DB has no pending migrations, which the server code may rely on; only reported, as the migrations may
+// be run by hand after an upgrade
+func migrationsCheck(dbConn *mongo.Database) handler.HealthCheck {
+	return handler.HealthCheck{
+		Name:       "migrations",
+		ReportOnly: true,
+		Check: func(ctx context.Context) error {
+			rs, err := database.MigrationStatus(ctx, dbConn)
+			if err != nil {
+				return err
+			}
+
+			pending := 0
+			for _, r := range rs {
+				if r.AppliedAt.IsZero() {
+					pending++
+				}
+			}
+			if pending > 0 {
+				return fmt.Errorf("%d pending migrations", pending)
+			}
+
+			return nil
+		},
+	}
+}
+
+// Check that the SMTP server of the active settings accepts connections, if one is configured; only reported, as the
+// emails are not needed to serve the API
+func smtpCheck(settings *internal.LiveSettings) handler.HealthCheck {
+	return handler.HealthCheck{
+		Name:       "smtp",
+		ReportOnly: true,
+		Check: func(ctx context.Context) error {
+			s := settings.Get().SMTP
+			if s.Host == "" {
+				return nil
+			}
+
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+			if err != nil {
+				return err
+			}
+
+			return conn.Close()
+		},
+	}
+}
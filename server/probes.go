@@ -0,0 +1,37 @@
+package server
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"echo_rest_api/internal"
+	"echo_rest_api/server/handler"
+)
+
+// Create the probe server, serving the liveness and readiness probes apart from the public API; it has no auth, as
+// the load balancers and the kubelet cannot give any, so it must only be reachable from the cluster
+func newProbeServer(env *internal.Environ, h *handler.Handler, logger echo.Logger) *echo.Echo {
+	p := echo.New()
+
+	// Remove Echo startup banner and port, which are not structured
+	p.HideBanner = true
+	p.HidePort = true
+
+	// Configure Echo port
+	p.Server.Addr = env.ProbeAddr
+
+	// Share the server logging
+	p.Logger = logger
+
+	// Configure Echo panic recovery
+	p.Use(middleware.Recover())
+
+	// Configure Echo error handler
+	p.HTTPErrorHandler = internal.ErrorHandler
+
+	// Health probes
+	p.GET("/healthz", h.Healthz)
+	p.GET("/readyz", h.Readyz)
+
+	return p
+}
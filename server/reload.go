@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"echo_rest_api/internal"
+)
+
+type (
+	// Reloads the settings that are safe to change while running, keeping the others until the next restart
+	reloader struct {
+		mu       sync.Mutex
+		args     []string
+		env      *internal.Environ // The active configuration
+		settings *internal.LiveSettings
+		logger   *internal.Logger
+		metrics  *internal.Metrics
+	}
+)
+
+// Create the settings of the handlers and workers that can be reloaded from the configuration
+func newSettings(env *internal.Environ, metrics *internal.Metrics) (internal.Settings, error) {
+	origins, err := internal.NewOrigins(env.AllowedOrigins(), env.CORSTenantOrigins)
+	if err != nil {
+		return internal.Settings{}, err
+	}
+
+	return internal.Settings{
+		JwtExp:    env.JwtExp,
+		Origins:   origins,
+		InviteURL: env.InviteBaseURL(),
+		SMTP: internal.SMTP{
+			Host:    env.SMTPHost,
+			Port:    env.SMTPPort,
+			User:    env.SMTPUser,
+			Pass:    env.SMTPPass,
+			Metrics: metrics,
+		},
+	}, nil
+}
+
+// Take the reloadable settings of a new configuration, keeping the other ones of the active configuration; the FE
+// endpoint needs a restart, as the CSRF cookie domain is taken from it
+func reloadable(active, loaded *internal.Environ) *internal.Environ {
+	next := *active
+	next.CORSOrigins, next.CORSTenantOrigins, next.InviteURL = loaded.CORSOrigins, loaded.CORSTenantOrigins, loaded.InviteURL
+	next.LogLevel = loaded.LogLevel
+	next.JwtExp = loaded.JwtExp
+	next.SMTPHost, next.SMTPPort, next.SMTPUser, next.SMTPPass = loaded.SMTPHost, loaded.SMTPPort, loaded.SMTPUser, loaded.SMTPPass
+	return &next
+}
+
+// Run reloads the configuration on SIGHUP, and when the config file changes if polling is enabled, until the context
+// is cancelled
+func (r *reloader) Run(ctx context.Context, poll time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// Watch the config file by polling, which works on all file systems, including mounted volumes
+	var tick <-chan time.Time
+	var last os.FileInfo
+	if r.env.ConfigFile != "" && poll > 0 {
+		t := time.NewTicker(poll)
+		defer t.Stop()
+		tick = t.C
+		last, _ = os.Stat(r.env.ConfigFile)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload("SIGHUP")
+		case <-tick:
+			fi, err := os.Stat(r.env.ConfigFile)
+			if err != nil {
+				r.logger.Errorf("Failed to watch the config file: %s", err)
+				continue
+			}
+			if last != nil && fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size() {
+				continue
+			}
+			last = fi
+			r.reload("config file change")
+		}
+	}
+}
+
+// Reload the configuration, applying the reloadable settings only if the whole configuration is valid
+func (r *reloader) reload(trigger string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	loaded, err := internal.GetEnv(r.args)
+	if err != nil {
+		r.metrics.ConfigReload(false)
+		r.logger.Errorf("Rejected the configuration reload on %s, keeping version %d: %s", trigger, r.settings.Version(), err)
+		return
+	}
+
+	// Find the changes, telling apart the ones that need a restart
+	next := reloadable(r.env, loaded)
+	applied, ignored := internal.EnvironDiff(r.env, next), internal.EnvironDiff(next, loaded)
+	sort.Strings(applied)
+	sort.Strings(ignored)
+	if len(ignored) > 0 {
+		r.logger.Warnf("Ignored the changes of %v on %s, they need a restart", ignored, trigger)
+	}
+	if len(applied) == 0 {
+		r.metrics.ConfigReload(true)
+		r.logger.Infof("No reloadable changes on %s, keeping version %d", trigger, r.settings.Version())
+		return
+	}
+
+	// Swap the settings
+	s, err := newSettings(next, r.metrics)
+	if err == nil {
+		err = r.logger.SetLevelName(next.LogLevel)
+	}
+	if err != nil {
+		r.metrics.ConfigReload(false)
+		r.logger.Errorf("Rejected the configuration reload on %s, keeping version %d: %s", trigger, r.settings.Version(), err)
+		return
+	}
+	version := r.settings.Set(s)
+	r.env = next
+
+	r.metrics.ConfigReload(true)
+	r.metrics.ConfigVersion(version)
+	r.logger.Infof("Reloaded %v on %s, now at version %d", applied, trigger, version)
+}
@@ -2,7 +2,6 @@ package server
 
 import (
 	"github.com/labstack/echo/v4"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"echo_rest_api/server/handler"
 )
@@ -14,9 +13,6 @@ func assignRoutesAndHandlers(e *echo.Echo, h *handler.Handler) {
 	// Tester
 	e.GET("/cache_test", h.CacheTest)
 
-	// Metrics management
-	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
-
 	// Users processes management
 	e.POST("/login", h.Login)
 	e.POST("/invite", h.Invite)
@@ -36,4 +32,45 @@ func assignRoutesAndHandlers(e *echo.Echo, h *handler.Handler) {
 	stats := e.Group("/stats")
 
 	stats.GET("", h.StatsGetData)
+	stats.GET("/export", h.StatsExport)
+
+	// Reports
+	reports := e.Group("/reports")
+
+	reports.GET("", h.ReportGetAll)
+	reports.GET("/:reportID", h.ReportGet)
+	reports.GET("/:reportID/deliveries", h.ReportGetDeliveries)
+
+	reports.POST("", h.ReportCreate)
+
+	reports.PUT("/:reportID", h.ReportUpdate)
+
+	reports.DELETE("/:reportID", h.ReportDelete)
+
+	// Alerts
+	alerts := e.Group("/alerts")
+
+	alerts.GET("", h.AlertGetAll)
+	alerts.GET("/history", h.AlertGetHistory)
+	alerts.GET("/:alertID", h.AlertGet)
+
+	alerts.POST("", h.AlertCreate)
+
+	alerts.PUT("/:alertID", h.AlertUpdate)
+
+	alerts.DELETE("/:alertID", h.AlertDelete)
+
+	// Webhooks
+	webhooks := e.Group("/webhooks")
+
+	webhooks.GET("", h.WebhookGetAll)
+	webhooks.GET("/:webhookID", h.WebhookGet)
+	webhooks.GET("/:webhookID/deliveries", h.WebhookGetDeliveries)
+
+	webhooks.POST("", h.WebhookCreate)
+	webhooks.POST("/:webhookID/test", h.WebhookTest)
+
+	webhooks.PUT("/:webhookID", h.WebhookUpdate)
+
+	webhooks.DELETE("/:webhookID", h.WebhookDelete)
 }
@@ -9,34 +9,96 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"echo_rest_api/database"
+	"echo_rest_api/database/model"
+	"echo_rest_api/database/repository"
 	"echo_rest_api/internal"
 	"echo_rest_api/security"
 	"echo_rest_api/server/handler"
+	"echo_rest_api/worker"
 )
 
-// InitServer initializes an Echo server and DB connection
-func InitServer() (*echo.Echo, *mongo.Client) {
+type (
+	// Server holds what is needed to run the server and shut it down gracefully
+	Server struct {
+		Echo       *echo.Echo    // The public API
+		Admin      *echo.Echo    // The metrics and profiling; nil if disabled
+		Probes     *echo.Echo    // The health probes, reachable by the orchestrator; nil if served by the admin server
+		DB         *mongo.Client // The DB connection
+		Health     *handler.Health
+		DrainDelay time.Duration // The time given to the load balancers to stop sending requests, once not ready
+
+		ShutdownTracing func(context.Context) error // Flushes the pending spans, once the requests are finished
+	}
+)
+
+// InitServer initializes an Echo server, its admin and probe servers if enabled, and DB connection, configured by the given CLI
+// flags on top of the environment
+func InitServer(args []string) *Server {
 	// Create a new Echo instance
 	e := echo.New()
 
 	// Get configuration from environment
-	env, err := internal.GetEnv()
+	env, err := internal.GetEnv(args)
 	if err != nil {
-		e.Logger.Fatalf("Failed to get environment variables: %s", err)
+		e.Logger.Fatalf("Failed to get configuration: %s", err)
 	}
 
+	// Configure the structured logging
+	logger, err := internal.NewLogger(env.LogLevel, env.LogFormat)
+	if err != nil {
+		e.Logger.Fatalf("Failed to configure logging: %s", err)
+	}
+	e.Logger = logger
+
+	// Configure the tracing
+	shutdownTracing, err := internal.NewTracing(context.Background(), env.TraceExporter)
+	if err != nil {
+		e.Logger.Fatalf("Failed to configure tracing: %s", err)
+	}
+
+	// Create the Prometheus metrics
+	metrics := internal.NewMetrics()
+
+	// Create the settings that can be reloaded while running
+	s, err := newSettings(env, metrics)
+	if err != nil {
+		e.Logger.Fatalf("Failed to configure the settings: %s", err)
+	}
+	settings := internal.NewLiveSettings(s)
+
 	// Configure the Echo instance
-	configureEcho(e, env)
+	configureEcho(e, env, metrics, settings)
 
 	// Configure Echo security, if requested
 	if !env.SkipChecks {
 		security.ConfigureEchoSecurity(e, env)
 	}
 
+	// Bound the DB operations
+	model.OperationTimeout, model.AggregationTimeout = env.DBTimeout, env.DBAggregateTimeout
+
 	// Create the database client & connection
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	dbClient, dbConn := database.NewDBClientAndConnection(ctx, env, e.Logger)
+	dbClient, dbConn := database.NewDBClientAndConnection(ctx, env, metrics, e.Logger)
+
+	// Apply the pending DB migrations, if requested
+	if env.Migrate {
+		if err = database.Migrate(context.Background(), dbConn, e.Logger); err != nil {
+			e.Logger.Fatalf("Failed to migrate the DB: %s", err)
+		}
+	}
+
+	// Keep the expiry of the raw gate events and space results up to date, once stored as time series
+	if err = database.SetRawExpiry(context.Background(), dbConn, env.RawExpiry, e.Logger); err != nil {
+		e.Logger.Errorf("Failed to set the raw data expiry: %s", err)
+	}
+
+	// Check if the DB can generate the missing stats buckets by itself
+	densify, err := model.StatsSupportsDensify(context.Background(), dbConn)
+	if err != nil {
+		e.Logger.Warnf("Failed to get DB version, stats gaps will be filled by the server: %s", err)
+	}
 
 	// Initialize the data cache
 	c := ttlcache.NewCache()
@@ -44,22 +106,96 @@ func InitServer() (*echo.Echo, *mongo.Client) {
 
 	// Initialize the route handler
 	h := &handler.Handler{
-		JwtSecret: env.JwtSecret,
-		JwtExp:    env.JwtExp,
-		DB:        dbConn,
-		Cache:     c,
-		FEndpoint: env.FEndpoint,
-		SMTP: handler.SMTP{
-			Host: env.SMTPHost,
-			Port: env.SMTPPort,
-			User: env.SMTPUser,
-			Pass: env.SMTPPass,
-		},
+		JwtSecret:    env.JwtSecret,
+		Densify:      densify,
+		Cache:        c,
+		Settings:     settings,
+		Metrics:      metrics,
+		Repositories: repository.NewMongo(dbConn),
+	}
+
+	// Check the dependencies for readiness, only the DB being required to take requests
+	h.Health = &handler.Health{
+		Checks: []handler.HealthCheck{mongoCheck(dbClient), migrationsCheck(dbConn), smtpCheck(settings)},
 	}
 
 	// Assign the routes & handlers
 	assignRoutesAndHandlers(e, h)
 
+	// Start the background workers, until the server is shut down
+	wCtx, wCancel := context.WithCancel(context.Background())
+	e.Server.RegisterOnShutdown(wCancel)
+
+	// Send the scheduled reports
+	if env.SMTPHost == "" {
+		e.Logger.Warn("No SMTP server configured, the scheduled reports will not be sent until one is")
+	}
+	r := &worker.Reports{
+		DB:       dbConn,
+		Settings: settings,
+		Densify:  densify,
+		Logger:   e.Logger,
+	}
+	go r.Run(wCtx)
+
+	// Evaluate the alerts
+	a := &worker.Alerts{
+		DB:       dbConn,
+		Settings: settings,
+		Logger:   e.Logger,
+	}
+	go a.Run(wCtx)
+
+	// Deliver the webhook messages and publish the gate events
+	wh := &worker.Webhooks{
+		DB:     dbConn,
+		Logger: e.Logger,
+	}
+	go wh.Run(wCtx)
+
+	// Keep the stats rollups up to date
+	ru := &worker.Rollups{
+		DB:     dbConn,
+		Logger: e.Logger,
+	}
+	go ru.Run(wCtx)
+
+	// Reload the reloadable settings on SIGHUP or when the config file changes
+	rl := &reloader{
+		args:     args,
+		env:      env,
+		settings: settings,
+		logger:   logger,
+		metrics:  metrics,
+	}
+	go rl.Run(wCtx, env.ConfigPoll)
+
+	// Serve the metrics, health probes and profiling on their own address, if enabled
+	var admin *echo.Echo
+	if env.AdminAddr != "" {
+		admin = newAdminServer(env, h, e.Logger)
+	} else {
+		e.Logger.Warn("No admin address configured, the metrics will not be served")
+	}
+
+	// Serve the health probes on their own address, without auth so the orchestrator can reach them
+	var probes *echo.Echo
+	switch {
+	case env.ProbeAddr != "":
+		probes = newProbeServer(env, h, e.Logger)
+	case admin == nil:
+		e.Logger.Warn("No probe or admin address configured, the health probes will not be served")
+	}
+
 	// Return
-	return e, dbClient
+	return &Server{
+		Echo:       e,
+		Admin:      admin,
+		Probes:     probes,
+		DB:         dbClient,
+		Health:     h.Health,
+		DrainDelay: env.ShutdownDrain,
+
+		ShutdownTracing: shutdownTracing,
+	}
 }
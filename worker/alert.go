@@ -0,0 +1,292 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"echo_rest_api/database/model"
+	"echo_rest_api/internal"
+	"echo_rest_api/security"
+)
+
+type (
+	// Alerts evaluates the alert rules and notifies their state changes by email and webhook
+	Alerts struct {
+		DB       *mongo.Database
+		Settings *internal.LiveSettings
+		Logger   echo.Logger
+		client   *http.Client
+	}
+
+	alertNotification struct {
+		*model.AlertEvent
+		Message string `json:"message"`
+	}
+)
+
+const (
+	alertsTick       = time.Minute      // How often the alerts are evaluated
+	alertMaxAttempts = 30               // The notification attempts of a state change, one per evaluation
+	webhookTimeout   = 10 * time.Second // How long a webhook call may take
+)
+
+// Run evaluates the active alerts every minute, until the context is cancelled
+func (w *Alerts) Run(ctx context.Context) {
+	w.client = &http.Client{Timeout: webhookTimeout}
+
+	t := time.NewTicker(alertsTick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			w.evaluate(ctx, now)
+		}
+	}
+}
+
+// Evaluate all active alerts at the given time, notifying only the ones changing their state
+func (w *Alerts) evaluate(ctx context.Context, t time.Time) {
+	as, err := model.AlertGetActive(ctx, w.DB)
+	if err != nil {
+		w.Logger.Errorf("Failed to get the active alerts: %s", errorMessage(err))
+		return
+	}
+
+	for i := range as {
+		a := &as[i]
+
+		// Retry the notification of the last state change first, if some of its channels failed
+		if a.Pending != nil {
+			w.retry(ctx, a)
+		}
+
+		firing, v, err := model.AlertEvaluate(ctx, w.DB, a, t)
+		if err != nil {
+			w.Logger.Errorf("Failed to evaluate alert %s: %s", a.ID.Hex(), errorMessage(err))
+			continue
+		}
+
+		// Skip the alerts keeping their state, so each change is notified once
+		state := model.AlertStateOK
+		if firing {
+			state = model.AlertStateFiring
+		}
+		if state == a.State || (a.State == "" && state == model.AlertStateOK) {
+			continue
+		}
+
+		// Change the state along with its pending notification first, so it is only notified once when several servers
+		// are running, and retried if it fails
+		e := &model.AlertEvent{
+			AlertID:  a.ID,
+			Name:     a.Name,
+			Type:     a.Type,
+			EntityID: a.EntityID,
+			State:    state,
+			Time:     t,
+			Value:    v,
+		}
+		n := &model.AlertNotification{Event: *e, Channels: alertChannels(a)}
+		ok, err := model.AlertSetState(ctx, w.DB, a, state, t, n)
+		if err != nil {
+			w.Logger.Errorf("Failed to change the state of alert %s: %s", a.ID.Hex(), errorMessage(err))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		a.State, a.Pending = state, n
+
+		// Notify the state change and record it in the history
+		e.Errors = w.deliver(ctx, a)
+
+		if err = model.AlertEventCreate(ctx, w.DB, e); err != nil {
+			w.Logger.Errorf("Failed to record the state change of alert %s: %s", a.ID.Hex(), errorMessage(err))
+		}
+
+		// Publish the state change to the subscribed webhooks
+		event := model.WebhookEventAlertResolved
+		if firing {
+			event = model.WebhookEventAlertFiring
+		}
+		if err = model.WebhookPublish(ctx, w.DB, event, e); err != nil {
+			w.Logger.Errorf("Failed to publish the state change of alert %s: %s", a.ID.Hex(), errorMessage(err))
+		}
+	}
+}
+
+// Retry the pending notification of an alert, to the channels it still has, until it runs out of attempts
+func (w *Alerts) retry(ctx context.Context, a *model.Alert) {
+	// Drop the channels removed from the alert since
+	current := alertChannels(a)
+	var channels []string
+	for _, ch := range a.Pending.Channels {
+		if internal.InSlice(ch, current) {
+			channels = append(channels, ch)
+		}
+	}
+
+	// Give up once out of attempts, or of channels
+	if len(channels) == 0 || a.Pending.Attempts >= alertMaxAttempts {
+		if len(channels) > 0 {
+			w.Logger.Errorf("Gave up notifying alert %s to %v after %d attempts", a.ID.Hex(), channels, a.Pending.Attempts)
+		}
+		if _, err := model.AlertSetPending(ctx, w.DB, a, nil); err != nil {
+			w.Logger.Errorf("Failed to clear the notification of alert %s: %s", a.ID.Hex(), errorMessage(err))
+		}
+		a.Pending = nil
+		return
+	}
+
+	a.Pending.Channels = channels
+	if errs := w.deliver(ctx, a); len(errs) == 0 && a.Pending == nil {
+		w.Logger.Infof("Notified alert %s on a later attempt", a.ID.Hex())
+	}
+}
+
+// Send the pending notification of an alert to its channels, keeping the failed ones pending and returning their
+// errors; the attempt is claimed first, so it is only made by one server
+func (w *Alerts) deliver(ctx context.Context, a *model.Alert) []string {
+	claimed := *a.Pending
+	claimed.Attempts++
+	ok, err := model.AlertSetPending(ctx, w.DB, a, &claimed)
+	if err != nil {
+		w.Logger.Errorf("Failed to claim the notification of alert %s: %s", a.ID.Hex(), errorMessage(err))
+		return []string{fmt.Sprintf("claim: %s", errorMessage(err))}
+	}
+	if !ok {
+		return nil
+	}
+	a.Pending = &claimed
+
+	// Send the notification to each channel
+	var errs, failed []string
+	n := &alertNotification{AlertEvent: &claimed.Event, Message: alertMessage(a, &claimed.Event)}
+	for _, ch := range claimed.Channels {
+		if ch == model.AlertChannelEmail {
+			err = w.email(ctx, a, n)
+		} else {
+			err = w.webhook(ctx, a, ch, n)
+		}
+		if err != nil {
+			w.Logger.Errorf("Failed to notify alert %s to %s: %s", a.ID.Hex(), ch, err)
+			errs, failed = append(errs, fmt.Sprintf("%s: %s", ch, err)), append(failed, ch)
+		}
+	}
+
+	// Keep the failed channels for the next attempt
+	var next *model.AlertNotification
+	if len(failed) > 0 {
+		next = &claimed
+		next.Channels = failed
+	}
+	if _, err = model.AlertSetPending(ctx, w.DB, a, next); err != nil {
+		w.Logger.Errorf("Failed to update the notification of alert %s: %s", a.ID.Hex(), errorMessage(err))
+	}
+	a.Pending = next
+
+	return errs
+}
+
+// Send an alert notification by email
+func (w *Alerts) email(ctx context.Context, a *model.Alert, n *alertNotification) error {
+	s := w.Settings.Get().SMTP
+	if s.Host == "" {
+		return errors.New("no SMTP server configured")
+	}
+
+	m := s.NewMessage(a.Emails, fmt.Sprintf("[%s] %s", alertStateLabel(n.State), a.Name))
+	m.SetBody("text/plain", fmt.Sprintf("%s\n\nTime: %s", n.Message, n.Time.Format(time.RFC3339)))
+
+	if err := s.Send(ctx, m); err != nil {
+		return internal.NewError(internal.ErrBEEmail, err, 1)
+	}
+
+	return nil
+}
+
+// Send an alert notification as JSON to a webhook, signed with the secret of the alert like the webhook messages
+func (w *Alerts) webhook(ctx context.Context, a *model.Alert, u string, n *alertNotification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	event := model.WebhookEventAlertResolved
+	if n.State == model.AlertStateFiring {
+		event = model.WebhookEventAlertFiring
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Signature", security.SignWebhook(a.Secret, time.Now().Unix(), b))
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %s", res.Status)
+	}
+
+	return nil
+}
+
+// Get the notification channels of an alert
+func alertChannels(a *model.Alert) []string {
+	var channels []string
+	if len(a.Emails) > 0 {
+		channels = append(channels, model.AlertChannelEmail)
+	}
+
+	return append(channels, a.Webhooks...)
+}
+
+// Get the label of an alert state
+func alertStateLabel(state string) string {
+	if state == model.AlertStateFiring {
+		return "FIRING"
+	}
+
+	return "RESOLVED"
+}
+
+// Describe an alert state change
+func alertMessage(a *model.Alert, e *model.AlertEvent) string {
+	id := a.EntityID.Hex()
+	firing := e.State == model.AlertStateFiring
+
+	switch a.Type {
+	case model.AlertTypeOccupancy:
+		if firing {
+			return fmt.Sprintf("The count of space %s has been above %g for the last %d minutes (lowest: %g).", id, a.Threshold, a.Minutes, *e.Value)
+		}
+		return fmt.Sprintf("The count of space %s is no longer above %g.", id, a.Threshold)
+	case model.AlertTypeGateSilent:
+		if firing {
+			return fmt.Sprintf("No events were received from gate %s in the last %d minutes.", id, a.Minutes)
+		}
+		return fmt.Sprintf("Events are received again from gate %s.", id)
+	default:
+		if firing {
+			return fmt.Sprintf("No results were received from space %s in the last %d minutes.", id, a.Minutes)
+		}
+		return fmt.Sprintf("Results are received again from space %s.", id)
+	}
+}
@@ -0,0 +1,177 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/gomail.v2"
+
+	"echo_rest_api/database/model"
+	"echo_rest_api/internal"
+	"echo_rest_api/render"
+)
+
+type (
+	// Reports sends the scheduled stats reports by email
+	Reports struct {
+		DB       *mongo.Database
+		Settings *internal.LiveSettings
+		Densify  bool
+		Logger   echo.Logger
+	}
+)
+
+const (
+	reportsTick = time.Minute // How often the due reports are checked
+)
+
+// Run sends the due reports every minute, until the context is cancelled; the reports wait while no SMTP server is
+// configured
+func (w *Reports) Run(ctx context.Context) {
+	t := time.NewTicker(reportsTick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if w.Settings.Get().SMTP.Host != "" {
+				w.sendDue(ctx, now)
+			}
+		}
+	}
+}
+
+// Send all the reports due at the given time
+func (w *Reports) sendDue(ctx context.Context, t time.Time) {
+	rs, err := model.ReportGetDue(ctx, w.DB, t)
+	if err != nil {
+		w.Logger.Errorf("Failed to get the due reports: %s", errorMessage(err))
+		return
+	}
+
+	for i := range rs {
+		r := &rs[i]
+
+		// Schedule the next run first, so a report is only sent once when several servers are running
+		cr, err := internal.ParseCron(r.Schedule)
+		if err != nil {
+			w.Logger.Errorf("Invalid schedule of report %s: %s", r.ID.Hex(), err)
+			continue
+		}
+		tz, err := internal.LoadTimezone(r.Timezone)
+		if err != nil {
+			w.Logger.Errorf("Invalid timezone of report %s: %s", r.ID.Hex(), err)
+			continue
+		}
+
+		ok, err := model.ReportClaim(ctx, w.DB, r, cr.Next(t.In(tz)), t)
+		if err != nil {
+			w.Logger.Errorf("Failed to schedule report %s: %s", r.ID.Hex(), errorMessage(err))
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		// Send the report and record the delivery
+		d := w.send(ctx, r, t)
+		if err = model.ReportDeliveryCreate(ctx, w.DB, d); err != nil {
+			w.Logger.Errorf("Failed to record the delivery of report %s: %s", r.ID.Hex(), errorMessage(err))
+		}
+	}
+}
+
+// Send a report with the stats of its last complete period before the given time
+func (w *Reports) send(ctx context.Context, r *model.Report, t time.Time) *model.ReportDelivery {
+	d := &model.ReportDelivery{
+		ReportID:   r.ID,
+		Time:       t,
+		Recipients: r.Recipients,
+	}
+
+	if err := w.sendReport(ctx, r, t, d); err != nil {
+		w.Logger.Errorf("Failed to send report %s: %s", r.ID.Hex(), errorMessage(err))
+		d.Error = errorMessage(err)
+		return d
+	}
+
+	d.Success = true
+
+	return d
+}
+
+// Render and send a report email, with the stats rows attached as a file
+func (w *Reports) sendReport(ctx context.Context, r *model.Report, t time.Time, d *model.ReportDelivery) error {
+	sq, err := r.StatsQuery(t, w.Densify)
+	if err != nil {
+		return err
+	}
+	d.Start, d.End = sq.Start, sq.End
+
+	// Stream the stats rows into the attached file and the summary at once
+	var file bytes.Buffer
+	cols := model.StatsColumns(sq)
+	s := render.NewSummary(sq, cols)
+
+	x, err := render.NewExporter(r.Format, &file, sq, cols)
+	if err != nil {
+		return internal.NewError(internal.ErrBEExport, err, 1)
+	}
+
+	if err = model.StatsStream(ctx, w.DB, sq, func(row *model.StatsRow) error {
+		s.Add(row)
+
+		if err := x.Write(row); err != nil {
+			return internal.NewError(internal.ErrBEExport, err, 1)
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err = x.Close(); err != nil {
+		return internal.NewError(internal.ErrBEExport, err, 1)
+	}
+
+	// Render the HTML summary
+	var body bytes.Buffer
+	if err = render.Report(&body, r, s); err != nil {
+		return err
+	}
+
+	// Build the email message
+	smtp := w.Settings.Get().SMTP
+	m := smtp.NewMessage(r.Recipients, fmt.Sprintf("%s - %s", r.Name, sq.Start.In(sq.TZ).Format("2006-01-02")))
+	m.SetBody("text/html", body.String())
+	m.Attach(
+		fmt.Sprintf("stats_%s.%s", sq.Start.In(sq.TZ).Format("2006-01-02"), r.Format),
+		gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(file.Bytes())
+			return err
+		}),
+		gomail.SetHeader(map[string][]string{"Content-Type": {render.ExportContentTypes[r.Format]}}),
+	)
+
+	if err = smtp.Send(ctx, m); err != nil {
+		return internal.NewError(internal.ErrBEEmail, err, 1)
+	}
+
+	return nil
+}
+
+// Get the full message of an error, including the original error of backend ones
+func errorMessage(err error) string {
+	if e, ok := err.(*internal.Error); ok && e.Original != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Original)
+	}
+
+	return err.Error()
+}
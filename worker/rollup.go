@@ -0,0 +1,191 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"echo_rest_api/database/model"
+)
+
+type (
+	// Rollups keeps the hourly and daily rollups of the gate events and space results up to date
+	Rollups struct {
+		DB     *mongo.Database
+		Logger echo.Logger
+	}
+)
+
+const (
+	rollupsTick     = 5 * time.Second // How often the new raw documents are checked
+	rollupTailBatch = 1000            // The raw documents rolled up at once
+	rollupOverlap   = time.Minute     // How long the raw documents are rolled up again, in case others are added before them
+)
+
+var (
+	rollupCursors = map[string]string{
+		model.StatsLocationGate:  "rollups.events",
+		model.StatsLocationSpace: "rollups.space_results",
+	}
+)
+
+// Run rolls up the new gate events and space results every few seconds, until the context is cancelled
+func (w *Rollups) Run(ctx context.Context) {
+	if err := model.RollupEnsureIndexes(ctx, w.DB); err != nil {
+		w.Logger.Errorf("Failed to create the rollup indexes, the stats will be read from the raw data: %s", errorMessage(err))
+		return
+	}
+
+	t := time.NewTicker(rollupsTick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			for _, l := range model.RollupLocations {
+				w.tail(ctx, l, now)
+			}
+		}
+	}
+}
+
+// Backfill rebuilds the rollups of all locations from the UTC day of the given date, or of their first data if zero, up
+// to now
+func (w *Rollups) Backfill(ctx context.Context, from time.Time) error {
+	if err := model.RollupEnsureIndexes(ctx, w.DB); err != nil {
+		return err
+	}
+
+	for _, l := range model.RollupLocations {
+		start := from
+		if start.IsZero() {
+			var err error
+			if start, err = model.RollupGetFirstTime(ctx, w.DB, l); err != nil {
+				return err
+			}
+			if start.IsZero() {
+				start = time.Now()
+			}
+		}
+
+		w.Logger.Infof("Rolling up the %s stats from %s", l, start.UTC().Format("2006-01-02"))
+		if err := w.backfill(ctx, l, start); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rebuild the rollups of a location from the UTC day of the given date up to now
+func (w *Rollups) backfill(ctx context.Context, location string, from time.Time) error {
+	// Set the position first, so the raw documents added while rebuilding are rolled up by the tailer, including the
+	// ones added late with earlier IDs
+	last, err := model.RollupGetLastID(ctx, w.DB, location)
+	if err != nil {
+		return err
+	}
+	if bound := rollupBound(time.Now()); last.IsZero() || idAfter(last, bound) {
+		last = bound
+	}
+	if _, err = model.CursorAdvance(ctx, w.DB, rollupCursors[location], primitive.NilObjectID, last); err != nil {
+		return err
+	}
+
+	// Rebuild a day at a time, so each aggregation stays small
+	start := from.UTC().Truncate(24 * time.Hour)
+	for d := start; d.Before(time.Now()); d = d.Add(24 * time.Hour) {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		hours := make([]time.Time, 24)
+		for i := range hours {
+			hours[i] = d.Add(time.Duration(i) * time.Hour)
+		}
+
+		if err = model.RollupRefresh(ctx, w.DB, location, nil, hours); err != nil {
+			return err
+		}
+	}
+
+	// Only use the rollups for stats queries once they are complete
+	return model.RollupCover(ctx, w.DB, location, start)
+}
+
+// Roll up the raw documents of a location added since the last check
+func (w *Rollups) tail(ctx context.Context, location string, t time.Time) {
+	name := rollupCursors[location]
+
+	last, err := model.CursorGet(ctx, w.DB, name)
+	if err != nil {
+		w.Logger.Errorf("Failed to get the %s rollups position: %s", location, errorMessage(err))
+		return
+	}
+
+	// Start with the rollups of the current day, instead of rebuilding all the past ones
+	if last.IsZero() {
+		if err = w.backfill(ctx, location, t); err != nil {
+			w.Logger.Errorf("Failed to start the %s rollups: %s", location, errorMessage(err))
+		}
+		return
+	}
+
+	// Documents are taken in ID order, but the IDs are generated by the clients so a document may be added after others
+	// with later IDs; the position is kept behind by the overlap, so the recent documents are taken again until then
+	bound := rollupBound(t)
+	for from := last; ctx.Err() == nil; {
+		ch, err := model.RollupGetChanges(ctx, w.DB, location, from, rollupTailBatch)
+		if err != nil || ch == nil {
+			if err != nil {
+				w.Logger.Errorf("Failed to get the new %s stats: %s", location, errorMessage(err))
+			}
+			return
+		}
+
+		// Recompute the touched rollups from the raw data before moving the position, so none are missed; this is
+		// idempotent, so it does not matter when several servers are running or documents are taken again
+		if err = model.RollupRefresh(ctx, w.DB, location, ch.IDs, ch.Hours); err != nil {
+			w.Logger.Errorf("Failed to roll up the new %s stats: %s", location, errorMessage(err))
+			return
+		}
+
+		next := ch.Last
+		if idAfter(next, bound) {
+			next = bound
+		}
+		if idAfter(next, last) {
+			ok, err := model.CursorAdvance(ctx, w.DB, name, last, next)
+			if err != nil {
+				w.Logger.Errorf("Failed to move the %s rollups position: %s", location, errorMessage(err))
+				return
+			}
+			if !ok {
+				return
+			}
+			last = next
+		}
+
+		// Stop once all the documents were taken
+		if ch.Count < rollupTailBatch {
+			return
+		}
+		from = ch.Last
+	}
+}
+
+// Get the position the rollups are kept behind at a given time
+func rollupBound(t time.Time) primitive.ObjectID {
+	return primitive.NewObjectIDFromTimestamp(t.Add(-rollupOverlap))
+}
+
+// Check if an ID comes after another one
+func idAfter(a, b primitive.ObjectID) bool {
+	return bytes.Compare(a[:], b[:]) > 0
+}
@@ -0,0 +1,250 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"echo_rest_api/database/model"
+	"echo_rest_api/internal"
+	"echo_rest_api/security"
+)
+
+type (
+	// Webhooks delivers the outbox messages to their webhooks and publishes the new gate events
+	Webhooks struct {
+		DB     *mongo.Database
+		Logger echo.Logger
+		client *http.Client
+	}
+)
+
+const (
+	webhooksTick       = 5 * time.Second // How often the outbox and the gate events are checked
+	webhookLease       = time.Minute     // How long a claimed message is hidden from other servers
+	webhookMaxAttempts = 10              // The delivery attempts before giving up on a message
+	webhookBackoff     = 30 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+	webhookTailBatch   = 1000 // The gate events published at once
+	webhookWorkers     = 8    // The messages delivered at once, each to a different webhook
+	eventsCursor       = "webhooks.events"
+)
+
+// Run delivers the due outbox messages and publishes the new gate events every few seconds, until the context is cancelled
+func (w *Webhooks) Run(ctx context.Context) {
+	w.client = &http.Client{Timeout: webhookTimeout}
+
+	t := time.NewTicker(webhooksTick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			w.tail(ctx, now)
+			w.dispatch(ctx)
+		}
+	}
+}
+
+// Deliver all the due outbox messages concurrently, one webhook at a time each so a slow webhook only holds up its own
+// messages
+func (w *Webhooks) dispatch(ctx context.Context) {
+	var mu sync.Mutex
+	busy := make(map[primitive.ObjectID]bool, webhookWorkers)
+
+	// Claim a message of a webhook not being delivered to yet
+	claim := func() (*model.WebhookMessage, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		skip := make([]primitive.ObjectID, 0, len(busy))
+		for id := range busy {
+			skip = append(skip, id)
+		}
+
+		msg, err := model.WebhookClaimDue(ctx, w.DB, time.Now(), webhookLease, skip)
+		if msg != nil {
+			busy[msg.WebhookID] = true
+		}
+
+		return msg, err
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < webhookWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				msg, err := claim()
+				if err != nil {
+					w.Logger.Errorf("Failed to get the due webhook messages: %s", errorMessage(err))
+					return
+				}
+				if msg == nil {
+					return
+				}
+
+				w.deliver(ctx, msg)
+
+				mu.Lock()
+				delete(busy, msg.WebhookID)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Deliver an outbox message to its webhook, scheduling a retry with exponential backoff on failure
+func (w *Webhooks) deliver(ctx context.Context, msg *model.WebhookMessage) {
+	// Give up on the messages of deleted webhooks
+	wh, err := model.WebhookGet(ctx, w.DB, msg.WebhookID)
+	if err != nil {
+		if e, ok := err.(*internal.Error); !ok || e.Message != internal.ErrDBNoData {
+			w.Logger.Errorf("Failed to get webhook %s: %s", msg.WebhookID.Hex(), errorMessage(err))
+			return
+		}
+
+		msg.Status = model.WebhookMessageFailed
+		if err = model.WebhookMessageUpdate(ctx, w.DB, msg); err != nil {
+			w.Logger.Errorf("Failed to update webhook message %s: %s", msg.ID.Hex(), errorMessage(err))
+		}
+		return
+	}
+
+	// Send the message and log the attempt
+	d := &model.WebhookDelivery{
+		MessageID: msg.ID,
+		WebhookID: msg.WebhookID,
+		Event:     msg.Event,
+		Attempt:   msg.Attempts,
+		Time:      time.Now(),
+	}
+
+	d.StatusCode, err = w.post(ctx, wh, msg)
+	d.Duration = time.Since(d.Time).Milliseconds()
+
+	switch {
+	case err == nil && d.StatusCode >= 200 && d.StatusCode < 300:
+		msg.Status = model.WebhookMessageDelivered
+	case msg.Attempts >= webhookMaxAttempts:
+		msg.Status = model.WebhookMessageFailed
+	default:
+		msg.NextAttempt = time.Now().Add(webhookRetryDelay(msg.Attempts))
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	if err = model.WebhookDeliveryCreate(ctx, w.DB, d); err != nil {
+		w.Logger.Errorf("Failed to log the delivery of webhook message %s: %s", msg.ID.Hex(), errorMessage(err))
+	}
+	if err = model.WebhookMessageUpdate(ctx, w.DB, msg); err != nil {
+		w.Logger.Errorf("Failed to update webhook message %s: %s", msg.ID.Hex(), errorMessage(err))
+	}
+}
+
+// Send a signed outbox message to a webhook, returning the response status code
+func (w *Webhooks) post(ctx context.Context, wh *model.Webhook, msg *model.WebhookMessage) (int, error) {
+	body := []byte(msg.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("X-Webhook-ID", msg.ID.Hex())
+	req.Header.Set("X-Webhook-Event", msg.Event)
+	req.Header.Set("X-Webhook-Signature", security.SignWebhook(wh.Secret, time.Now().Unix(), body))
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// Drain the response, so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+
+	return res.StatusCode, nil
+}
+
+// Get the delay before the next delivery attempt, doubling after every failed one
+func webhookRetryDelay(attempts int) time.Duration {
+	d := webhookBackoff
+	for i := 1; i < attempts && d < webhookMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > webhookMaxBackoff {
+		d = webhookMaxBackoff
+	}
+
+	return d
+}
+
+// Publish the gate events added since the last check to the subscribed webhooks
+func (w *Webhooks) tail(ctx context.Context, t time.Time) {
+	last, err := model.CursorGet(ctx, w.DB, eventsCursor)
+	if err != nil {
+		w.Logger.Errorf("Failed to get the published events position: %s", errorMessage(err))
+		return
+	}
+
+	// Start with the events added from now on, instead of publishing all the past ones
+	if last.IsZero() {
+		id, err := model.EventGetLastID(ctx, w.DB)
+		if err != nil {
+			w.Logger.Errorf("Failed to get the last event: %s", errorMessage(err))
+			return
+		}
+		if id.IsZero() {
+			id = primitive.NewObjectIDFromTimestamp(t)
+		}
+
+		if _, err = model.CursorAdvance(ctx, w.DB, eventsCursor, primitive.NilObjectID, id); err != nil {
+			w.Logger.Errorf("Failed to set the published events position: %s", errorMessage(err))
+		}
+		return
+	}
+
+	// Events are taken in ID order, so they are expected to be inserted with increasing IDs
+	es, err := model.EventGetAfter(ctx, w.DB, last, webhookTailBatch)
+	if err != nil || len(es) == 0 {
+		if err != nil {
+			w.Logger.Errorf("Failed to get the new events: %s", errorMessage(err))
+		}
+		return
+	}
+
+	subs, err := model.WebhookGetSubscribers(ctx, w.DB, model.WebhookEventGateCrossing)
+	if err != nil {
+		w.Logger.Errorf("Failed to get the webhook subscribers: %s", errorMessage(err))
+		return
+	}
+
+	// Add the events to the outbox first, so none is lost if it fails; the messages are identified by their event, so
+	// the events published again by another server or on retry are only delivered once
+	for i := range es {
+		if err = model.WebhookEnqueueOnce(ctx, w.DB, subs, model.WebhookEventGateCrossing, es[i].ID, &es[i]); err != nil {
+			w.Logger.Errorf("Failed to publish event %s: %s", es[i].ID.Hex(), errorMessage(err))
+			return
+		}
+	}
+
+	// Then move the position, unless another server already did
+	if _, err = model.CursorAdvance(ctx, w.DB, eventsCursor, last, es[len(es)-1].ID); err != nil {
+		w.Logger.Errorf("Failed to move the published events position: %s", errorMessage(err))
+	}
+}